@@ -113,6 +113,24 @@ func StaticPanicRaceOutput() []byte {
 	return []byte(staticPanicRace)
 }
 
+// StaticAlpineCgoOutput returns a constant version of a cgo crash on Alpine
+// Linux, where the C frames have an unknown location.
+func StaticAlpineCgoOutput() []byte {
+	return []byte(staticAlpineCgo)
+}
+
+// StaticGVisorOutput returns a constant version of a snapshot indented with
+// spaces, as printed by gVisor.
+func StaticGVisorOutput() []byte {
+	return []byte(staticGVisor)
+}
+
+// StaticTraceOutput returns a constant version of a snapshot with the stacks
+// laid out like "go tool trace" prints them.
+func StaticTraceOutput() []byte {
+	return []byte(staticTrace)
+}
+
 // IsUsingModules is best guess to know if go module are enabled.
 //
 // Panics if an internal error occurs.
@@ -0,0 +1,30 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internaltest
+
+// staticAlpineCgo is a reduced snapshot of a crash in C code called via cgo,
+// from a binary built on Alpine Linux. The C library is musl without debug
+// information, so the C frames are printed as "??:0".
+const staticAlpineCgo = `fatal error: unexpected signal during runtime execution
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x7f5e3b1c9e4a]
+
+goroutine 1 [syscall]:
+crash(0x0)
+	??:0
+runtime.asmcgocall(0x49a2b0, 0xc000046f58)
+	/usr/local/go/src/runtime/asm_amd64.s:656 +0x42
+runtime.cgocall(0x49a2b0, 0xc000046f58, 0x0)
+	/usr/local/go/src/runtime/cgocall.go:133 +0x5b
+main._Cfunc_crash()
+	_cgo_gotypes.go:40 +0x41
+main.main()
+	/src/cgocrash/main.go:13 +0x25
+
+goroutine 17 [syscall, locked to thread]:
+__libc_start_main()
+	??:0 +0x7f5e3b1a2d4c
+runtime.goexit()
+	/usr/local/go/src/runtime/asm_amd64.s:1374 +0x1
+`
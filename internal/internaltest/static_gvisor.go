@@ -0,0 +1,30 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internaltest
+
+// staticGVisor is a reduced snapshot as printed by gVisor and some sandboxes,
+// which indent the stacks with spaces instead of tabs: the goroutine headers
+// are not indented, the function and "created by" lines are indented with 2
+// spaces and the file lines with 4 spaces.
+const staticGVisor = `panic: unexpected state
+
+goroutine 1 [running]:
+  main.crash(0xc000010000, 0x2)
+    /src/sandbox/main.go:20 +0x27
+  main.main()
+    /src/sandbox/main.go:12 +0x1a
+
+goroutine 6 [running]:
+    goroutine running on other thread; stack unavailable
+  created by main.main
+    /src/sandbox/main.go:10 +0x30
+
+goroutine 7 [select, 2 minutes]:
+  main.worker(0xc000012000)
+    /src/sandbox/worker.go:30 +0x27
+  ...additional frames elided...
+  created by main.main
+    /src/sandbox/main.go:11 +0x1a
+`
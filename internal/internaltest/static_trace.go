@@ -0,0 +1,23 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internaltest
+
+// staticTrace is a reduced snapshot with the stacks laid out like the
+// goroutine analysis of "go tool trace": the function lines have no
+// arguments and are suffixed with the PC, like "main.main @ 0x4a7b35", and the
+// file lines are indented with 2 tabs and have no offset. The trace tool
+// doesn't print the goroutine headers, they were added back.
+const staticTrace = `goroutine 1 [chan receive]:
+	main.main @ 0x4a7b35
+		/home/user/src/tracer/main.go:12
+
+goroutine 6 [select]:
+	main.worker @ 0x4a7a12
+		/home/user/src/tracer/worker.go:30
+	main.main.func1 @ 0x4a7c01
+		/home/user/src/tracer/main.go:9
+	runtime.goexit @ 0x46b9a1
+		/usr/local/go/src/runtime/asm_amd64.s:1650
+`
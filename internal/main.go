@@ -62,9 +62,12 @@ var defaultPalette = Palette{
 	FuncStdLib:                  ansi.Green,
 	FuncStdLibExported:          ansi.ColorCode("green+b"),
 	Arguments:                   resetFG,
+	DiffAdded:                   ansi.Green,
+	DiffRemoved:                 ansi.Red,
+	DiffMoved:                   ansi.Yellow,
 }
 
-func writeBucketsToConsole(out io.Writer, p *Palette, a *stack.Aggregated, pf pathFormat, needsEnv bool, filter, match *regexp.Regexp) error {
+func writeBucketsToConsole(out io.Writer, p *Palette, a *stack.Aggregated, pf pathFormat, needsEnv bool, filter, match *regexp.Regexp, baseline *stack.Snapshot) error {
 	if needsEnv {
 		_, _ = io.WriteString(out, "\nTo see all goroutines, visit https://github.com/maruel/panicparse#gotraceback\n\n")
 	}
@@ -79,12 +82,12 @@ func writeBucketsToConsole(out io.Writer, p *Palette, a *stack.Aggregated, pf pa
 			continue
 		}
 		_, _ = io.WriteString(out, header)
-		_, _ = io.WriteString(out, p.StackLines(&e.Signature, srcLen, pkgLen, pf))
+		_, _ = io.WriteString(out, p.stackOrDiffLines(&e.Signature, baseline, srcLen, pkgLen, pf))
 	}
 	return nil
 }
 
-func writeGoroutinesToConsole(out io.Writer, p *Palette, s *stack.Snapshot, pf pathFormat, needsEnv bool, filter, match *regexp.Regexp) error {
+func writeGoroutinesToConsole(out io.Writer, p *Palette, s *stack.Snapshot, pf pathFormat, needsEnv bool, filter, match *regexp.Regexp, baseline *stack.Snapshot) error {
 	if needsEnv {
 		_, _ = io.WriteString(out, "\nTo see all goroutines, visit https://github.com/maruel/panicparse#gotraceback\n\n")
 	}
@@ -99,7 +102,7 @@ func writeGoroutinesToConsole(out io.Writer, p *Palette, s *stack.Snapshot, pf p
 			continue
 		}
 		_, _ = io.WriteString(out, header)
-		_, _ = io.WriteString(out, p.StackLines(&e.Signature, srcLen, pkgLen, pf))
+		_, _ = io.WriteString(out, p.stackOrDiffLines(&e.Signature, baseline, srcLen, pkgLen, pf))
 	}
 	return nil
 }
@@ -124,7 +127,7 @@ func toHTML(h toHTMLer, p string, needsEnv bool) error {
 	return err
 }
 
-func processInner(out io.Writer, p *Palette, s stack.Similarity, pf pathFormat, html string, filter, match *regexp.Regexp, c *stack.Snapshot, first bool) error {
+func processInner(out io.Writer, p *Palette, s stack.Similarity, pf pathFormat, html string, filter, match *regexp.Regexp, baseline, c *stack.Snapshot, first bool) error {
 	log.Printf("GOROOT=%s", c.RemoteGOROOT)
 	log.Printf("GOPATH=%s", c.RemoteGOPATHs)
 	needsEnv := len(c.Goroutines) == 1 && showBanner()
@@ -132,21 +135,19 @@ func processInner(out io.Writer, p *Palette, s stack.Similarity, pf pathFormat,
 	if !c.IsRace() {
 		a := c.Aggregate(s)
 		if html == "" {
-			return writeBucketsToConsole(out, p, a, pf, needsEnv, filter, match)
+			return writeBucketsToConsole(out, p, a, pf, needsEnv, filter, match, baseline)
 		}
 		return toHTML(a, html, needsEnv)
 	}
 	// It's a data race.
 	if html == "" {
-		return writeGoroutinesToConsole(out, p, c, pf, needsEnv, filter, match)
+		return writeGoroutinesToConsole(out, p, c, pf, needsEnv, filter, match, baseline)
 	}
 	return toHTML(c, html, needsEnv)
 }
 
-// process copies stdin to stdout and processes any "panic: " line found.
-//
-// If html is used, a stack trace is written to this file instead.
-func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, pf pathFormat, parse, rebase bool, html string, filter, match *regexp.Regexp) error {
+// scanOpts returns the options to parse the stack dumps.
+func scanOpts(parse, rebase bool) *stack.Opts {
 	opts := stack.DefaultOpts()
 	if !rebase {
 		opts.GuessPaths = false
@@ -155,13 +156,67 @@ func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, pf pat
 	if !parse {
 		opts.AnalyzeSources = false
 	}
+	return opts
+}
+
+// loadBaseline parses the first snapshot found in the file name.
+func loadBaseline(name string, parse, rebase bool) (*stack.Snapshot, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, _, err := stack.ScanSnapshot(f, ioutil.Discard, scanOpts(parse, rebase))
+	if s == nil {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("no goroutine found in %s", name)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// baselineSignature returns the signature of the goroutine in baseline that
+// shares the most bottom frames with sig, or nil if none does.
+func baselineSignature(sig *stack.Signature, baseline *stack.Snapshot) *stack.Signature {
+	if baseline == nil {
+		return nil
+	}
+	var out *stack.Signature
+	best := 0
+	for _, g := range baseline.Goroutines {
+		if n := sig.CommonPrefix(&g.Signature); n > best {
+			best = n
+			out = &g.Signature
+		}
+	}
+	return out
+}
+
+// process copies stdin to stdout and processes any "panic: " line found.
+//
+// If html is used, a stack trace is written to this file instead. If baseline
+// is not nil, the stacks are printed as a diff against it on the console.
+func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, pf pathFormat, parse, rebase bool, html string, filter, match *regexp.Regexp, baseline *stack.Snapshot) error {
+	opts := scanOpts(parse, rebase)
 	for first := true; ; first = false {
 		c, suffix, err := stack.ScanSnapshot(in, out, opts)
 		if c != nil {
 			// Process it even if an error occurred.
-			if err1 := processInner(out, p, s, pf, html, filter, match, c, first); err == nil {
+			if err1 := processInner(out, p, s, pf, html, filter, match, baseline, c, first); err == nil {
 				err = err1
 			}
+			// The trailers were consumed as part of the snapshot, print them back.
+			if c.Race != nil && c.Race.Count != 0 {
+				if _, err1 := fmt.Fprintf(out, "Found %d data race(s)\n", c.Race.Count); err == nil {
+					err = err1
+				}
+			}
+			if c.ExitInfo != nil {
+				if _, err1 := fmt.Fprintf(out, "%s\n", c.ExitInfo); err == nil {
+					err = err1
+				}
+			}
 		}
 		if err == nil {
 			// This means the whole buffer was not read, loop again.
@@ -204,6 +259,7 @@ func Main() error {
 	relPathArg := flag.Bool("rel-path", false, "Print sources path relative to GOROOT or GOPATH; implies -rebase")
 	noColor := flag.Bool("no-color", !isatty.IsTerminal(os.Stdout.Fd()) || os.Getenv("TERM") == "dumb", "Disable coloring")
 	forceColor := flag.Bool("force-color", false, "Forcibly enable coloring when with stdout is redirected")
+	baselineFlag := flag.String("baseline", "", "Stack dump of a known good run; each stack is printed as a diff against the baseline goroutine sharing the most bottom frames")
 	// HTML only.
 	html := flag.String("html", "", "Output an HTML file")
 
@@ -311,5 +367,14 @@ func Main() error {
 		pf = relPath
 		*rebase = true
 	}
-	return process(in, out, p, s, pf, *parse, *rebase, *html, filter, match)
+	var baseline *stack.Snapshot
+	if *baselineFlag != "" {
+		if *html != "" {
+			return errors.New("can't use both -baseline and -html")
+		}
+		if baseline, err = loadBaseline(*baselineFlag, *parse, *rebase); err != nil {
+			return err
+		}
+	}
+	return process(in, out, p, s, pf, *parse, *rebase, *html, filter, match, baseline)
 }
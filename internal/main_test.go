@@ -81,7 +81,7 @@ func TestProcess(t *testing.T) {
 			t.Parallel()
 			out := bytes.Buffer{}
 			r := bytes.NewReader(internaltest.PanicOutputs()["simple"])
-			if err := process(r, &out, line.palette, line.simil, line.path, false, true, "", line.filter, line.match); err != nil {
+			if err := process(r, &out, line.palette, line.simil, line.path, false, true, "", line.filter, line.match, nil); err != nil {
 				t.Fatal(err)
 			}
 			compareString(t, line.want, out.String())
@@ -98,7 +98,7 @@ func TestProcessTwoSnapshots(t *testing.T) {
 	in.WriteString("Ye\n")
 	in.Write(internaltest.PanicOutputs()["int"])
 	in.WriteString("Yo\n")
-	err := process(&in, &out, &Palette{}, stack.AnyPointer, basePath, false, true, "", nil, nil)
+	err := process(&in, &out, &Palette{}, stack.AnyPointer, basePath, false, true, "", nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,6 +118,83 @@ func TestProcessTwoSnapshots(t *testing.T) {
 	compareString(t, want, out.String())
 }
 
+func TestProcessRaceTrailers(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"==================",
+		"WARNING: DATA RACE",
+		"Read at 0x00c000014100 by goroutine 8:",
+		"  main.read()",
+		"      /go/src/foo/main.go:137 +0x3a",
+		"",
+		"Previous write at 0x00c000014100 by goroutine 7:",
+		"  main.write()",
+		"      /go/src/foo/main.go:132 +0x41",
+		"",
+		"Goroutine 8 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:54 +0x6c8",
+		"",
+		"Goroutine 7 (finished) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:53 +0x6c8",
+		"==================",
+		"Found 1 data race(s)",
+		"exit status 66",
+		"",
+	}, "\n")
+	out := bytes.Buffer{}
+	if err := process(strings.NewReader(in), &out, &Palette{}, stack.AnyPointer, basePath, false, true, "", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The trailers consumed by the parser are printed back in order.
+	if !strings.HasSuffix(out.String(), "Found 1 data race(s)\nexit status 66\n") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestProcessBaseline(t *testing.T) {
+	t.Parallel()
+	baseline, _, err := stack.ScanSnapshot(strings.NewReader(strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.removed()",
+		"\t/gopath/src/foo/bar.go:20 +0x1",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:1472 +0x2",
+		"",
+	}, "\n")), ioutil.Discard, scanOpts(false, false))
+	if baseline == nil {
+		t.Fatal(err)
+	}
+	in := strings.Join([]string{
+		"panic: boom",
+		"",
+		"goroutine 1 [running]:",
+		"main.added()",
+		"\t/gopath/src/foo/bar.go:10 +0x1",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:1472 +0x2",
+		"",
+		"goroutine 2 [select]:",
+		"main.worker()",
+		"\t/gopath/src/foo/worker.go:5 +0x1",
+		"",
+	}, "\n")
+	out := bytes.Buffer{}
+	if err := process(strings.NewReader(in), &out, &Palette{}, stack.AnyPointer, basePath, false, false, "", nil, nil, baseline); err != nil {
+		t.Fatal(err)
+	}
+	// Goroutine 2 shares no frame with the baseline so it is printed as-is.
+	want := "panic: boom\n\n" +
+		"1: running\n" +
+		"+    main bar.go:10    added()\n" +
+		"-    main bar.go:20    removed()\n" +
+		"     main main.go:1472 main()\n" +
+		"1: select\n" +
+		"    main worker.go:5  worker()\n"
+	compareString(t, want, out.String())
+}
+
 func TestMainFn(t *testing.T) {
 	t.Parallel()
 	// It doesn't do anything since stdin is closed.
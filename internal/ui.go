@@ -38,6 +38,11 @@ type Palette struct {
 	FuncStdLib                  string
 	FuncStdLibExported          string
 	Arguments                   string
+
+	// Frame diff.
+	DiffAdded   string
+	DiffRemoved string
+	DiffMoved   string
 }
 
 // pathFormat determines how much to show.
@@ -225,3 +230,33 @@ func (p *Palette) StackLines(signature *stack.Signature, srcLen, pkgLen int, pf
 	}
 	return strings.Join(out, "\n") + "\n"
 }
+
+// DiffLines prints the frames of a diff as returned by
+// stack.Signature.DiffFrames(), one line per frame prefixed with its change.
+func (p *Palette) DiffLines(diff []stack.FrameDiff, srcLen, pkgLen int, pf pathFormat) string {
+	out := make([]string, len(diff))
+	for i := range diff {
+		d := &diff[i]
+		line := p.callLine(&d.Call, srcLen, pkgLen, pf)
+		switch d.Change {
+		case stack.FrameAdded:
+			out[i] = p.DiffAdded + "+" + line
+		case stack.FrameRemoved:
+			out[i] = p.DiffRemoved + "-" + line
+		case stack.FrameMoved:
+			out[i] = fmt.Sprintf("%s~%s %s(was line %d)%s", p.DiffMoved, line, p.DiffMoved, d.BaselineLine, p.EOLReset)
+		default:
+			out[i] = " " + line
+		}
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// stackOrDiffLines is StackLines, or DiffLines against the matching baseline
+// signature if there is one.
+func (p *Palette) stackOrDiffLines(sig *stack.Signature, baseline *stack.Snapshot, srcLen, pkgLen int, pf pathFormat) string {
+	if b := baselineSignature(sig, baseline); b != nil {
+		return p.DiffLines(sig.DiffFrames(b), srcLen, pkgLen, pf)
+	}
+	return p.StackLines(sig, srcLen, pkgLen, pf)
+}
@@ -32,6 +32,9 @@ var testPalette = &Palette{
 	FuncStdLib:                  "P",
 	FuncStdLibExported:          "Q",
 	Arguments:                   "R",
+	DiffAdded:                   "S",
+	DiffRemoved:                 "T",
+	DiffMoved:                   "U",
 }
 
 func TestCalcBucketsLengths(t *testing.T) {
@@ -100,6 +103,15 @@ func TestBucketHeader(t *testing.T) {
 		First: true,
 	}
 	compareString(t, "C0: b0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(&b, basePath, false))
+
+	b = stack.Bucket{
+		Signature: stack.Signature{
+			State:       "GC worker (idle)",
+			StateDetail: "idle",
+		},
+		IDs: []int{3},
+	}
+	compareString(t, "C1: GC worker (idle)A\n", testPalette.BucketHeader(&b, basePath, false))
 }
 
 func TestStackLines(t *testing.T) {
@@ -170,6 +182,31 @@ func TestStackLines(t *testing.T) {
 	compareString(t, want, testPalette.StackLines(s, 10, 10, basePath))
 }
 
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+	baseline := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				newCallLocal("foo.removed", stack.Args{}, "/home/user/go/src/foo/bar.go", 20),
+				newCallLocal("main.Main", stack.Args{}, "/home/user/go/src/main.go", 1472),
+			},
+		},
+	}
+	s := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				newCallLocal("foo.added", stack.Args{}, "/home/user/go/src/foo/bar.go", 10),
+				newCallLocal("main.Main", stack.Args{}, "/home/user/go/src/main.go", 1480),
+			},
+		},
+	}
+	want := "" +
+		"S+    Efoo  Fbar.go:10  LaddedR()A\n" +
+		"T-    Efoo  Fbar.go:20  LremovedR()A\n" +
+		"U~    Emain Fmain.go:1480 GMainR()A U(was line 1472)A\n"
+	compareString(t, want, testPalette.DiffLines(s.DiffFrames(baseline), 10, 4, basePath))
+}
+
 //
 
 func newFunc(s string) stack.Func {
@@ -0,0 +1,678 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// This file contains helpers to summarize a snapshot.
+
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FuncCount is the number of goroutines with a function as their first user
+// call.
+type FuncCount struct {
+	// Func is the function.
+	Func Func
+	// Count is the number of goroutines with Func as their first user call.
+	Count int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// TopFunctions returns the n most common functions found as the first user
+// call of each goroutine, with the number of goroutines for each.
+//
+// The first user call is the first call in the stack that is not in the
+// standard library. Goroutines with only standard library calls are ignored.
+//
+// Results are sorted by decreasing count, then by function name. Returns all
+// the functions found if n is 0 or less.
+func (s *Snapshot) TopFunctions(n int) []FuncCount {
+	counts := map[string]*FuncCount{}
+	for _, g := range s.Goroutines {
+		for i := range g.Stack.Calls {
+			c := &g.Stack.Calls[i]
+			if !c.isUser() {
+				continue
+			}
+			if f := counts[c.Func.Complete]; f != nil {
+				f.Count++
+			} else {
+				counts[c.Func.Complete] = &FuncCount{Func: c.Func, Count: 1}
+			}
+			break
+		}
+	}
+	out := make([]FuncCount, 0, len(counts))
+	for _, f := range counts {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Func.Complete < out[j].Func.Complete
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// BlockingAddresses returns the goroutines grouped by the address of the
+// object they are blocked on.
+//
+// Goroutines sharing an address are contending on the same mutex, channel,
+// wait group, etc. This is a heuristic: for each goroutine, the calls are
+// inspected from the leaf and the first call found in blockingCalls has its
+// first argument used as the address. Goroutines not blocked in any of these
+// calls, or where the argument is 0 or elided, are ignored.
+func (s *Snapshot) BlockingAddresses() map[uint64][]*Goroutine {
+	out := map[uint64][]*Goroutine{}
+	for _, g := range s.Goroutines {
+		for i := range g.Stack.Calls {
+			c := &g.Stack.Calls[i]
+			if _, ok := blockingCalls[c.Func.Complete]; !ok {
+				continue
+			}
+			if len(c.Args.Values) != 0 && c.Args.Values[0].Value != 0 {
+				a := c.Args.Values[0].Value
+				out[a] = append(out[a], g)
+			}
+			break
+		}
+	}
+	return out
+}
+
+// SharedBlocker returns the synchronization primitive the most goroutines
+// are blocked on, with its address and the number of goroutines blocked on it.
+//
+// It is the most likely culprit of a deadlock, like a forgotten
+// WaitGroup.Done() or Cond.Signal(). The goroutines are grouped with
+// BlockingAddresses(), so the same heuristic applies, and the kind is the
+// first LockKind() found that is not LockUnknown among the goroutines of the
+// group. On a tie, the lowest address is returned.
+//
+// Returns LockUnknown, 0, 0 if no goroutine is blocked on a known primitive.
+func (s *Snapshot) SharedBlocker() (kind LockKind, addr uint64, count int) {
+	for a, gs := range s.BlockingAddresses() {
+		if len(gs) < count || (len(gs) == count && a > addr) {
+			continue
+		}
+		addr = a
+		count = len(gs)
+		kind = LockUnknown
+		for _, g := range gs {
+			if kind = g.LockKind(); kind != LockUnknown {
+				break
+			}
+		}
+	}
+	return kind, addr, count
+}
+
+// Fingerprint returns a short identifier of the snapshot, to recognize the
+// same crash across snapshots.
+//
+// It is a hash of the state and the function names of the goroutine that
+// crashed, as returned by Crashed(), or of the first goroutine otherwise.
+// Arguments, paths and line numbers are ignored so it is stable across builds
+// and versions of the dependencies.
+//
+// Returns an empty string if there is no goroutine.
+func (s *Snapshot) Fingerprint() string {
+	g := s.Crashed()
+	if g == nil {
+		if len(s.Goroutines) == 0 {
+			return ""
+		}
+		g = s.Goroutines[0]
+	}
+	h := sha256.New()
+	_, _ = io.WriteString(h, g.State)
+	for i := range g.Stack.Calls {
+		_, _ = io.WriteString(h, "\n")
+		_, _ = io.WriteString(h, g.Stack.Calls[i].Func.Complete)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Stats is a summary of a snapshot.
+type Stats struct {
+	// NumGoroutines is the number of goroutines.
+	NumGoroutines int
+	// NumStates is the number of distinct goroutine states.
+	NumStates int
+	// MaxDepth is the number of calls in the deepest stack.
+	MaxDepth int
+	// NumStuck is the number of goroutines that were blocked for at least one
+	// minute. The runtime doesn't print shorter durations.
+	NumStuck int
+	// NumLocked is the number of goroutines locked to an OS thread.
+	NumLocked int
+	// NumElided is the number of goroutines with a stack too deep to be
+	// printed in full.
+	NumElided int
+	// NumRuntimeOnly is the number of goroutines with only standard library
+	// calls, for example the garbage collector workers.
+	NumRuntimeOnly int
+	// NumBlocked is the number of goroutines in CategoryBlocked.
+	NumBlocked int
+	// PerCategory is the number of goroutines per Category, as returned by
+	// Goroutine.StateCategory(). Categories without goroutines are omitted.
+	PerCategory map[Category]int
+	// MaxSleep is how long the goroutine blocked the longest was blocked, with
+	// a resolution of one minute.
+	MaxSleep time.Duration
+	// Truncated is true if the runtime skipped goroutines, see
+	// Snapshot.SkippedGoroutines.
+	Truncated bool
+	// NumSignatures is the number of unique signatures. It is only set by
+	// StatsSimilar().
+	NumSignatures int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Stats returns a summary of the snapshot.
+//
+// It is a single pass over the goroutines so it is cheap to call.
+func (s *Snapshot) Stats() Stats {
+	out := Stats{NumGoroutines: len(s.Goroutines)}
+	// There are usually only a handful of states, a slice is cheaper than a
+	// map.
+	var states []string
+	for _, g := range s.Goroutines {
+		found := false
+		for _, st := range states {
+			if st == g.State {
+				found = true
+				break
+			}
+		}
+		if !found {
+			states = append(states, g.State)
+		}
+		if l := len(g.Stack.Calls); l > out.MaxDepth {
+			out.MaxDepth = l
+		}
+		if g.SleepMax != 0 {
+			out.NumStuck++
+		}
+		if g.Locked {
+			out.NumLocked++
+		}
+		if g.Stack.Elided {
+			out.NumElided++
+		}
+		if !g.hasUser() {
+			out.NumRuntimeOnly++
+		}
+		c := g.StateCategory()
+		if c == CategoryBlocked {
+			out.NumBlocked++
+		}
+		if out.PerCategory == nil {
+			out.PerCategory = map[Category]int{}
+		}
+		out.PerCategory[c]++
+		if d := time.Duration(g.SleepMax) * time.Minute; d > out.MaxSleep {
+			out.MaxSleep = d
+		}
+	}
+	out.NumStates = len(states)
+	out.Truncated = s.SkippedGoroutines != 0
+	return out
+}
+
+// StatsSimilar returns the same summary as Stats() with NumSignatures set to
+// the number of buckets returned by Aggregate(similar).
+//
+// It is more expensive than Stats() since the goroutines are aggregated.
+func (s *Snapshot) StatsSimilar(similar Similarity) Stats {
+	out := s.Stats()
+	out.NumSignatures = len(s.Aggregate(similar).Buckets)
+	return out
+}
+
+// StateCount is the number of goroutines in a state.
+type StateCount struct {
+	// State is the goroutine state, including its qualifier, e.g.
+	// "chan receive (nil chan)".
+	State string
+	// Count is the number of goroutines in State.
+	Count int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// CountByState returns the number of goroutines per state.
+//
+// Results are sorted by decreasing count, then by state.
+func (s *Snapshot) CountByState() []StateCount {
+	var out []StateCount
+	for _, g := range s.Goroutines {
+		found := false
+		for i := range out {
+			if out[i].State == g.State {
+				out[i].Count++
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, StateCount{State: g.State, Count: 1})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].State < out[j].State
+	})
+	return out
+}
+
+// StuckLongerThan returns the goroutines that were blocked for at least d.
+//
+// The runtime only prints the wait duration with a minute resolution, and
+// only once a goroutine was blocked for at least one minute. So a goroutine
+// printed as blocked for 5 minutes was blocked between 5 and 6 minutes, and it
+// is returned for a d of up to 5 minutes.
+//
+// Since the goroutines blocked for less than a minute can't be told apart
+// from the ones that are not blocked at all, a d of 0 or less returns all the
+// goroutines, including the running ones. Use StateCategory() to only keep
+// the ones in a given Category.
+func (s *Snapshot) StuckLongerThan(d time.Duration) []*Goroutine {
+	var out []*Goroutine
+	for _, g := range s.Goroutines {
+		if time.Duration(g.SleepMin)*time.Minute >= d {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// Packages returns the sorted unique import paths of the functions found in
+// all the goroutines, including the calls that created them.
+func (s *Snapshot) Packages() []string {
+	counts := s.PackageCounts()
+	out := make([]string, 0, len(counts))
+	for _, p := range counts {
+		out = append(out, p.ImportPath)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// PackageCount is the number of calls in a package.
+type PackageCount struct {
+	// ImportPath is the package import path, as in Func.ImportPath.
+	ImportPath string
+	// Count is the number of calls in the package.
+	Count int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// PackageCounts returns the number of calls per package found in all the
+// goroutines, including the calls that created them.
+//
+// Results are sorted by decreasing count, then by import path.
+func (s *Snapshot) PackageCounts() []PackageCount {
+	counts := map[string]int{}
+	for _, g := range s.Goroutines {
+		for i := range g.Stack.Calls {
+			counts[g.Stack.Calls[i].Func.ImportPath]++
+		}
+		for i := range g.CreatedBy.Calls {
+			counts[g.CreatedBy.Calls[i].Func.ImportPath]++
+		}
+	}
+	out := make([]PackageCount, 0, len(counts))
+	for p, c := range counts {
+		out = append(out, PackageCount{ImportPath: p, Count: c})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].ImportPath < out[j].ImportPath
+	})
+	return out
+}
+
+// DuplicateIDs returns the sorted goroutine IDs that are found more than once.
+//
+// A goroutine dump has unique IDs, so duplicates usually mean that the output
+// of multiple dumps was interleaved. The goroutines of a race report are
+// ignored, since a goroutine can do multiple of the racing memory accesses.
+func (s *Snapshot) DuplicateIDs() []int {
+	if s.Race != nil {
+		return nil
+	}
+	seen := make(map[int]int, len(s.Goroutines))
+	var out []int
+	for _, g := range s.Goroutines {
+		seen[g.ID]++
+		if seen[g.ID] == 2 {
+			out = append(out, g.ID)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// RemoveDuplicates removes the goroutines that are exact repeats of a
+// previous goroutine, with the same ID and the same signature. The first
+// occurrence is kept.
+//
+// It is meant to clean up dumps that were accidentally captured or printed
+// twice. Contrary to Aggregate(), goroutines with different IDs are never
+// merged. Race reports are left untouched.
+//
+// Returns the number of goroutines removed.
+func (s *Snapshot) RemoveDuplicates() int {
+	if s.Race != nil {
+		return 0
+	}
+	seen := make(map[int][]*Goroutine, len(s.Goroutines))
+	out := s.Goroutines[:0]
+	removed := 0
+	for _, g := range s.Goroutines {
+		dupe := false
+		for _, o := range seen[g.ID] {
+			if o.Signature.equal(&g.Signature) {
+				dupe = true
+				break
+			}
+		}
+		if dupe {
+			removed++
+			continue
+		}
+		seen[g.ID] = append(seen[g.ID], g)
+		out = append(out, g)
+	}
+	for i := len(out); i < len(s.Goroutines); i++ {
+		s.Goroutines[i] = nil
+	}
+	s.Goroutines = out
+	return removed
+}
+
+// Filter returns a shallow copy of the snapshot with only the goroutines for
+// which pred returns true.
+//
+// The snapshot is not modified. The returned snapshot shares the goroutines
+// and the other members, like RemoteGOPATHs, with the original one.
+func (s *Snapshot) Filter(pred func(g *Goroutine) bool) *Snapshot {
+	out := *s
+	out.Goroutines = nil
+	for _, g := range s.Goroutines {
+		if pred(g) {
+			out.Goroutines = append(out.Goroutines, g)
+		}
+	}
+	return &out
+}
+
+// TrimExternal returns a shallow copy of the goroutine with only the calls in
+// packages under ownModulePrefix, like "github.com/foo/bar", and in package
+// main.
+//
+// Each run of consecutive calls removed is replaced by a single placeholder
+// call for which IsCollapsed() returns true, so it is still visible that
+// external code was called. The goroutine is not modified.
+func (g *Goroutine) TrimExternal(ownModulePrefix string) *Goroutine {
+	out := *g
+	out.Stack.Calls = nil
+	collapsed := false
+	for _, c := range g.Stack.Calls {
+		if c.Func.IsPkgMain || c.Func.ImportPath == ownModulePrefix || strings.HasPrefix(c.Func.ImportPath, ownModulePrefix+"/") {
+			out.Stack.Calls = append(out.Stack.Calls, c)
+			collapsed = false
+		} else if !collapsed {
+			out.Stack.Calls = append(out.Stack.Calls, Call{RemoteSrcPath: SrcCollapsed})
+			collapsed = true
+		}
+	}
+	return &out
+}
+
+// PartitionByState returns a shallow copy of the snapshot per goroutine
+// state, each with only the goroutines in this state, in the order they were
+// printed.
+//
+// It calls Filter() for each state, so the copies share the goroutines and
+// the other members, like RemoteGOROOT and RemoteGOPATHs, with the original
+// one. Returns an empty map if there is no goroutine.
+func (s *Snapshot) PartitionByState() map[string]*Snapshot {
+	out := map[string]*Snapshot{}
+	for _, g := range s.Goroutines {
+		if _, ok := out[g.State]; ok {
+			continue
+		}
+		state := g.State
+		out[state] = s.Filter(func(g *Goroutine) bool { return g.State == state })
+	}
+	return out
+}
+
+// SortByInterest sorts the goroutines in place so the most interesting ones
+// for triage come first.
+//
+// The goroutines are ordered by:
+//   - the goroutine that crashed, as returned by Crashed(), first;
+//   - then the goroutines with a top frame in user code, i.e. not in the
+//     standard library;
+//   - then the other goroutines with at least one call in user code;
+//   - then the goroutines with only standard library calls, like the runtime
+//     background goroutines, last.
+//
+// Within each group, the goroutines blocked for the longest time come first.
+// Ties are broken by ascending ID.
+func (s *Snapshot) SortByInterest() {
+	crashed := s.Crashed()
+	rank := func(g *Goroutine) int {
+		switch {
+		case g == crashed:
+			return 0
+		case len(g.Stack.Calls) != 0 && g.Stack.Calls[0].isUser():
+			return 1
+		case g.hasUser():
+			return 2
+		default:
+			return 3
+		}
+	}
+	sort.SliceStable(s.Goroutines, func(i, j int) bool {
+		l, r := s.Goroutines[i], s.Goroutines[j]
+		if a, b := rank(l), rank(r); a != b {
+			return a < b
+		}
+		if l.SleepMax != r.SleepMax {
+			return l.SleepMax > r.SleepMax
+		}
+		return l.ID < r.ID
+	})
+}
+
+// RootGoroutines returns the goroutines at the root of the creation tree, in
+// the order they were printed.
+//
+// A goroutine is a root when its creator is unknown, like the main goroutine
+// or goroutines printed by Go versions before 1.21 which didn't print the
+// creator goroutine ID, when its creator is not in the snapshot, for example
+// because it exited, or when it was created by the runtime as reported by
+// Goroutine.CreatedByRuntime().
+func (s *Snapshot) RootGoroutines() []*Goroutine {
+	ids := make(map[int]struct{}, len(s.Goroutines))
+	for _, g := range s.Goroutines {
+		ids[g.ID] = struct{}{}
+	}
+	var out []*Goroutine
+	for _, g := range s.Goroutines {
+		if g.CreatedByGoroutine == 0 || g.CreatedByRuntime() {
+			out = append(out, g)
+			continue
+		}
+		if _, ok := ids[g.CreatedByGoroutine]; !ok {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// Descendants returns the goroutines created by g, directly or indirectly, as
+// found with Goroutine.CreatedByGoroutine.
+//
+// The goroutines are returned breadth first: first the goroutines created by
+// g, then the goroutines they created, etc. The goroutines at the same depth
+// are in the order they were printed. g itself is never returned, even in the
+// case of a cycle in the creation tree. The goroutines created by the runtime
+// are never descendants, see RootGoroutines().
+func (s *Snapshot) Descendants(g *Goroutine) []*Goroutine {
+	children := map[int][]*Goroutine{}
+	for _, c := range s.Goroutines {
+		if c.CreatedByGoroutine != 0 && !c.CreatedByRuntime() {
+			children[c.CreatedByGoroutine] = append(children[c.CreatedByGoroutine], c)
+		}
+	}
+	// Guard against cycles, which the runtime shouldn't print.
+	seen := map[*Goroutine]struct{}{g: {}}
+	var out []*Goroutine
+	for next := []int{g.ID}; len(next) != 0; {
+		id := next[0]
+		next = next[1:]
+		for _, c := range children[id] {
+			if _, ok := seen[c]; ok {
+				continue
+			}
+			seen[c] = struct{}{}
+			out = append(out, c)
+			next = append(next, c.ID)
+		}
+	}
+	return out
+}
+
+// GoroutineNode is a goroutine in the creation tree returned by
+// Snapshot.Tree().
+type GoroutineNode struct {
+	// Goroutine is the goroutine.
+	Goroutine *Goroutine
+	// Children are the nodes of the goroutines created by Goroutine, in the
+	// order they were printed.
+	Children []*GoroutineNode
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Tree returns the creation tree of the goroutines, as found with
+// Goroutine.CreatedByGoroutine.
+//
+// The roots are the goroutines returned by RootGoroutines(), in the same
+// order. Every goroutine is in the tree exactly once. In the case of a cycle
+// in the creation tree, which the runtime shouldn't print, the first
+// goroutine of the cycle that was printed becomes an additional root, after
+// the others.
+func (s *Snapshot) Tree() []*GoroutineNode {
+	children := map[int][]*Goroutine{}
+	for _, c := range s.Goroutines {
+		if c.CreatedByGoroutine != 0 && !c.CreatedByRuntime() {
+			children[c.CreatedByGoroutine] = append(children[c.CreatedByGoroutine], c)
+		}
+	}
+	seen := make(map[*Goroutine]struct{}, len(s.Goroutines))
+	var build func(g *Goroutine) *GoroutineNode
+	build = func(g *Goroutine) *GoroutineNode {
+		seen[g] = struct{}{}
+		n := &GoroutineNode{Goroutine: g}
+		for _, c := range children[g.ID] {
+			if _, ok := seen[c]; !ok {
+				n.Children = append(n.Children, build(c))
+			}
+		}
+		return n
+	}
+	var out []*GoroutineNode
+	for _, g := range append(s.RootGoroutines(), s.Goroutines...) {
+		if _, ok := seen[g]; !ok {
+			out = append(out, build(g))
+		}
+	}
+	return out
+}
+
+// Private stuff.
+
+// blockingCalls are the functions where a goroutine blocks on a
+// synchronization object that is passed as the first argument.
+//
+// For runtime functions, it is the channel or the semaphore. For sync methods,
+// it is the receiver.
+var blockingCalls = map[string]struct{}{
+	"runtime.chanrecv":                {},
+	"runtime.chanrecv1":               {},
+	"runtime.chanrecv2":               {},
+	"runtime.chansend":                {},
+	"runtime.chansend1":               {},
+	"sync.runtime_Semacquire":         {},
+	"sync.runtime_SemacquireMutex":    {},
+	"sync.runtime_SemacquireRWMutex":  {},
+	"sync.runtime_SemacquireRWMutexR": {},
+	"sync.runtime_notifyListWait":     {},
+	"sync.(*Mutex).Lock":              {},
+	"sync.(*Mutex).lockSlow":          {},
+	"sync.(*RWMutex).Lock":            {},
+	"sync.(*RWMutex).RLock":           {},
+	"sync.(*WaitGroup).Wait":          {},
+	"sync.(*Cond).Wait":               {},
+}
+
+// hasUser returns true if at least one call is not in the standard library.
+func (s *Signature) hasUser() bool {
+	for i := range s.Stack.Calls {
+		if s.Stack.Calls[i].isUser() {
+			return true
+		}
+	}
+	return false
+}
+
+// isUser returns true if the call is not in the standard library.
+//
+// When Opts.GuessPaths was false, Location is usually unknown so it falls back
+// to the import path: the first path element of third party packages contains
+// a dot, like "github.com".
+func (c *Call) isUser() bool {
+	switch c.Location {
+	case Stdlib:
+		return false
+	case LocationUnknown:
+		if c.Func.IsPkgMain {
+			return true
+		}
+		p := c.Func.ImportPath
+		if i := strings.IndexByte(p, '/'); i != -1 {
+			p = p[:i]
+		}
+		return strings.IndexByte(p, '.') != -1
+	}
+	return true
+}
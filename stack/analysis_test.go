@@ -0,0 +1,1023 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTopFunctions(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [semacquire]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"sync.(*WaitGroup).Wait()",
+		"\t/goroot/src/sync/waitgroup.go:130 +0x65",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"",
+		"goroutine 2 [chan receive]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:12 +0x30",
+		"",
+		"goroutine 3 [select]:",
+		"github.com/foo/bar.Serve()",
+		"\t/gopath/src/github.com/foo/bar/serve.go:20 +0x30",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 4 [IO wait]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"net/http.(*conn).serve()",
+		"\t/goroot/src/net/http/server.go:1925 +0x8ad",
+		"",
+		"goroutine 5 [running]:",
+		"github.com/foo/bar.(*T).Get()",
+		"\t/gopath/src/github.com/foo/bar/t.go:30 +0x30",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:22 +0x30",
+		"",
+		"goroutine 6 [runnable]:",
+		"github.com/foo/bar.(*T).Get()",
+		"\t/gopath/src/github.com/foo/bar/t.go:30 +0x30",
+		"",
+	}
+	data := []struct {
+		name string
+		in   []string
+		n    int
+		want []string
+	}{
+		// The goroutine with only standard library calls is ignored.
+		{"All", in, 0, []string{"github.com/foo/bar.(*T).Get: 2", "main.worker: 2", "github.com/foo/bar.Serve: 1"}},
+		{"Limit", in, 2, []string{"github.com/foo/bar.(*T).Get: 2", "main.worker: 2"}},
+		{"Large", in, 10, []string{"github.com/foo/bar.(*T).Get: 2", "main.worker: 2", "github.com/foo/bar.Serve: 1"}},
+		{
+			"StdlibOnly",
+			[]string{
+				"goroutine 4 [IO wait]:",
+				"runtime.gopark()",
+				"\t/goroot/src/runtime/proc.go:307 +0xce",
+				"",
+			},
+			0,
+			nil,
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			var got []string
+			for _, f := range s.TopFunctions(line.n) {
+				got = append(got, fmt.Sprintf("%s: %d", f.Func.Complete, f.Count))
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("TopFunctions(%d) mismatch (-want +got):\n%s", line.n, diff)
+			}
+		})
+	}
+}
+
+func TestCallIsUser(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		c    Call
+		want bool
+	}{
+		{newCall("main.main", Args{}, "", 0), true},
+		{newCall("github.com/foo/bar.Baz", Args{}, "", 0), true},
+		{newCall("runtime.gopark", Args{}, "", 0), false},
+		{newCall("net/http.Serve", Args{}, "", 0), false},
+		{Call{Func: newFunc("github.com/foo/bar.Baz"), Location: Stdlib}, false},
+		{Call{Func: newFunc("net/http.Serve"), Location: GoMod}, true},
+	}
+	for i, line := range data {
+		if got := line.c.isUser(); got != line.want {
+			t.Errorf("#%d: isUser(%q) = %t", i, line.c.Func.Complete, got)
+		}
+	}
+}
+
+func TestBlockingAddresses(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+		want map[uint64][]int
+	}{
+		{
+			"Mutex",
+			[]string{
+				"goroutine 1 [sync.Mutex.Lock]:",
+				"sync.runtime_SemacquireMutex(0xc000020000, 0x0, 0x1)",
+				"\t/goroot/src/runtime/sema.go:71 +0x47",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"",
+				"goroutine 2 [sync.Mutex.Lock]:",
+				"sync.runtime_SemacquireMutex(0xc000020000, 0x0, 0x1)",
+				"\t/goroot/src/runtime/sema.go:71 +0x47",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			},
+			map[uint64][]int{0xc000020000: {1, 2}},
+		},
+		{
+			"Channel",
+			[]string{
+				"goroutine 3 [chan receive]:",
+				"runtime.chanrecv1(0xc000030000, 0x0)",
+				"\t/goroot/src/runtime/chan.go:442 +0x2b",
+				"main.reader()",
+				"\t/gopath/src/foo/main.go:30 +0x30",
+				"",
+				"goroutine 4 [chan send]:",
+				"runtime.chansend1(0xc000030000, 0xc000040000)",
+				"\t/goroot/src/runtime/chan.go:145 +0x2b",
+				"main.writer()",
+				"\t/gopath/src/foo/main.go:40 +0x30",
+				"",
+			},
+			map[uint64][]int{0xc000030000: {3, 4}},
+		},
+		{
+			"WaitGroup",
+			[]string{
+				"goroutine 5 [semacquire]:",
+				"sync.runtime_Semacquire(0xc000040008)",
+				"\t/goroot/src/runtime/sema.go:56 +0x45",
+				"sync.(*WaitGroup).Wait(0xc000040000)",
+				"\t/goroot/src/sync/waitgroup.go:130 +0x65",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:50 +0x30",
+				"",
+			},
+			// The leaf call is used.
+			map[uint64][]int{0xc000040008: {5}},
+		},
+		{
+			"NotBlocking",
+			[]string{
+				"goroutine 6 [running]:",
+				"main.worker(0xc000020000)",
+				"\t/gopath/src/foo/main.go:60 +0x30",
+				"",
+			},
+			map[uint64][]int{},
+		},
+		{
+			"MissingAddress",
+			[]string{
+				"goroutine 7 [chan receive]:",
+				"runtime.chanrecv1(0x0, 0x0)",
+				"\t/goroot/src/runtime/chan.go:442 +0x2b",
+				"main.reader()",
+				"\t/gopath/src/foo/main.go:30 +0x30",
+				"",
+			},
+			map[uint64][]int{},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			got := map[uint64][]int{}
+			for a, gs := range s.BlockingAddresses() {
+				for _, g := range gs {
+					got[a] = append(got[a], g.ID)
+				}
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("BlockingAddresses() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSharedBlocker(t *testing.T) {
+	t.Parallel()
+	// A forgotten wg.Done().
+	in := strings.Join([]string{
+		"fatal error: all goroutines are asleep - deadlock!",
+		"",
+		"goroutine 1 [semacquire]:",
+		"sync.runtime_Semacquire(0xc00001c0b8)",
+		"\t/goroot/src/runtime/sema.go:56 +0x45",
+		"sync.(*WaitGroup).Wait(0xc00001c0b0)",
+		"\t/goroot/src/sync/waitgroup.go:130 +0x65",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:20 +0x8d",
+		"",
+		"goroutine 6 [semacquire]:",
+		"sync.runtime_Semacquire(0xc00001c0b8)",
+		"\t/goroot/src/runtime/sema.go:56 +0x45",
+		"sync.(*WaitGroup).Wait(0xc00001c0b0)",
+		"\t/goroot/src/sync/waitgroup.go:130 +0x65",
+		"main.waiter(0xc00001c0b0)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:17 +0x5a",
+		"",
+		"goroutine 7 [chan receive]:",
+		"runtime.chanrecv1(0xc000020060, 0x0)",
+		"\t/goroot/src/runtime/chan.go:442 +0x2b",
+		"main.reader(0xc000020060)",
+		"\t/gopath/src/foo/main.go:30 +0x30",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:18 +0x6a",
+		"",
+	}, "\n")
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	kind, addr, count := s.SharedBlocker()
+	if kind != LockWaitGroup || addr != 0xc00001c0b8 || count != 2 {
+		t.Fatalf("SharedBlocker() = %s, 0x%x, %d", kind, addr, count)
+	}
+
+	kind, addr, count = (&Snapshot{}).SharedBlocker()
+	if kind != LockUnknown || addr != 0 || count != 0 {
+		t.Fatalf("SharedBlocker() = %s, 0x%x, %d", kind, addr, count)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+	ref := []string{
+		"panic: boom",
+		"",
+		"goroutine 1 [running]:",
+		"main.crash(0x0)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(ref, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	a := s.Fingerprint()
+	if len(a) != 16 {
+		t.Fatalf("unexpected fingerprint %q", a)
+	}
+	data := []struct {
+		name string
+		in   []string
+		same bool
+	}{
+		{
+			"OtherBuild",
+			[]string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.crash(0x2a)",
+				"\t/home/user/src/foo/main.go:42 +0x45",
+				"main.main()",
+				"\t/home/user/src/foo/main.go:21 +0x30",
+				"",
+			},
+			true,
+		},
+		{
+			"OtherStack",
+			[]string{
+				"panic: boom",
+				"",
+				"goroutine 1 [running]:",
+				"main.other(0x0)",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			},
+			false,
+		},
+		{
+			"OtherState",
+			[]string{
+				"goroutine 1 [chan receive]:",
+				"main.crash(0x0)",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			},
+			false,
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			if got := s.Fingerprint(); (got == a) != line.same {
+				t.Fatalf("Fingerprint() = %q, reference %q", got, a)
+			}
+		})
+	}
+	compareString(t, "", (&Snapshot{}).Fingerprint())
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+		want Stats
+	}{
+		{
+			"Mixed",
+			[]string{
+				"goroutine 1 [running]:",
+				"main.crash()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+				"goroutine 2 [chan receive, 10 minutes]:",
+				"runtime.gopark()",
+				"\t/goroot/src/runtime/proc.go:307 +0xce",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:30 +0x30",
+				"",
+				"goroutine 3 [chan receive, 20 minutes, locked to thread]:",
+				"runtime.gopark()",
+				"\t/goroot/src/runtime/proc.go:307 +0xce",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:30 +0x30",
+				"",
+				"goroutine 4 [GC worker]:",
+				"runtime.gopark()",
+				"\t/goroot/src/runtime/proc.go:307 +0xce",
+				"runtime.gcBgMarkWorker()",
+				"\t/goroot/src/runtime/mgc.go:1891 +0x105",
+				"runtime.goexit()",
+				"\t/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+				"",
+				"goroutine 5 [running]:",
+				"main.recurse()",
+				"\t/gopath/src/foo/main.go:40 +0x30",
+				"...additional frames elided...",
+				"",
+			},
+			Stats{
+				NumGoroutines:  5,
+				NumStates:      3,
+				MaxDepth:       3,
+				NumStuck:       2,
+				NumLocked:      1,
+				NumElided:      1,
+				NumRuntimeOnly: 1,
+				NumBlocked:     2,
+				PerCategory:    map[Category]int{CategoryRunning: 2, CategoryBlocked: 2, CategoryGC: 1},
+				MaxSleep:       20 * time.Minute,
+				// The two "chan receive" goroutines are similar.
+				NumSignatures: 4,
+			},
+		},
+		{
+			"Truncated",
+			[]string{
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+				"...10 goroutines, skipping...",
+				"",
+			},
+			Stats{
+				NumGoroutines: 1,
+				NumStates:     1,
+				MaxDepth:      1,
+				PerCategory:   map[Category]int{CategoryRunning: 1},
+				Truncated:     true,
+				NumSignatures: 1,
+			},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			if diff := cmp.Diff(line.want, s.StatsSimilar(AnyValue)); diff != "" {
+				t.Fatalf("StatsSimilar() mismatch (-want +got):\n%s", diff)
+			}
+			// Stats() doesn't aggregate the goroutines.
+			want := line.want
+			want.NumSignatures = 0
+			if diff := cmp.Diff(want, s.Stats()); diff != "" {
+				t.Fatalf("Stats() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if diff := cmp.Diff(Stats{}, (&Snapshot{}).Stats()); diff != "" {
+		t.Fatalf("Stats() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCountByState(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{}
+	for _, state := range []string{"IO wait", "chan receive", "IO wait", "running", "select", "IO wait", "chan receive", "chan receive (nil chan)"} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{Signature: Signature{State: state}})
+	}
+	// The qualifier is part of the state.
+	want := []StateCount{
+		{State: "IO wait", Count: 3},
+		{State: "chan receive", Count: 2},
+		{State: "chan receive (nil chan)", Count: 1},
+		{State: "running", Count: 1},
+		{State: "select", Count: 1},
+	}
+	if diff := cmp.Diff(want, s.CountByState()); diff != "" {
+		t.Fatalf("CountByState() mismatch (-want +got):\n%s", diff)
+	}
+	if got := (&Snapshot{}).CountByState(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
+
+func TestStuckLongerThan(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{}
+	for i, m := range []int{0, 1, 5, 10} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{Signature: Signature{State: "chan receive", SleepMin: m, SleepMax: m}, ID: i + 1})
+	}
+	s.Goroutines[0].State = "running"
+	data := []struct {
+		d    time.Duration
+		want []int
+	}{
+		// The running goroutine is returned too.
+		{0, []int{1, 2, 3, 4}},
+		{30 * time.Second, []int{2, 3, 4}},
+		{time.Minute, []int{2, 3, 4}},
+		{5 * time.Minute, []int{3, 4}},
+		{5*time.Minute + time.Second, []int{4}},
+		{time.Hour, nil},
+	}
+	for i, line := range data {
+		var got []int
+		for _, g := range s.StuckLongerThan(line.d) {
+			got = append(got, g.ID)
+		}
+		if diff := cmp.Diff(line.want, got); diff != "" {
+			t.Errorf("#%d: StuckLongerThan(%s) mismatch (-want +got):\n%s", i, line.d, diff)
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{
+				Signature: Signature{
+					Stack: Stack{
+						Calls: []Call{
+							newCall("sync.(*Mutex).Lock", Args{}, "/goroot/src/sync/mutex.go", 72),
+							newCall("github.com/foo/bar.Baz", Args{}, "/gopath/src/github.com/foo/bar/bar.go", 12),
+							newCall("main.main", Args{}, "/gopath/src/foo/main.go", 10),
+						},
+					},
+				},
+				ID: 1,
+			},
+			{
+				Signature: Signature{
+					CreatedBy: Stack{
+						Calls: []Call{newCall("main.main", Args{}, "/gopath/src/foo/main.go", 11)},
+					},
+					Stack: Stack{
+						Calls: []Call{
+							newCall("sync.runtime_Semacquire", Args{}, "/goroot/src/runtime/sema.go", 56),
+							newCall("sync.(*WaitGroup).Wait", Args{}, "/goroot/src/sync/waitgroup.go", 130),
+							newCall("github.com/foo/bar.Wait", Args{}, "/gopath/src/github.com/foo/bar/bar.go", 20),
+						},
+					},
+				},
+				ID: 2,
+			},
+		},
+	}
+	want := []string{"github.com/foo/bar", "main", "sync"}
+	if diff := cmp.Diff(want, s.Packages()); diff != "" {
+		t.Fatalf("Packages() mismatch (-want +got):\n%s", diff)
+	}
+	wantCounts := []PackageCount{
+		{ImportPath: "sync", Count: 3},
+		{ImportPath: "github.com/foo/bar", Count: 2},
+		{ImportPath: "main", Count: 2},
+	}
+	if diff := cmp.Diff(wantCounts, s.PackageCounts()); diff != "" {
+		t.Fatalf("PackageCounts() mismatch (-want +got):\n%s", diff)
+	}
+	if got := (&Snapshot{}).Packages(); len(got) != 0 {
+		t.Fatalf("expected empty, got %v", got)
+	}
+}
+
+func TestDuplicateIDs(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{}
+	for _, id := range []int{1, 7, 3, 7, 1, 7, 9} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{ID: id})
+	}
+	if diff := cmp.Diff([]int{1, 7}, s.DuplicateIDs()); diff != "" {
+		t.Fatalf("DuplicateIDs() mismatch (-want +got):\n%s", diff)
+	}
+	s.Goroutines = s.Goroutines[1:3]
+	if got := s.DuplicateIDs(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+	// A goroutine can appear multiple times in a race report.
+	s = &Snapshot{
+		Goroutines: []*Goroutine{{ID: 8, RaceAddr: 0x10}, {ID: 8, RaceWrite: true, RaceAddr: 0x10}},
+		Race:       &RaceReport{},
+	}
+	if got := s.DuplicateIDs(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name    string
+		in      []string
+		removed int
+		want    []string
+	}{
+		{
+			"Repeated",
+			[]string{
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"",
+				"goroutine 2 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"",
+				"goroutine 2 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			},
+			2,
+			[]string{"1: main.go:10", "2: main.go:20"},
+		},
+		{
+			"SameIDDifferentStack",
+			[]string{
+				"goroutine 2 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+				"goroutine 2 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:21 +0x30",
+				"",
+			},
+			0,
+			[]string{"2: main.go:20", "2: main.go:21"},
+		},
+		{
+			"SameStackDifferentID",
+			[]string{
+				"goroutine 2 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+				"goroutine 3 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			},
+			0,
+			[]string{"2: main.go:20", "3: main.go:20"},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			if got := s.RemoveDuplicates(); got != line.removed {
+				t.Fatalf("want %d removed, got %d", line.removed, got)
+			}
+			var got []string
+			for _, g := range s.Goroutines {
+				got = append(got, fmt.Sprintf("%d: %s:%d", g.ID, g.Stack.Calls[0].SrcName, g.Stack.Calls[0].Line))
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+			}
+			// It is idempotent.
+			if got := s.RemoveDuplicates(); got != 0 {
+				t.Fatalf("want 0 removed, got %d", got)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+	s := newStatesSnapshot()
+	orig := append([]*Goroutine{}, s.Goroutines...)
+	f := s.Filter(func(g *Goroutine) bool { return g.State == "chan receive" })
+	if f == s {
+		t.Fatal("expected a copy")
+	}
+	if diff := cmp.Diff([]*Goroutine{orig[1], orig[3]}, f.Goroutines); diff != "" {
+		t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+	}
+	compareString(t, "/goroot", f.RemoteGOROOT)
+	if diff := cmp.Diff(s.RemoteGOPATHs, f.RemoteGOPATHs); diff != "" {
+		t.Fatalf("RemoteGOPATHs mismatch (-want +got):\n%s", diff)
+	}
+	// The original is not modified.
+	if diff := cmp.Diff(orig, s.Goroutines); diff != "" {
+		t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.Filter(func(g *Goroutine) bool { return false }).Goroutines; got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+}
+
+func TestGoroutine_TrimExternal(t *testing.T) {
+	t.Parallel()
+	g := &Goroutine{
+		Signature: Signature{
+			State: "running",
+			Stack: Stack{
+				Calls: []Call{
+					newCall("github.com/foo/bar/db.(*Conn).Query", Args{}, "/gopath/src/github.com/foo/bar/db/conn.go", 10),
+					newCall("database/sql.(*DB).Query", Args{}, "/goroot/src/database/sql/sql.go", 1500),
+					newCall("github.com/foo/bar.handle", Args{}, "/gopath/src/github.com/foo/bar/handle.go", 20),
+					newCall("github.com/gorilla/mux.(*Router).ServeHTTP", Args{}, "/gopath/pkg/mod/github.com/gorilla/mux@v1.8.0/mux.go", 210),
+					newCall("net/http.serverHandler.ServeHTTP", Args{}, "/goroot/src/net/http/server.go", 2887),
+					newCall("github.com/foo/barbaz.Serve", Args{}, "/gopath/src/github.com/foo/barbaz/serve.go", 30),
+					newCall("main.main", Args{}, "/gopath/src/github.com/foo/bar/cmd/main.go", 40),
+				},
+			},
+		},
+		ID: 1,
+	}
+	orig := g.Stack.Calls
+	got := g.TrimExternal("github.com/foo/bar")
+	want := []Call{
+		orig[0],
+		{RemoteSrcPath: SrcCollapsed},
+		orig[2],
+		{RemoteSrcPath: SrcCollapsed},
+		orig[6],
+	}
+	if diff := cmp.Diff(want, got.Stack.Calls); diff != "" {
+		t.Fatalf("TrimExternal() mismatch (-want +got):\n%s", diff)
+	}
+	if !got.Stack.Calls[1].IsCollapsed() || got.Stack.Calls[0].IsCollapsed() {
+		t.Fatal("unexpected IsCollapsed()")
+	}
+	if got.ID != 1 || got.State != "running" {
+		t.Fatalf("unexpected goroutine %v", got)
+	}
+	// The original goroutine is not modified.
+	if len(g.Stack.Calls) != 7 || g.Stack.Calls[1].Func.Complete != "database/sql.(*DB).Query" {
+		t.Fatalf("goroutine was modified: %v", g.Stack.Calls)
+	}
+	// Everything is external.
+	got = g.TrimExternal("github.com/other")
+	if diff := cmp.Diff([]Call{{RemoteSrcPath: SrcCollapsed}, orig[6]}, got.Stack.Calls); diff != "" {
+		t.Fatalf("TrimExternal() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPartitionByState(t *testing.T) {
+	t.Parallel()
+	s := newStatesSnapshot()
+	orig := append([]*Goroutine{}, s.Goroutines...)
+	p := s.PartitionByState()
+	want := map[string][]*Goroutine{
+		"running":      {orig[0]},
+		"chan receive": {orig[1], orig[3]},
+		"IO wait":      {orig[2]},
+	}
+	got := map[string][]*Goroutine{}
+	for state, c := range p {
+		if c == s {
+			t.Fatal("expected a copy")
+		}
+		compareString(t, "/goroot", c.RemoteGOROOT)
+		if diff := cmp.Diff(s.RemoteGOPATHs, c.RemoteGOPATHs); diff != "" {
+			t.Fatalf("RemoteGOPATHs mismatch (-want +got):\n%s", diff)
+		}
+		got[state] = c.Goroutines
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("PartitionByState() mismatch (-want +got):\n%s", diff)
+	}
+	// The original is not modified.
+	if diff := cmp.Diff(orig, s.Goroutines); diff != "" {
+		t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+	}
+	if got := (&Snapshot{}).PartitionByState(); len(got) != 0 {
+		t.Fatalf("expected empty map, got %v", got)
+	}
+}
+
+func TestSortByInterest(t *testing.T) {
+	t.Parallel()
+	// The goroutines blocked in user code, the ones blocked in the standard
+	// library from user code and the runtime ones.
+	goroutines := []string{
+		"goroutine 2 [GC worker (idle)]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"runtime.gcBgMarkWorker()",
+		"\t/goroot/src/runtime/mgc.go:1891 +0x105",
+		"",
+		"goroutine 8 [chan receive]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"runtime.chanrecv1()",
+		"\t/goroot/src/runtime/chan.go:442 +0x2b",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x30",
+		"",
+		"goroutine 7 [select]:",
+		"github.com/foo/bar.Run()",
+		"\t/gopath/src/github.com/foo/bar/run.go:20 +0x30",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 5 [chan receive]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"runtime.chanrecv1()",
+		"\t/goroot/src/runtime/chan.go:442 +0x2b",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x30",
+		"",
+		"goroutine 9 [select, 2 minutes]:",
+		"github.com/foo/bar.Run()",
+		"\t/gopath/src/github.com/foo/bar/run.go:20 +0x30",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 1 [chan receive, 10 minutes]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"runtime.chanrecv1()",
+		"\t/goroot/src/runtime/chan.go:442 +0x2b",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x30",
+		"",
+		"goroutine 4 [force gc (idle)]:",
+		"runtime.gopark()",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"runtime.forcegchelper()",
+		"\t/goroot/src/runtime/proc.go:255 +0xb8",
+		"",
+	}
+	data := []struct {
+		name string
+		in   []string
+		want []int
+	}{
+		{
+			"Crash",
+			append([]string{
+				"panic: boom",
+				"",
+				"goroutine 3 [running]:",
+				"main.crash()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			}, goroutines...),
+			[]int{3, 9, 7, 1, 5, 8, 2, 4},
+		},
+		{
+			// Without a panic header, no goroutine crashed.
+			"SIGQUIT",
+			append([]string{
+				"SIGQUIT: quit",
+				"PC=0x46c4e1 m=0 sigcode=0",
+				"",
+				"goroutine 3 [running]:",
+				"main.crash()",
+				"\t/gopath/src/foo/main.go:10 +0x30",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:20 +0x30",
+				"",
+			}, goroutines...),
+			[]int{9, 3, 7, 1, 5, 8, 2, 4},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			s.SortByInterest()
+			var got []int
+			for _, g := range s.Goroutines {
+				got = append(got, g.ID)
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("SortByInterest() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	(&Snapshot{}).SortByInterest()
+}
+
+func TestRootGoroutines(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{}
+	// ID: creator ID.
+	for _, g := range [][2]int{{1, 0}, {5, 1}, {6, 5}, {7, 42}, {2, 0}, {8, 7}} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{ID: g[0], CreatedByGoroutine: g[1]})
+	}
+	var got []int
+	for _, g := range s.RootGoroutines() {
+		got = append(got, g.ID)
+	}
+	// Goroutine 7 was created by goroutine 42 which is not in the snapshot.
+	if diff := cmp.Diff([]int{1, 7, 2}, got); diff != "" {
+		t.Fatalf("RootGoroutines() mismatch (-want +got):\n%s", diff)
+	}
+	if got := (&Snapshot{}).RootGoroutines(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+
+	// A goroutine created by the runtime is a root, even if the creator
+	// goroutine is printed.
+	s.Goroutines = append(s.Goroutines, &Goroutine{
+		Signature: Signature{
+			CreatedBy: Stack{Calls: []Call{newCall("runtime.gcenable", Args{}, "/goroot/src/runtime/mgc.go", 200)}},
+		},
+		ID:                 3,
+		CreatedByGoroutine: 1,
+	})
+	got = nil
+	for _, g := range s.RootGoroutines() {
+		got = append(got, g.ID)
+	}
+	if diff := cmp.Diff([]int{1, 7, 2, 3}, got); diff != "" {
+		t.Fatalf("RootGoroutines() mismatch (-want +got):\n%s", diff)
+	}
+	if len(s.Descendants(s.Goroutines[0])) != 2 {
+		t.Fatal("expected goroutine 3 not to be a descendant of goroutine 1")
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{}
+	// ID: creator ID.
+	for _, g := range [][2]int{{1, 0}, {5, 1}, {6, 5}, {7, 1}, {2, 0}, {8, 6}, {9, 2}} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{ID: g[0], CreatedByGoroutine: g[1]})
+	}
+	ids := func(gs []*Goroutine) []int {
+		var out []int
+		for _, g := range gs {
+			out = append(out, g.ID)
+		}
+		return out
+	}
+	if diff := cmp.Diff([]int{5, 7, 6, 8}, ids(s.Descendants(s.Goroutines[0]))); diff != "" {
+		t.Fatalf("Descendants() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{8}, ids(s.Descendants(s.Goroutines[2]))); diff != "" {
+		t.Fatalf("Descendants() mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.Descendants(s.Goroutines[6]); got != nil {
+		t.Fatalf("expected nil, got %v", ids(got))
+	}
+
+	// A cycle terminates and doesn't include the goroutine itself.
+	s = &Snapshot{
+		Goroutines: []*Goroutine{
+			{ID: 1, CreatedByGoroutine: 2},
+			{ID: 2, CreatedByGoroutine: 1},
+			{ID: 3, CreatedByGoroutine: 3},
+		},
+	}
+	if diff := cmp.Diff([]int{2}, ids(s.Descendants(s.Goroutines[0]))); diff != "" {
+		t.Fatalf("Descendants() mismatch (-want +got):\n%s", diff)
+	}
+	if got := s.Descendants(s.Goroutines[2]); got != nil {
+		t.Fatalf("expected nil, got %v", ids(got))
+	}
+}
+
+func TestTree(t *testing.T) {
+	t.Parallel()
+	// format returns the tree as "id(children),id".
+	var format func(nodes []*GoroutineNode) string
+	format = func(nodes []*GoroutineNode) string {
+		var out []string
+		for _, n := range nodes {
+			v := strconv.Itoa(n.Goroutine.ID)
+			if len(n.Children) != 0 {
+				v += "(" + format(n.Children) + ")"
+			}
+			out = append(out, v)
+		}
+		return strings.Join(out, ",")
+	}
+	s := &Snapshot{}
+	// ID: creator ID.
+	for _, g := range [][2]int{{1, 0}, {5, 1}, {6, 5}, {7, 1}, {2, 0}, {8, 6}, {9, 2}, {10, 42}} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{ID: g[0], CreatedByGoroutine: g[1]})
+	}
+	// Goroutine 10 was created by goroutine 42 which is not in the snapshot.
+	compareString(t, "1(5(6(8)),7),2(9),10", format(s.Tree()))
+	if got := (&Snapshot{}).Tree(); got != nil {
+		t.Fatalf("expected nil, got %v", got)
+	}
+
+	// A cycle doesn't lose goroutines.
+	s = &Snapshot{
+		Goroutines: []*Goroutine{
+			{ID: 1, CreatedByGoroutine: 2},
+			{ID: 2, CreatedByGoroutine: 1},
+			{ID: 3, CreatedByGoroutine: 3},
+			{ID: 4},
+			{ID: 5, CreatedByGoroutine: 2},
+		},
+	}
+	compareString(t, "4,1(2(5)),3", format(s.Tree()))
+}
+
+//
+
+// newStatesSnapshot returns a snapshot with goroutines 1 to 4 in the states
+// "running", "chan receive", "IO wait" and "chan receive".
+func newStatesSnapshot() *Snapshot {
+	s := &Snapshot{
+		RemoteGOROOT:  "/goroot",
+		RemoteGOPATHs: map[string]string{"/gopath": "/home/user/go"},
+	}
+	for i, state := range []string{"running", "chan receive", "IO wait", "chan receive"} {
+		s.Goroutines = append(s.Goroutines, &Goroutine{Signature: Signature{State: state}, ID: i + 1})
+	}
+	return s
+}
@@ -5,11 +5,25 @@
 package stack
 
 import (
+	"io"
 	"sort"
 )
 
 // Similarity is the level at which two call lines arguments must match to be
 // considered similar enough to coalesce them.
+//
+// It is one of the levels ExactFlags, ExactLines, AnyPointer or AnyValue,
+// optionally combined with the flags BasenameOnly, AnyModuleVersion, AnyLine
+// and AnyClosure, e.g. AnyPointer|BasenameOnly.
+//
+// Each flag relaxes an independent part of the call comparison, so they can be
+// mixed freely: the level applies to the arguments, BasenameOnly to the
+// directory of the source file, AnyModuleVersion to the module version in the
+// directory, AnyLine to the line number and AnyClosure to the numbering of
+// generated functions. The function name must otherwise
+// always match. For example, with BasenameOnly|AnyLine, two calls to
+// the same function in files with the same name are similar, whatever their
+// directory and line.
 type Similarity int
 
 const (
@@ -23,6 +37,40 @@ const (
 	AnyValue
 )
 
+const (
+	// BasenameOnly is a flag to compare only the file name of the source files,
+	// ignoring the directory. This is useful to aggregate goroutines from
+	// binaries built in different directories.
+	//
+	// The path of the first goroutine is kept in the bucket.
+	BasenameOnly Similarity = 1 << (iota + 8)
+	// AnyLine is a flag to ignore the line number in the source files.
+	//
+	// The line of the first goroutine is kept in the bucket.
+	AnyLine
+	// AnyClosure is a flag to ignore the numbering of the closures and wrappers
+	// generated by the compiler, as reported by Func.IsGenerated(). This is
+	// useful to aggregate goroutines from binaries built with different Go
+	// versions, since the numbering can change between them.
+	//
+	// Since only the numbering tells them apart, the closures of the same
+	// function at the same nesting depth are merged, e.g. "main.main.func1"
+	// and "main.main.func2". The name of the first goroutine is kept in the
+	// bucket.
+	AnyClosure
+	// AnyModuleVersion is a flag to ignore the version of the go modules in
+	// the source paths in the module cache, e.g. "@v1.2.3" in
+	// "/gopath/pkg/mod/github.com/foo/bar@v1.2.3/baz.go". This is useful to
+	// aggregate the same bug across versions of a dependency, usually combined
+	// with AnyLine.
+	//
+	// The path of the first goroutine is kept in the bucket.
+	AnyModuleVersion
+
+	// similarityLevels is the mask of the levels.
+	similarityLevels Similarity = 0xff
+)
+
 // Aggregated is a list of Bucket sorted by repetition count.
 type Aggregated struct {
 	// Snapshot is a pointer to the structure that was used to generate these
@@ -40,61 +88,47 @@ type Aggregated struct {
 // The buckets are ordered in library provided order of relevancy. You can
 // reorder at your choosing.
 func (s *Snapshot) Aggregate(similar Similarity) *Aggregated {
-	type count struct {
-		ids   []int
-		first bool
-	}
-	b := map[*Signature]*count{}
+	a := aggregator{similar: similar}
 	// O(n²). Fix eventually.
 	for _, routine := range s.Goroutines {
-		found := false
-		for key, c := range b {
-			// When a match is found, this effectively drops the other goroutine ID.
-			if key.similar(&routine.Signature, similar) {
-				found = true
-				c.ids = append(c.ids, routine.ID)
-				c.first = c.first || routine.First
-				if !key.equal(&routine.Signature) {
-					// Almost but not quite equal. There's different pointers passed
-					// around but the same values. Zap out the different values.
-					newKey := key.merge(&routine.Signature)
-					b[newKey] = c
-					delete(b, key)
-				}
-				break
-			}
-		}
-		if !found {
-			// Create a copy of the Signature, since it will be mutated.
-			key := &Signature{}
-			*key = routine.Signature
-			b[key] = &count{ids: []int{routine.ID}, first: routine.First}
-		}
+		a.add(routine)
 	}
-	bs := make([]*Bucket, 0, len(b))
-	for signature, c := range b {
-		sort.Ints(c.ids)
-		bs = append(bs, &Bucket{Signature: *signature, IDs: c.ids, First: c.first})
+	return &Aggregated{
+		Snapshot: s,
+		Buckets:  a.sorted(),
 	}
-	// Do reverse sort.
-	sort.SliceStable(bs, func(i, j int) bool {
-		l := bs[i]
-		r := bs[j]
-		if l.First || r.First {
-			return l.First
-		}
-		if l.Signature.less(&r.Signature) {
-			return true
-		}
-		if r.Signature.less(&l.Signature) {
-			return false
+}
+
+// StreamAggregate scans the Reader for a snapshot like ScanSnapshot() and
+// merges the goroutines into buckets as they are parsed, without keeping the
+// goroutines in memory. The memory used is thus proportional to the number of
+// buckets instead of the number of goroutines.
+//
+// onBucket, if set, is called once per goroutine parsed, in the order they
+// were printed, with the bucket it was added to. The bucket is either new or
+// one previously passed to onBucket that was updated: the goroutine ID is
+// appended to IDs and the Signature may have been generalized to match the
+// goroutine. IDs are only sorted once the whole snapshot is parsed.
+//
+// Since the goroutines are not kept, the returned Aggregated.Snapshot has no
+// Goroutines, and Opts NameArguments, GuessPaths, AnalyzeSources and ArgTypes
+// are ignored. The goroutines of a race report are only merged at the end of
+// the report.
+//
+// The buckets in the returned Aggregated are in the same order as with
+// Aggregate(). The returned values are otherwise the same as ScanSnapshot().
+func StreamAggregate(in io.Reader, prefix io.Writer, opts *Opts, similar Similarity, onBucket func(b *Bucket)) (*Aggregated, []byte, error) {
+	a := aggregator{similar: similar}
+	s, suffix, err := scanSnapshot(in, prefix, opts, func(g *Goroutine) {
+		b := a.add(g)
+		if onBucket != nil {
+			onBucket(b)
 		}
-		return len(r.IDs) > len(l.IDs)
 	})
-	return &Aggregated{
-		Snapshot: s,
-		Buckets:  bs,
+	if s == nil {
+		return nil, suffix, err
 	}
+	return &Aggregated{Snapshot: s, Buckets: a.sorted()}, suffix, err
 }
 
 // Bucket is a stack trace signature and the list of goroutines that fits this
@@ -111,3 +145,59 @@ type Bucket struct {
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
 }
+
+// Private stuff.
+
+// aggregator merges goroutines into buckets.
+type aggregator struct {
+	similar Similarity
+	buckets []*Bucket
+}
+
+// add adds the goroutine to the first similar bucket found, or to a new one.
+//
+// Returns the bucket.
+func (a *aggregator) add(g *Goroutine) *Bucket {
+	for _, b := range a.buckets {
+		// When a match is found, this effectively drops the other goroutine ID.
+		if b.Signature.similar(&g.Signature, a.similar) {
+			b.IDs = append(b.IDs, g.ID)
+			b.First = b.First || g.First
+			if !b.Signature.equal(&g.Signature) {
+				// Almost but not quite equal. There's different pointers passed
+				// around but the same values. Zap out the different values.
+				b.Signature = *b.Signature.merge(&g.Signature)
+			}
+			return b
+		}
+	}
+	// Copy the Signature, since it will be mutated.
+	b := &Bucket{Signature: g.Signature, IDs: []int{g.ID}, First: g.First}
+	a.buckets = append(a.buckets, b)
+	return b
+}
+
+// sorted returns the buckets in order of relevancy.
+func (a *aggregator) sorted() []*Bucket {
+	bs := make([]*Bucket, len(a.buckets))
+	copy(bs, a.buckets)
+	for _, b := range bs {
+		sort.Ints(b.IDs)
+	}
+	// Do reverse sort.
+	sort.SliceStable(bs, func(i, j int) bool {
+		l := bs[i]
+		r := bs[j]
+		if l.First || r.First {
+			return l.First
+		}
+		if l.Signature.less(&r.Signature) {
+			return true
+		}
+		if r.Signature.less(&l.Signature) {
+			return false
+		}
+		return len(r.IDs) > len(l.IDs)
+	})
+	return bs
+}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strings"
 	"testing"
 
@@ -43,11 +44,12 @@ func TestAggregateNotAggressive(t *testing.T) {
 				State: "chan receive",
 				Stack: Stack{
 					Calls: []Call{
-						newCall(
+						newCallOffset(
 							"main.func·001",
 							Args{Values: []Arg{{Value: 0x11000000, IsPtr: true}, {Value: 2}}},
 							"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-							72),
+							72,
+							0x49),
 					},
 				},
 			},
@@ -59,11 +61,12 @@ func TestAggregateNotAggressive(t *testing.T) {
 				State: "chan receive",
 				Stack: Stack{
 					Calls: []Call{
-						newCall(
+						newCallOffset(
 							"main.func·001",
 							Args{Values: []Arg{{Value: 0x21000000, Name: "#1", IsPtr: true}, {Value: 2}}},
 							"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-							72),
+							72,
+							0x49),
 					},
 				},
 			},
@@ -110,20 +113,22 @@ func TestAggregateExactMatching(t *testing.T) {
 				State: "chan receive",
 				CreatedBy: Stack{
 					Calls: []Call{
-						newCall(
+						newCallOffset(
 							"main.mainImpl",
 							Args{},
 							"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-							74),
+							74,
+							0xeb),
 					},
 				},
 				Stack: Stack{
 					Calls: []Call{
-						newCall(
+						newCallOffset(
 							"main.func·001",
 							Args{},
 							"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-							72),
+							72,
+							0x49),
 					},
 				},
 			},
@@ -169,11 +174,12 @@ func TestAggregateAggressive(t *testing.T) {
 				SleepMax: 100,
 				Stack: Stack{
 					Calls: []Call{
-						newCall(
+						newCallOffset(
 							"main.func·001",
 							Args{Values: []Arg{{Value: 0x21000000, Name: "*", IsPtr: true}, {Value: 2}}},
 							"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-							72),
+							72,
+							0x49),
 					},
 				},
 			},
@@ -229,6 +235,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 						Func:          Func{Complete: "main", Name: "main"},
 						RemoteSrcPath: "foo/foo.go",
 						Line:          631,
+						PCOffset:      0x4b,
 						SrcName:       "foo.go",
 					},
 				}},
@@ -246,6 +253,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          467,
+							PCOffset:      0x2b8,
 							SrcName:       "foo.go",
 							ImportPath:    "foo",
 						},
@@ -254,6 +262,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							Args:          Args{Values: []Arg{{Value: 3}}},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          643,
+							PCOffset:      0x69,
 							SrcName:       "foo.go",
 							ImportPath:    "foo",
 						},
@@ -271,6 +280,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 						Func:          Func{Complete: "main", Name: "main"},
 						RemoteSrcPath: "foo/foo.go",
 						Line:          631,
+						PCOffset:      0x4b,
 						SrcName:       "foo.go",
 					},
 				}},
@@ -288,6 +298,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          467,
+							PCOffset:      0x2b8,
 							SrcName:       "foo.go",
 							ImportPath:    "foo",
 						},
@@ -296,6 +307,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							Args:          Args{Values: []Arg{{Value: 1}}},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          643,
+							PCOffset:      0x69,
 							SrcName:       "foo.go",
 							ImportPath:    "foo",
 						},
@@ -313,6 +325,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							Func:          Func{Complete: "bozo", Name: "bozo"},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          420,
+							PCOffset:      0x33,
 							SrcName:       "foo.go",
 						},
 					},
@@ -331,6 +344,7 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 							},
 							RemoteSrcPath: "foo/foo.go",
 							Line:          467,
+							PCOffset:      0x2b8,
 							SrcName:       "foo.go",
 							ImportPath:    "foo",
 						},
@@ -344,6 +358,256 @@ func TestAggregateDeadlockPanic(t *testing.T) {
 	compareString(t, "", string(suffix))
 }
 
+func TestAggregateFlags(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 6 [chan receive]:",
+		"main.func·001(0x11000000)",
+		"\t/a/foo/main.go:72 +0x30",
+		"",
+		// Different directory.
+		"goroutine 7 [chan receive]:",
+		"main.func·001(0x22000000)",
+		"\t/b/foo/main.go:72 +0x30",
+		"",
+		// Different line.
+		"goroutine 8 [chan receive]:",
+		"main.func·001(0x33000000)",
+		"\t/a/foo/main.go:80 +0x30",
+		"",
+		// Different file name.
+		"goroutine 9 [chan receive]:",
+		"main.func·001(0x44000000)",
+		"\t/b/foo/other.go:72 +0x30",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	data := []struct {
+		similar Similarity
+		want    [][]int
+	}{
+		{AnyPointer, [][]int{{6}, {7}, {8}, {9}}},
+		{AnyPointer | BasenameOnly, [][]int{{6, 7}, {8}, {9}}},
+		{AnyPointer | AnyLine, [][]int{{6, 8}, {7}, {9}}},
+		{AnyPointer | BasenameOnly | AnyLine, [][]int{{6, 7, 8}, {9}}},
+		// The level still applies to the arguments.
+		{ExactLines | BasenameOnly | AnyLine, [][]int{{6}, {7}, {8}, {9}}},
+	}
+	for i, line := range data {
+		a := s.Aggregate(line.similar)
+		var got [][]int
+		for _, b := range a.Buckets {
+			got = append(got, b.IDs)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+		if diff := cmp.Diff(line.want, got); diff != "" {
+			t.Errorf("#%d: Aggregate(%d) mismatch (-want +got):\n%s", i, line.similar, diff)
+		}
+		// The first goroutine is the representative.
+		c := a.Buckets[0].Stack.Calls[0]
+		if c.RemoteSrcPath != "/a/foo/main.go" || c.Line != 72 {
+			t.Errorf("#%d: unexpected representative %s:%d", i, c.RemoteSrcPath, c.Line)
+		}
+	}
+}
+
+func TestAggregateModuleVersions(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [chan receive]:",
+		"github.com/foo/bar.(*Conn).Read()",
+		"\t/gopath/pkg/mod/github.com/foo/bar@v1.2.3/conn.go:72 +0x30",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:10 +0x30",
+		"",
+		"goroutine 2 [chan receive]:",
+		"github.com/foo/bar.(*Conn).Read()",
+		"\t/gopath/pkg/mod/github.com/foo/bar@v1.2.4/conn.go:72 +0x30",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:10 +0x30",
+		"",
+		"goroutine 3 [chan receive]:",
+		"github.com/foo/bar.(*Conn).Read()",
+		"\t/gopath/pkg/mod/github.com/foo/bar@v1.2.4/conn.go:75 +0x30",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:10 +0x30",
+		"",
+		// A different module.
+		"goroutine 4 [chan receive]:",
+		"github.com/foo/bar.(*Conn).Read()",
+		"\t/gopath/pkg/mod/github.com/foo/baz@v1.2.3/conn.go:72 +0x30",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:10 +0x30",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	data := []struct {
+		similar Similarity
+		want    [][]int
+	}{
+		{AnyPointer, [][]int{{1}, {2}, {3}, {4}}},
+		{AnyPointer | AnyModuleVersion, [][]int{{1, 2}, {3}, {4}}},
+		{AnyPointer | AnyModuleVersion | AnyLine, [][]int{{1, 2, 3}, {4}}},
+	}
+	for i, line := range data {
+		a := s.Aggregate(line.similar)
+		var got [][]int
+		for _, b := range a.Buckets {
+			got = append(got, b.IDs)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+		if diff := cmp.Diff(line.want, got); diff != "" {
+			t.Errorf("#%d: Aggregate(%d) mismatch (-want +got):\n%s", i, line.similar, diff)
+		}
+	}
+}
+
+func TestAggregateGoVersions(t *testing.T) {
+	t.Parallel()
+	// The same program built with Go 1.21 and Go 1.22. Go 1.22 generates a
+	// "gowrap" wrapper for the go statement with arguments and a "deferwrap"
+	// wrapper for the defer statement, renumbering the other closures.
+	go121 := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.main.func1()",
+		"\t/home/user/src/foo/main.go:22 +0x25",
+		"panic(0x4a1e20, 0x4d6f40)",
+		"\t/goroot/src/runtime/panic.go:914 +0x21f",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:30 +0xb1",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.main.func3()",
+		"\t/home/user/src/foo/main.go:27 +0x2c",
+		"created by main.main in goroutine 1",
+		"\t/home/user/src/foo/main.go:26 +0x85",
+		"",
+		"goroutine 5 [chan receive]:",
+		"main.worker(0xc000012345)",
+		"\t/home/user/src/foo/main.go:12 +0x2c",
+		"created by main.main.func2 in goroutine 1",
+		"\t/home/user/src/foo/main.go:24 +0x85",
+		"",
+	}
+	go122 := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.main.deferwrap1()",
+		"\t/home/user/src/foo/main.go:22 +0x25",
+		"panic(0x4a1e20, 0x4d6f40)",
+		"\t/goroot/src/runtime/panic.go:914 +0x21f",
+		"main.main()",
+		"\t/home/user/src/foo/main.go:30 +0xb1",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.main.func1()",
+		"\t/home/user/src/foo/main.go:27 +0x2c",
+		"created by main.main in goroutine 1",
+		"\t/home/user/src/foo/main.go:26 +0x85",
+		"",
+		"goroutine 5 [chan receive]:",
+		"main.worker(0xc000054321)",
+		"\t/home/user/src/foo/main.go:12 +0x2c",
+		"created by main.main.gowrap1 in goroutine 1",
+		"\t/home/user/src/foo/main.go:24 +0x85",
+		"",
+	}
+	s := &Snapshot{}
+	for _, in := range [][]string{go121, go122} {
+		c, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+		if c == nil {
+			t.Fatal("expected snapshot")
+		}
+		s.Goroutines = append(s.Goroutines, c.Goroutines...)
+	}
+	if got := len(s.Aggregate(AnyPointer).Buckets); got != 6 {
+		t.Fatalf("expected 6 buckets, got %d", got)
+	}
+	a := s.Aggregate(AnyPointer | AnyClosure)
+	var got [][]string
+	for _, b := range a.Buckets {
+		var names []string
+		for _, c := range b.Stack.Calls {
+			names = append(names, c.Func.Complete)
+		}
+		got = append(got, names)
+		if len(b.IDs) != 2 {
+			t.Errorf("%v: expected 2 goroutines, got %v", names, b.IDs)
+		}
+	}
+	want := [][]string{
+		{"main.main.func1", "panic", "main.main"},
+		{"main.main.func3"},
+		{"main.worker"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Bucket mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStreamAggregate(t *testing.T) {
+	t.Parallel()
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive, 10 minutes]:",
+		"main.func·001(0x21000000, 2)",
+		"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"goroutine 7 [running]:",
+		"main.main()",
+		"\t/gopath/src/github.com/maruel/panicparse/stack/main.go:10 +0x49",
+		"",
+		"goroutine 8 [chan receive, 100 minutes]:",
+		"main.func·001(0x41000000, 2)",
+		"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"junk",
+		"",
+	}
+	in := strings.Join(data, "\n")
+	var got []*Bucket
+	var ids [][]int
+	a, suffix, err := StreamAggregate(bytes.NewBufferString(in), ioutil.Discard, &Opts{}, AnyPointer, func(b *Bucket) {
+		got = append(got, b)
+		ids = append(ids, append([]int{}, b.IDs...))
+	})
+	compareErr(t, nil, err)
+	compareString(t, "junk\n", string(suffix))
+	if a == nil {
+		t.Fatal("expected buckets")
+	}
+	if len(a.Goroutines) != 0 {
+		t.Fatalf("expected no goroutines kept, got %d", len(a.Goroutines))
+	}
+	if len(got) != 3 || got[0] != got[2] || got[0] == got[1] {
+		t.Fatalf("unexpected callbacks: %v", got)
+	}
+	if diff := cmp.Diff([][]int{{6}, {7}, {6, 8}}, ids); diff != "" {
+		t.Fatalf("IDs mismatch (-want +got):\n%s", diff)
+	}
+
+	// The result is the same as the non-streaming version.
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, nil, err)
+	compareString(t, "junk\n", string(suffix))
+	compareBuckets(t, s.Aggregate(AnyPointer).Buckets, a.Buckets)
+}
+
 func BenchmarkAggregate(b *testing.B) {
 	b.ReportAllocs()
 	s, suffix, err := ScanSnapshot(bytes.NewReader(internaltest.StaticPanicwebOutput()), ioutil.Discard, defaultOpts())
@@ -0,0 +1,407 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// This file contains helpers to classify goroutines.
+
+package stack
+
+import "strings"
+
+// Category is a coarse grouping of goroutine states.
+type Category int
+
+const (
+	// CategoryOther is a state that is not known to LookupStateCategory(),
+	// e.g. "idle" or "timer goroutine".
+	CategoryOther Category = iota
+	// CategoryRunning is a goroutine running on a thread.
+	CategoryRunning
+	// CategoryRunnable is a goroutine ready to run but not running.
+	CategoryRunnable
+	// CategoryBlocked is a goroutine blocked on a synchronization primitive,
+	// like a channel, select, mutex, condition variable or sleep.
+	CategoryBlocked
+	// CategorySyscall is a goroutine executing a system call.
+	CategorySyscall
+	// CategoryIOWait is a goroutine waiting on network or file I/O.
+	CategoryIOWait
+	// CategoryGC is a goroutine of the garbage collector or finalizer.
+	CategoryGC
+	// CategoryDead is a goroutine that exited.
+	CategoryDead
+
+	lastCategory
+)
+
+// stateCategories maps Goroutine.State, without its qualifier, to its Category.
+//
+// It must only be modified by RegisterStateCategory(), use
+// LookupStateCategory() to read it.
+var stateCategories = map[string]Category{
+	"running":             CategoryRunning,
+	"runnable":            CategoryRunnable,
+	"preempted":           CategoryRunnable,
+	"chan send":           CategoryBlocked,
+	"chan receive":        CategoryBlocked,
+	"select":              CategoryBlocked,
+	"semacquire":          CategoryBlocked,
+	"semarelease":         CategoryBlocked,
+	"sleep":               CategoryBlocked,
+	"sync.Cond.Wait":      CategoryBlocked,
+	"sync.Mutex.Lock":     CategoryBlocked,
+	"sync.RWMutex.Lock":   CategoryBlocked,
+	"sync.RWMutex.RLock":  CategoryBlocked,
+	"sync.WaitGroup.Wait": CategoryBlocked,
+	"waiting":             CategoryBlocked,
+	"syscall":             CategorySyscall,
+	"IO wait":             CategoryIOWait,
+	"GC assist marking":   CategoryGC,
+	"GC assist wait":      CategoryGC,
+	"GC scavenge wait":    CategoryGC,
+	"GC sweep wait":       CategoryGC,
+	"GC worker":           CategoryGC,
+	"Concurrent GC wait":  CategoryGC,
+	"finalizer wait":      CategoryGC,
+	"force gc":            CategoryGC,
+	"mark wait":           CategoryGC,
+	"mark worker":         CategoryGC,
+	"wait for GC cycle":   CategoryGC,
+	"dead":                CategoryDead,
+	// As printed by the race detector.
+	"finished": CategoryDead,
+}
+
+// LookupStateCategory returns the Category of a goroutine state without its
+// qualifier, e.g. "chan receive" or "GC worker".
+//
+// States being scanned by the garbage collector, like "scanrunning", use the
+// category of the underlying state. Returns CategoryOther and false if the state is
+// not known.
+func LookupStateCategory(state string) (Category, bool) {
+	if c, ok := stateCategories[state]; ok {
+		return c, true
+	}
+	if strings.HasPrefix(state, "scan") {
+		if c, ok := stateCategories[state[len("scan"):]]; ok {
+			return c, true
+		}
+	}
+	return CategoryOther, false
+}
+
+// RegisterStateCategory sets the Category of a goroutine state without its
+// qualifier, e.g. a state added by a newer Go runtime. It overrides the
+// category of a known state.
+//
+// It must be called at initialization time, e.g. in an init() function, since
+// the categories are read without synchronization. It panics if c is not a
+// valid Category.
+func RegisterStateCategory(state string, c Category) {
+	if c < CategoryOther || c >= lastCategory {
+		panic("invalid category " + c.String())
+	}
+	stateCategories[state] = c
+}
+
+// StateCategory returns the coarse grouping of the goroutine state, as
+// returned by LookupStateCategory().
+//
+// Goroutines with an unknown state for which IsGCWorker() is true are
+// categorized as CategoryGC.
+func (g *Goroutine) StateCategory() Category {
+	if c, ok := LookupStateCategory(g.BaseState()); ok {
+		return c
+	}
+	if g.IsGCWorker() {
+		return CategoryGC
+	}
+	return CategoryOther
+}
+
+// IsGCWorker returns true if the goroutine is a garbage collector background
+// mark worker.
+//
+// These are started by the runtime and are normally noise when looking at a
+// snapshot. The worker mode, e.g. "idle", "dedicated" or "fractional", is in
+// StateDetail.
+func (g *Goroutine) IsGCWorker() bool {
+	switch g.BaseState() {
+	case "GC worker", "mark worker":
+		return true
+	}
+	for i := range g.Stack.Calls {
+		if g.Stack.Calls[i].Func.Complete == "runtime.gcBgMarkWorker" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRuntimeBackground returns true if the goroutine is one of the long lived
+// goroutines started by the runtime itself.
+//
+// They are recognized by one of these functions in their stack:
+//   - runtime.forcegchelper, which forces a periodic garbage collection;
+//   - runtime.bgsweep, the background sweeper;
+//   - runtime.bgscavenge, the background scavenger;
+//   - runtime.gcBgMarkWorker, a garbage collector mark worker, see
+//     IsGCWorker();
+//   - runtime.runfinq, which runs the finalizers;
+//   - runtime.timerproc, which ran the timers before Go 1.14.
+//
+// They are expected in any process and are normally noise when looking at a
+// snapshot, e.g. hide them with Snapshot.Filter().
+func (g *Goroutine) IsRuntimeBackground() bool {
+	for i := range g.Stack.Calls {
+		if runtimeBackgroundFuncs[g.Stack.Calls[i].Func.Complete] {
+			return true
+		}
+	}
+	return false
+}
+
+// LikelyBug returns true and an explanation if the goroutine is in a state
+// that almost always indicates a bug, because it can never be unblocked:
+//   - "chan send (nil chan)" and "chan receive (nil chan)": a send or receive
+//     on a nil channel;
+//   - "select (no cases)": a select statement without any case, like
+//     "select {}".
+//
+// A deadlock involving multiple goroutines, like a sync.WaitGroup that is
+// never done, can't be told from a single goroutine. The runtime reports it
+// when all goroutines are blocked, see Snapshot.FatalError.
+func (g *Goroutine) LikelyBug() (bool, string) {
+	switch g.State {
+	case "chan send (nil chan)", "chan receive (nil chan)":
+		return true, g.BaseState() + " on a nil channel blocks forever"
+	case "select (no cases)":
+		return true, "select without cases blocks forever"
+	}
+	return false, ""
+}
+
+// InNetpoll returns true if the goroutine is blocked waiting on network I/O,
+// like an idle connection handler waiting for the next request.
+//
+// The goroutine must be in the "IO wait" state and have at least one call to
+// runtime.netpollblock, to a function in package internal/poll or to a
+// net.(*netFD) method.
+func (g *Goroutine) InNetpoll() bool {
+	if g.State != "IO wait" {
+		return false
+	}
+	for i := range g.Stack.Calls {
+		f := g.Stack.Calls[i].Func.Complete
+		if f == "runtime.netpollblock" || strings.HasPrefix(f, "internal/poll.") || strings.HasPrefix(f, "net.(*netFD).") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMain returns true if the goroutine is the main goroutine, the one running
+// main.main().
+//
+// It is detected by the bottom frame, which is runtime.main or main.main for
+// the main goroutine, and the lack of a creator. When the bottom frames were
+// elided, the goroutine ID is used instead, since the main goroutine is
+// normally goroutine 1.
+func (g *Goroutine) IsMain() bool {
+	if g.HasCreator() {
+		return false
+	}
+	if g.Stack.Elided || len(g.Stack.Calls) == 0 {
+		return g.ID == 1
+	}
+	switch g.Stack.Calls[len(g.Stack.Calls)-1].Func.Complete {
+	case "runtime.main", "main.main":
+		return true
+	}
+	return false
+}
+
+// HasCreator returns true if the goroutine has a "created by" line.
+//
+// It is false for the main goroutine and the goroutines of a data race
+// report without a creation stack. The parser only accepts a "created by"
+// line with a function name, so when HasCreator returns true,
+// CreatedBy.Calls[0].Func.Complete is never empty.
+func (g *Goroutine) HasCreator() bool {
+	return len(g.CreatedBy.Calls) != 0
+}
+
+// CreatedByRuntime returns true if the goroutine was created by the runtime
+// itself, like "created by runtime.gcenable" or "created by runtime.main".
+//
+// These goroutines are started on behalf of the program, even if Go 1.21 and
+// later print the goroutine that was running at the time, usually the main
+// goroutine, as the creator.
+func (g *Goroutine) CreatedByRuntime() bool {
+	return g.HasCreator() && g.CreatedBy.Calls[0].Func.ImportPath == "runtime"
+}
+
+// CreatorPackage returns the import path of the package of the function that
+// created the goroutine, e.g. "net/http" for "created by
+// net/http.(*Server).Serve", or "main" for package main.
+//
+// Returns an empty string if the goroutine has no creator, see HasCreator().
+func (g *Goroutine) CreatorPackage() string {
+	if !g.HasCreator() {
+		return ""
+	}
+	return g.CreatedBy.Calls[0].Func.ImportPath
+}
+
+// Main returns the main goroutine, as determined by Goroutine.IsMain(), if
+// any.
+func (s *Snapshot) Main() *Goroutine {
+	for _, g := range s.Goroutines {
+		if g.IsMain() {
+			return g
+		}
+	}
+	return nil
+}
+
+// Crashed returns the goroutine that crashed, if any.
+//
+// The runtime prints the goroutine that triggered the crash first and in the
+// "running" state, after a "panic: " or "fatal error: " header. Returns nil
+// when HasCrashHeader() is false, since the snapshot is then a diagnostic
+// dump, like the one printed on SIGQUIT, where the first goroutine merely
+// happened to be printed first. Returns nil if the goroutine is not running.
+//
+// The goroutine is looked up by its First flag, or by the GoroutineID of
+// FatalError or of the last item of Panics when set, instead of its position
+// so the result stays the same once Goroutines was reordered, for example by
+// SortByInterest(). Other goroutines can be "running" on other threads; they
+// are never reported.
+func (s *Snapshot) Crashed() *Goroutine {
+	if !s.HasCrashHeader() {
+		return nil
+	}
+	id := 0
+	if s.FatalError != nil {
+		id = s.FatalError.GoroutineID
+	} else if len(s.Panics) != 0 {
+		id = s.Panics[len(s.Panics)-1].GoroutineID
+	}
+	for _, g := range s.Goroutines {
+		if g.First || (id != 0 && g.ID == id) {
+			if g.State != "running" {
+				return nil
+			}
+			return g
+		}
+	}
+	return nil
+}
+
+// IsCrashing returns true if g is the goroutine that crashed, as returned by
+// Crashed().
+func (s *Snapshot) IsCrashing(g *Goroutine) bool {
+	return g != nil && s.Crashed() == g
+}
+
+// HasCrashHeader returns true if a "panic: " or "fatal error: " line was
+// printed before the goroutines, which means the process crashed.
+func (s *Snapshot) HasCrashHeader() bool {
+	return len(s.Panics) != 0 || s.FatalError != nil
+}
+
+// CrashedInBackground returns true if a goroutine crashed and it is not the
+// main goroutine.
+//
+// In this case, Main() returns where the main goroutine was when the crash
+// happened, if it was printed.
+func (s *Snapshot) CrashedInBackground() bool {
+	c := s.Crashed()
+	return c != nil && !c.IsMain()
+}
+
+// SameAsCrashed returns the other goroutines with a signature similar to the
+// goroutine that crashed, as returned by Crashed(), in the order they were
+// printed.
+//
+// It tells if the crash is isolated or systemic: many goroutines at the same
+// spot as the crash hints at a problem that is not specific to the crashed
+// goroutine. The goroutines are compared like with Aggregate(). Returns nil if
+// no goroutine crashed.
+func (s *Snapshot) SameAsCrashed(similar Similarity) []*Goroutine {
+	c := s.Crashed()
+	if c == nil {
+		return nil
+	}
+	var out []*Goroutine
+	for _, g := range s.Goroutines {
+		if g != c && c.Signature.similar(&g.Signature, similar) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// LockKind is the kind of synchronization primitive a goroutine is blocked
+// on.
+type LockKind int
+
+const (
+	// LockUnknown is a goroutine not blocked on a known primitive.
+	LockUnknown LockKind = iota
+	// LockChannel is a goroutine blocked sending to or receiving from a
+	// channel, including in a select.
+	LockChannel
+	// LockMutex is a goroutine blocked in sync.(*Mutex).Lock.
+	LockMutex
+	// LockRWMutex is a goroutine blocked in sync.(*RWMutex).Lock or RLock.
+	LockRWMutex
+	// LockWaitGroup is a goroutine blocked in sync.(*WaitGroup).Wait.
+	LockWaitGroup
+	// LockCond is a goroutine blocked in sync.(*Cond).Wait.
+	LockCond
+)
+
+// LockKind returns the kind of synchronization primitive the goroutine is
+// blocked on, as inferred from the calls.
+//
+// The calls are inspected from the leaf, the first one that is a known
+// blocking function determines the kind. The sync.runtime_* functions are
+// skipped since they are shared by the sync primitives.
+func (s *Signature) LockKind() LockKind {
+	for i := range s.Stack.Calls {
+		if k, ok := lockKinds[s.Stack.Calls[i].Func.Complete]; ok {
+			return k
+		}
+	}
+	return LockUnknown
+}
+
+// Private stuff.
+
+// runtimeBackgroundFuncs are the functions run by the goroutines for which
+// IsRuntimeBackground() is true.
+var runtimeBackgroundFuncs = map[string]bool{
+	"runtime.forcegchelper":  true,
+	"runtime.bgsweep":        true,
+	"runtime.bgscavenge":     true,
+	"runtime.gcBgMarkWorker": true,
+	"runtime.runfinq":        true,
+	"runtime.timerproc":      true,
+}
+
+// lockKinds maps the blocking functions to the kind of primitive.
+var lockKinds = map[string]LockKind{
+	"runtime.chanrecv":       LockChannel,
+	"runtime.chanrecv1":      LockChannel,
+	"runtime.chanrecv2":      LockChannel,
+	"runtime.chansend":       LockChannel,
+	"runtime.chansend1":      LockChannel,
+	"runtime.selectgo":       LockChannel,
+	"sync.(*Mutex).Lock":     LockMutex,
+	"sync.(*Mutex).lockSlow": LockMutex,
+	"sync.(*RWMutex).Lock":   LockRWMutex,
+	"sync.(*RWMutex).RLock":  LockRWMutex,
+	"sync.(*WaitGroup).Wait": LockWaitGroup,
+	"sync.(*Cond).Wait":      LockCond,
+}
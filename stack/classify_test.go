@@ -0,0 +1,699 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGoroutineIsGCWorker(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		header string
+		fn     string
+		state  string
+		detail string
+		base   string
+		want   bool
+	}{
+		{"GC worker (idle)", "runtime.gopark", "GC worker (idle)", "idle", "GC worker", true},
+		{"GC worker (active)", "runtime.gopark", "GC worker (active)", "active", "GC worker", true},
+		{"GC worker (idle), 5 minutes", "runtime.gopark", "GC worker (idle)", "idle", "GC worker", true},
+		{"mark worker (dedicated)", "runtime.gopark", "mark worker (dedicated)", "dedicated", "mark worker", true},
+		{"mark worker (fractional)", "runtime.gopark", "mark worker (fractional)", "fractional", "mark worker", true},
+		{"mark worker (idle)", "runtime.gopark", "mark worker (idle)", "idle", "mark worker", true},
+		{"idle", "runtime.gcBgMarkWorker", "idle", "", "idle", true},
+		{"force gc (idle)", "runtime.forcegchelper", "force gc (idle)", "idle", "force gc", false},
+		{"chan receive (nil chan)", "main.main", "chan receive (nil chan)", "nil chan", "chan receive", false},
+		{"chan receive", "main.main", "chan receive", "", "chan receive", false},
+	}
+	for i, line := range data {
+		in := []string{
+			"goroutine 1 [" + line.header + "]:",
+			line.fn + "()",
+			"\t/goroot/src/runtime/proc.go:307 +0xe5",
+			"",
+		}
+		s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+		compareErr(t, io.EOF, err)
+		if s == nil {
+			t.Fatalf("#%d: expected snapshot", i)
+		}
+		g := s.Goroutines[0]
+		compareString(t, line.state, g.State)
+		compareString(t, line.detail, g.StateDetail)
+		compareString(t, line.base, g.BaseState())
+		if got := g.IsGCWorker(); got != line.want {
+			t.Errorf("#%d: %q: IsGCWorker() = %t", i, line.header, got)
+		}
+	}
+}
+
+func TestGoroutineIsRuntimeBackground(t *testing.T) {
+	t.Parallel()
+	// An idle server.
+	in := []string{
+		"goroutine 1 [IO wait]:",
+		"internal/poll.runtime_pollWait(0x7f0e1c2a8e18, 0x72, 0x0)",
+		"\t/goroot/src/runtime/netpoll.go:222 +0x55",
+		"net.(*netFD).accept(0xc000126000, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/net/fd_unix.go:172 +0x45",
+		"net/http.(*Server).Serve(0xc00012a000, 0x8b3b80, 0xc000120040, 0x0, 0x0)",
+		"\t/goroot/src/net/http/server.go:2937 +0x266",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+		"goroutine 2 [force gc (idle), 5 minutes]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xe5",
+		"runtime.goparkunlock(...)",
+		"\t/goroot/src/runtime/proc.go:312",
+		"runtime.forcegchelper()",
+		"\t/goroot/src/runtime/proc.go:260 +0xb8",
+		"created by runtime.init.6",
+		"\t/goroot/src/runtime/proc.go:248 +0x35",
+		"",
+		"goroutine 3 [GC sweep wait]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xe5",
+		"runtime.bgsweep(0x0)",
+		"\t/goroot/src/runtime/mgcsweep.go:182 +0x8d",
+		"created by runtime.gcenable",
+		"\t/goroot/src/runtime/mgc.go:217 +0x5c",
+		"",
+		"goroutine 4 [GC scavenge wait]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xe5",
+		"runtime.bgscavenge(0x0)",
+		"\t/goroot/src/runtime/mgcscavenge.go:314 +0x27b",
+		"created by runtime.gcenable",
+		"\t/goroot/src/runtime/mgc.go:218 +0x7e",
+		"",
+		"goroutine 5 [finalizer wait, 5 minutes]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xe5",
+		"runtime.runfinq()",
+		"\t/goroot/src/runtime/mfinal.go:175 +0xa3",
+		"created by runtime.createfing",
+		"\t/goroot/src/runtime/mfinal.go:156 +0x65",
+		"",
+		"goroutine 6 [GC worker (idle)]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xe5",
+		"runtime.gcBgMarkWorker()",
+		"\t/goroot/src/runtime/mgc.go:1243 +0xe5",
+		"created by runtime.gcBgMarkStartWorkers",
+		"\t/goroot/src/runtime/mgc.go:1160 +0x93",
+		"",
+		"goroutine 7 [timer goroutine (idle)]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:303 +0xeb",
+		"runtime.timerproc(0x0)",
+		"\t/goroot/src/runtime/time.go:253 +0x2b0",
+		"created by runtime.(*timersBucket).addtimerLocked",
+		"\t/goroot/src/runtime/time.go:160 +0x107",
+		"",
+		"goroutine 8 [select]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x27",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x1a",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []bool{false, true, true, true, true, true, true, false}
+	if len(s.Goroutines) != len(want) {
+		t.Fatalf("unexpected number of goroutines %d", len(s.Goroutines))
+	}
+	for i, g := range s.Goroutines {
+		if got := g.IsRuntimeBackground(); got != want[i] {
+			t.Errorf("goroutine %d: IsRuntimeBackground() = %t", g.ID, got)
+		}
+	}
+	f := s.Filter(func(g *Goroutine) bool { return !g.IsRuntimeBackground() })
+	if len(f.Goroutines) != 2 || f.Goroutines[0].ID != 1 || f.Goroutines[1].ID != 8 {
+		t.Fatalf("unexpected filtered goroutines %v", f.Goroutines)
+	}
+}
+
+func TestGoroutineLikelyBug(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		header string
+		want   bool
+		reason string
+	}{
+		{"chan send (nil chan)", true, "chan send on a nil channel blocks forever"},
+		{"chan receive (nil chan)", true, "chan receive on a nil channel blocks forever"},
+		{"select (no cases)", true, "select without cases blocks forever"},
+		{"select (no cases), 5 minutes", true, "select without cases blocks forever"},
+		{"chan receive", false, ""},
+		{"select", false, ""},
+		{"running", false, ""},
+	}
+	for i, line := range data {
+		in := []string{
+			"goroutine 1 [" + line.header + "]:",
+			"main.main()",
+			"\t/gopath/src/foo/main.go:12 +0x1a",
+			"",
+		}
+		s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+		compareErr(t, io.EOF, err)
+		if s == nil {
+			t.Fatalf("#%d: expected snapshot", i)
+		}
+		got, reason := s.Goroutines[0].LikelyBug()
+		if got != line.want {
+			t.Errorf("#%d: %q: LikelyBug() = %t", i, line.header, got)
+		}
+		compareString(t, line.reason, reason)
+	}
+}
+
+func TestGoroutineInNetpoll(t *testing.T) {
+	t.Parallel()
+	// Idle HTTP handlers.
+	in := []string{
+		"goroutine 20 [IO wait, 3 minutes]:",
+		"internal/poll.runtime_pollWait(0x7f0e1c2a8e18, 0x72, 0x0)",
+		"\t/goroot/src/runtime/netpoll.go:222 +0x55",
+		"internal/poll.(*pollDesc).wait(0xc000126018, 0x72, 0x0, 0x1, 0x0)",
+		"\t/goroot/src/internal/poll/fd_poll_runtime.go:87 +0x45",
+		"internal/poll.(*FD).Read(0xc000126000, 0xc000148000, 0x1000, 0x1000, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/internal/poll/fd_unix.go:159 +0x1a5",
+		"net.(*netFD).Read(0xc000126000, 0xc000148000, 0x1000, 0x1000, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/net/fd_posix.go:55 +0x4f",
+		"net/http.(*connReader).Read(0xc00012c030, 0xc000148000, 0x1000, 0x1000, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/net/http/server.go:798 +0x1ad",
+		"net/http.(*conn).serve(0xc000130000, 0x8b3b80, 0xc000120040)",
+		"\t/goroot/src/net/http/server.go:1925 +0x8ad",
+		"created by net/http.(*Server).Serve",
+		"\t/goroot/src/net/http/server.go:2969 +0x36c",
+		"",
+		"goroutine 1 [IO wait]:",
+		"internal/poll.runtime_pollWait(0x7f0e1c2a8f00, 0x72, 0x0)",
+		"\t/goroot/src/runtime/netpoll.go:222 +0x55",
+		"internal/poll.(*FD).Accept(0xc000126080, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/internal/poll/fd_unix.go:394 +0x1fc",
+		"net.(*netFD).accept(0xc000126080, 0x30, 0x30, 0x7f0e1c2a5108)",
+		"\t/goroot/src/net/fd_unix.go:172 +0x45",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+		"goroutine 21 [chan receive]:",
+		"main.worker(0xc000130060)",
+		"\t/gopath/src/foo/main.go:20 +0x27",
+		"",
+		"goroutine 22 [running]:",
+		"net.(*netFD).Read(0xc000126000, 0xc000148000, 0x1000, 0x1000, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/net/fd_posix.go:55 +0x4f",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []bool{true, true, false, false}
+	if len(s.Goroutines) != len(want) {
+		t.Fatalf("want %d goroutines, got %d", len(want), len(s.Goroutines))
+	}
+	for i, g := range s.Goroutines {
+		if got := g.InNetpoll(); got != want[i] {
+			t.Errorf("#%d: goroutine %d: InNetpoll() = %t", i, g.ID, got)
+		}
+	}
+}
+
+func TestGoroutineStateCategory(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		state string
+		fn    string
+		want  Category
+	}{
+		{"running", "main.main", CategoryRunning},
+		{"scanrunning", "main.main", CategoryRunning},
+		{"runnable", "main.main", CategoryRunnable},
+		{"chan receive", "main.main", CategoryBlocked},
+		{"select", "main.main", CategoryBlocked},
+		{"sync.Mutex.Lock", "sync.runtime_SemacquireMutex", CategoryBlocked},
+		{"sync.Cond.Wait", "sync.runtime_notifyListWait", CategoryBlocked},
+		{"semacquire", "sync.runtime_Semacquire", CategoryBlocked},
+		{"sleep", "time.Sleep", CategoryBlocked},
+		{"syscall", "syscall.Syscall", CategorySyscall},
+		{"IO wait", "internal/poll.runtime_pollWait", CategoryIOWait},
+		{"GC worker", "runtime.gopark", CategoryGC},
+		{"finalizer wait", "runtime.gopark", CategoryGC},
+		{"idle", "runtime.gcBgMarkWorker", CategoryGC},
+		{"dead", "main.main", CategoryDead},
+		{"finished", "main.main", CategoryDead},
+		{"idle", "runtime.gopark", CategoryOther},
+		{"timer goroutine", "runtime.gopark", CategoryOther},
+		{"", "main.main", CategoryOther},
+	}
+	for i, line := range data {
+		g := &Goroutine{
+			Signature: Signature{
+				State: line.state,
+				Stack: Stack{Calls: []Call{newCall(line.fn, Args{}, "/goroot/src/runtime/proc.go", 307)}},
+			},
+		}
+		if got := g.StateCategory(); got != line.want {
+			t.Errorf("#%d: %q: StateCategory() = %s, want %s", i, line.state, got, line.want)
+		}
+	}
+}
+
+func TestLookupStateCategory(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		state string
+		want  Category
+		ok    bool
+	}{
+		{"chan receive", CategoryBlocked, true},
+		{"scanwaiting", CategoryBlocked, true},
+		{"GC worker", CategoryGC, true},
+		// The qualifier must be removed.
+		{"GC worker (idle)", CategoryOther, false},
+		{"scan", CategoryOther, false},
+		{"timer goroutine", CategoryOther, false},
+	}
+	for i, line := range data {
+		got, ok := LookupStateCategory(line.state)
+		if got != line.want || ok != line.ok {
+			t.Errorf("#%d: LookupStateCategory(%q) = %s, %t", i, line.state, got, ok)
+		}
+	}
+}
+
+func TestRegisterStateCategory(t *testing.T) {
+	// Not parallel, since it modifies the categories read by the other tests.
+	RegisterStateCategory("custom wait", CategoryIOWait)
+	in := []string{
+		"goroutine 1 [custom wait, 2 minutes]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+		"goroutine 2 [scancustom wait]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:20 +0x1a",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	for _, g := range s.Goroutines {
+		if c := g.StateCategory(); c != CategoryIOWait {
+			t.Errorf("goroutine %d: got %s", g.ID, c)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	RegisterStateCategory("bogus", lastCategory)
+}
+
+func TestCategoryString(t *testing.T) {
+	t.Parallel()
+	compareString(t, "IOWait", CategoryIOWait.String())
+	compareString(t, "Category(100)", Category(100).String())
+}
+
+func TestSnapshotCrashedInMain(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.crash()",
+		"\t/gopath/src/foo/main.go:20 +0x27",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x27",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x1a",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if c := s.Crashed(); c != s.Goroutines[0] {
+		t.Fatalf("unexpected crashed goroutine %v", c)
+	}
+	if m := s.Main(); m != s.Goroutines[0] {
+		t.Fatalf("unexpected main goroutine %v", m)
+	}
+	if s.CrashedInBackground() {
+		t.Fatal("expected the crash to be in the main goroutine")
+	}
+	if s.Goroutines[1].IsMain() {
+		t.Fatal("expected goroutine 6 to not be main")
+	}
+}
+
+func TestSnapshotCrashedInBackground(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"panic: oh no",
+		"",
+		"goroutine 6 [running]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x27",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x1a",
+		"",
+		"goroutine 1 [semacquire]:",
+		"sync.(*WaitGroup).Wait(0xc000012345)",
+		"\t/goroot/src/sync/waitgroup.go:130 +0x64",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if c := s.Crashed(); c != s.Goroutines[0] {
+		t.Fatalf("unexpected crashed goroutine %v", c)
+	}
+	if !s.CrashedInBackground() {
+		t.Fatal("expected the crash to be in a background goroutine")
+	}
+	m := s.Main()
+	if m != s.Goroutines[1] {
+		t.Fatalf("unexpected main goroutine %v", m)
+	}
+	compareString(t, "sync.(*WaitGroup).Wait", m.Stack.Calls[0].Func.Complete)
+}
+
+func TestSnapshotCrashedManyGoroutines(t *testing.T) {
+	t.Parallel()
+	// A large dump where other goroutines are also running on other threads.
+	// The crashing goroutine is printed first but it ends up in the middle once
+	// sorted by ID.
+	const n = 2000
+	const crasher = n / 2
+	b := &bytes.Buffer{}
+	b.WriteString("panic: oh no\n\n")
+	fmt.Fprintf(b, "goroutine %d [running]:\n", crasher)
+	b.WriteString("main.crash()\n\t/gopath/src/foo/main.go:20 +0x27\n")
+	b.WriteString("created by main.main\n\t/gopath/src/foo/main.go:11 +0x1a\n")
+	for i := 1; i <= n; i++ {
+		if i == crasher {
+			continue
+		}
+		if i%100 == 0 {
+			fmt.Fprintf(b, "\ngoroutine %d [running]:\n\tgoroutine running on other thread; stack unavailable\n", i)
+			continue
+		}
+		fmt.Fprintf(b, "\ngoroutine %d [chan receive]:\n", i)
+		b.WriteString("main.worker()\n\t/gopath/src/foo/main.go:30 +0x27\n")
+		b.WriteString("created by main.main\n\t/gopath/src/foo/main.go:11 +0x1a\n")
+	}
+	s, _, err := ScanSnapshot(b, ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if len(s.Goroutines) != n {
+		t.Fatalf("unexpected number of goroutines %d", len(s.Goroutines))
+	}
+	for i, g := range s.Goroutines[1:] {
+		if g.First {
+			t.Fatalf("#%d: goroutine %d unexpectedly first", i+1, g.ID)
+		}
+	}
+	sort.Slice(s.Goroutines, func(i, j int) bool { return s.Goroutines[i].ID < s.Goroutines[j].ID })
+	c := s.Crashed()
+	if c == nil || c.ID != crasher {
+		t.Fatalf("unexpected crashed goroutine %v", c)
+	}
+	if c != s.Goroutines[crasher-1] {
+		t.Fatal("expected the crashed goroutine in the middle")
+	}
+	if !s.CrashedInBackground() {
+		t.Fatal("expected the crash to be in a background goroutine")
+	}
+
+	// A fatal error points to the goroutine that threw by ID.
+	s.FatalError = &FatalError{Message: "oh no", GoroutineID: crasher}
+	c.First = false
+	if got := s.Crashed(); got != c {
+		t.Fatalf("unexpected crashed goroutine %v", got)
+	}
+}
+
+func TestSnapshotCrashedRequiresHeader(t *testing.T) {
+	t.Parallel()
+	goroutines := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:30 +0x27",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x1a",
+		"",
+	}
+	data := []struct {
+		name   string
+		header []string
+		want   bool
+	}{
+		{"SIGQUIT", []string{"SIGQUIT: quit", "PC=0x46c4e1 m=0 sigcode=0", ""}, false},
+		{"NoHeader", nil, false},
+		{"Panic", []string{"panic: oh no", ""}, true},
+		{"FatalError", []string{"fatal error: concurrent map writes", ""}, true},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append(append([]string{}, line.header...), goroutines...)
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+			compareErr(t, io.EOF, err)
+			if s == nil || len(s.Goroutines) != 2 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			if !s.Goroutines[0].First {
+				t.Fatal("expected the first goroutine to be First")
+			}
+			if got := s.Crashed() != nil; got != line.want {
+				t.Fatalf("Crashed() = %v", s.Crashed())
+			}
+			if got := s.IsCrashing(s.Goroutines[0]); got != line.want {
+				t.Fatalf("IsCrashing() = %t", got)
+			}
+			if s.IsCrashing(s.Goroutines[1]) {
+				t.Fatal("unexpected crashing goroutine")
+			}
+		})
+	}
+}
+
+func TestSnapshotCrashedNone(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{Signature: Signature{State: "idle"}, ID: 0, First: true},
+			{Signature: Signature{State: "select", Stack: Stack{Elided: true}}, ID: 1},
+		},
+	}
+	if c := s.Crashed(); c != nil {
+		t.Fatalf("unexpected crashed goroutine %v", c)
+	}
+	if s.CrashedInBackground() {
+		t.Fatal("expected no crash")
+	}
+	// Falls back to the ID when frames are elided.
+	if m := s.Main(); m != s.Goroutines[1] {
+		t.Fatalf("unexpected main goroutine %v", m)
+	}
+}
+
+func TestLockKind(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		funcs []string
+		want  LockKind
+	}{
+		{nil, LockUnknown},
+		{[]string{"main.main"}, LockUnknown},
+		{[]string{"runtime.chanrecv1", "main.main"}, LockChannel},
+		{[]string{"runtime.selectgo", "main.main"}, LockChannel},
+		{[]string{"sync.runtime_SemacquireMutex", "sync.(*Mutex).lockSlow", "sync.(*Mutex).Lock", "main.main"}, LockMutex},
+		{[]string{"sync.runtime_SemacquireRWMutexR", "sync.(*RWMutex).RLock", "main.main"}, LockRWMutex},
+		{[]string{"sync.runtime_Semacquire", "sync.(*WaitGroup).Wait", "main.main"}, LockWaitGroup},
+		{[]string{"sync.runtime_notifyListWait", "sync.(*Cond).Wait", "main.main"}, LockCond},
+	}
+	for i, line := range data {
+		s := Signature{}
+		for _, f := range line.funcs {
+			s.Stack.Calls = append(s.Stack.Calls, newCall(f, Args{}, "", 0))
+		}
+		if got := s.LockKind(); got != line.want {
+			t.Errorf("#%d: LockKind() = %s; want %s", i, got, line.want)
+		}
+	}
+}
+
+func TestGoroutineHasCreator(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x20",
+		"",
+		"goroutine 2 [force gc (idle)]:",
+		"runtime.gopark(0x0, 0x0, 0x0, 0x0, 0x0)",
+		"\t/goroot/src/runtime/proc.go:398 +0xce",
+		"created by runtime.init.6 in goroutine 1",
+		"\t/goroot/src/runtime/proc.go:298 +0x25",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:20 +0x20",
+		"created by main.main in goroutine 1",
+		"\t/gopath/src/foo/main.go:9 +0x20",
+		"",
+	}, "\n")
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil || len(s.Goroutines) != 3 {
+		t.Fatalf("expected 3 goroutines, got %v", s)
+	}
+	data := []struct {
+		hasCreator, byRuntime bool
+	}{
+		{false, false},
+		{true, true},
+		{true, false},
+	}
+	for i, line := range data {
+		g := s.Goroutines[i]
+		if got := g.HasCreator(); got != line.hasCreator {
+			t.Errorf("goroutine %d: HasCreator() = %t", g.ID, got)
+		}
+		if got := g.CreatedByRuntime(); got != line.byRuntime {
+			t.Errorf("goroutine %d: CreatedByRuntime() = %t", g.ID, got)
+		}
+	}
+}
+
+func TestGoroutineCreatorPackage(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		creator string
+		want    string
+	}{
+		{"", ""},
+		{"main.main", "main"},
+		{"net/http.(*Server).Serve", "net/http"},
+		{"github.com/foo/bar/middleware.Wrap.func1", "github.com/foo/bar/middleware"},
+		{"gopkg.in/yaml%2ev2.handleErr", "gopkg.in/yaml.v2"},
+	}
+	for i, line := range data {
+		g := &Goroutine{}
+		if line.creator != "" {
+			g.CreatedBy.Calls = []Call{newCall(line.creator, Args{}, "", 0)}
+		}
+		if got := g.CreatorPackage(); got != line.want {
+			t.Errorf("#%d: CreatorPackage() = %q; want %q", i, got, line.want)
+		}
+	}
+}
+
+func TestSameAsCrashed(t *testing.T) {
+	t.Parallel()
+	goroutines := []string{
+		"goroutine 1 [running]:",
+		"main.crash(0xc000010000)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"main.worker(0xc000010000)",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 2 [running]:",
+		"main.crash(0xc000020000)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"main.worker(0xc000020000)",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 3 [chan receive]:",
+		"main.crash(0xc000020000)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"main.worker(0xc000020000)",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 4 [running]:",
+		"main.other(0xc000010000)",
+		"\t/gopath/src/foo/main.go:30 +0x30",
+		"main.worker(0xc000010000)",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+		"goroutine 5 [running]:",
+		"main.crash(0xc000010000)",
+		"\t/gopath/src/foo/main.go:10 +0x30",
+		"main.worker(0xc000010000)",
+		"\t/gopath/src/foo/main.go:20 +0x30",
+		"",
+	}
+	crash := append([]string{"panic: boom", ""}, goroutines...)
+	data := []struct {
+		name    string
+		in      []string
+		similar Similarity
+		want    []int
+	}{
+		{"AnyPointer", crash, AnyPointer, []int{2, 5}},
+		{"ExactLines", crash, ExactLines, []int{5}},
+		// Without a panic header, no goroutine crashed.
+		{"SIGQUIT", append([]string{"SIGQUIT: quit", "PC=0x46c4e1 m=0 sigcode=0", ""}, goroutines...), AnyPointer, nil},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			var got []int
+			for _, g := range s.SameAsCrashed(line.similar) {
+				got = append(got, g.ID)
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("SameAsCrashed() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -5,6 +5,9 @@
 //go:generate go get golang.org/x/tools/cmd/stringer
 //go:generate stringer -type state
 //go:generate stringer -type Location
+//go:generate stringer -type Category -trimprefix Category
+//go:generate stringer -type LockKind
+//go:generate stringer -type FrameChange
 
 package stack
 
@@ -23,9 +26,14 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Opts represents options to process the snapshot.
+//
+// The zero value is valid and only parses the snapshot: no path is guessed,
+// no source file is read and no argument is named. Use DefaultOpts() to
+// enable all the processing. Data race reports are always parsed.
 type Opts struct {
 	// LocalGOROOT is GOROOT with "/" as path separator. No trailing "/". Can be
 	// unset.
@@ -53,9 +61,130 @@ type Opts struct {
 	// AnalyzeSources tells panicparse to processes source files to improve calls
 	// to be more descriptive.
 	//
-	// Requires GuessPaths to be true.
+	// Requires GuessPaths to be true, unless SourceResolver is set.
 	AnalyzeSources bool
 
+	// SourceResolver returns the content of the source file srcPath, as found in
+	// the stack trace, if set. line is the line referenced in the stack trace.
+	//
+	// It is used by AnalyzeSources instead of reading LocalSrcPath from the
+	// local disk. This enables analyzing snapshots from binaries built on
+	// another host, for example by fetching the sources from a version control
+	// system at the commit the binary was built from.
+	//
+	// It is not used by GuessPaths, which still looks for the source files on
+	// the local disk to find the local GOROOT, GOPATH and Go modules. Leave
+	// GuessPaths false when the sources are only available via the resolver.
+	SourceResolver func(srcPath string, line int) (io.ReadCloser, error)
+
+	// IndexedFrames tells panicparse to accept function lines prefixed with
+	// their frame index, like "0: main.f(0x1)", as printed by some
+	// runtime/trace tools. The arguments may also be omitted, like "1: main.g".
+	//
+	// It is opt-in since a function named like "0: main.f" could then be
+	// confused with a frame index.
+	IndexedFrames bool
+
+	// TraceFrames tells panicparse to accept function lines laid out like "go
+	// tool trace" prints them, without arguments and suffixed with the PC,
+	// like "main.main @ 0x4a7b35", and file lines indented with 2 tabs. The PC
+	// is discarded.
+	//
+	// The trace tool doesn't print goroutine headers, so each stack must be
+	// preceded by a "goroutine N [state]:" line to be found.
+	//
+	// It is opt-in since the calls have no arguments, which loses information
+	// when the option is used on a regular runtime dump.
+	TraceFrames bool
+
+	// Lenient tells panicparse to accept dumps that were reformatted by third
+	// party tools.
+	//
+	// Currently, a function line and its file line joined on a single line,
+	// like "main.main()\t/foo.go:10 +0x1", are split back. The separator can be
+	// whitespace, optionally around "|", "@", "->" or ";", or " at ".
+	//
+	// It is opt-in since splitting the lines on these separators can mangle a
+	// source path containing one of them.
+	Lenient bool
+
+	// Strict tells panicparse to return an error when a line directly following
+	// a goroutine is not recognized, instead of ending the snapshot there.
+	//
+	// The snapshot must be followed by an empty line, or the end of the input,
+	// before unrelated text. The text before the snapshot is still written to
+	// prefix. This is useful to validate the output of a custom dumper.
+	Strict bool
+
+	// StateFilter, when set, is called with the state of each goroutine, e.g.
+	// "chan receive", right after its header is parsed. The goroutines for
+	// which it returns false are parsed but not kept in the Snapshot, and are
+	// not passed to OnGoroutine.
+	//
+	// It reduces the memory used on huge dumps. It doesn't apply to data race
+	// reports.
+	StateFilter func(state string) bool
+
+	// MaxArgs is the maximum number of argument values kept per call. The
+	// values past it are dropped and Args.Truncated is set. It bounds the
+	// memory used on corrupted or adversarial input.
+	//
+	// The runtime prints at most 10 values so the default of 1000, used when
+	// MaxArgs is 0, doesn't affect normal dumps.
+	MaxArgs int
+
+	// KeepRaw tells panicparse to keep the original text of each goroutine in
+	// Goroutine.Raw and the original text of the goroutine header state in
+	// Signature.RawState.
+	//
+	// It is disabled by default to save memory.
+	KeepRaw bool
+
+	// ArgTypes returns the parameters of the function funcRaw, as found in
+	// Func.Complete, if set. Each item is a parameter declaration, like
+	// "conn *net.TCPConn".
+	//
+	// It is used to render the arguments of calls that were not processed by
+	// AnalyzeSources, like "conn *net.TCPConn = 0xc000012345", into
+	// Args.Processed. The parameters are matched positionally with
+	// Args.Values. The raw values are kept as is when the number of parameters
+	// differs from the number of values or when values were elided, since the
+	// runtime prints words and not parameters.
+	ArgTypes func(funcRaw string) []string
+
+	// OnLine is called for each line read from the input, in the order they
+	// were read, if set.
+	//
+	// line includes the line ending, if any. kind tells if the line was junk
+	// written to prefix or was consumed as part of the snapshot. g is the
+	// goroutine the line belongs to, if any. g may still be partially parsed
+	// when the callback is called.
+	//
+	// The line that terminates a snapshot is not reported, as it is returned as
+	// part of the suffix.
+	OnLine func(line string, kind LineKind, g *Goroutine)
+
+	// OnGoroutine is called for each goroutine once it is fully parsed, if set.
+	//
+	// It is called in the order the goroutines were printed, as soon as the
+	// next goroutine header or the end of the snapshot is read, so the
+	// goroutine can be annotated before the rest of the snapshot is parsed.
+	// The goroutines of a race report are only reported once the whole report
+	// is parsed.
+	//
+	// It is called before the processing done by NameArguments, GuessPaths,
+	// AnalyzeSources and ArgTypes.
+	//
+	// The goroutines for which it returns false are dropped from
+	// Snapshot.Goroutines, like with StateFilter but based on the whole
+	// goroutine, e.g. its stack.
+	OnGoroutine func(g *Goroutine) bool
+
+	// endOnDedent is set by ParseDebugStack to end the snapshot on the first
+	// line after a goroutine that doesn't have the indentation of the stack,
+	// instead of returning an error.
+	endOnDedent bool
+
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
 }
@@ -75,8 +204,22 @@ func DefaultOpts() *Opts {
 	}
 }
 
+// LineKind is the classification of a line passed to Opts.OnLine.
+type LineKind int
+
+const (
+	// LineJunk is a line that is not part of a snapshot. It was written to
+	// prefix.
+	LineJunk LineKind = iota
+	// LineGoroutine is a line that was consumed as part of a goroutine.
+	LineGoroutine
+	// LineSnapshot is a line that was consumed as part of the snapshot but is
+	// not associated to a goroutine, for example a race detector header.
+	LineSnapshot
+)
+
 func (o *Opts) isValid() bool {
-	if !o.GuessPaths && o.AnalyzeSources {
+	if !o.GuessPaths && o.AnalyzeSources && o.SourceResolver == nil {
 		return false
 	}
 	if strings.Contains(o.LocalGOROOT, "\\") {
@@ -97,6 +240,44 @@ type Snapshot struct {
 	// They are in the order that they were printed.
 	Goroutines []*Goroutine
 
+	// Signal is the signal that triggered the snapshot, if printed. It is
+	// notably the case when the process is sent SIGQUIT.
+	//
+	// The lines it is parsed from are still written to prefix, like the panic
+	// message. They precede the first goroutine header, so they are streamed
+	// out before it is known that a snapshot follows them.
+	Signal *Signal
+
+	// SkippedGoroutines is the number of goroutines that were not printed, as
+	// reported by a "...N goroutines, skipping..." line. When non-zero, the
+	// snapshot is incomplete.
+	SkippedGoroutines int
+
+	// Race is the data race report, if the snapshot is a race detector report.
+	Race *RaceReport
+
+	// ExitInfo is how the process terminated, if printed right after the
+	// goroutines, for example by "go run" or "go test".
+	ExitInfo *ExitInfo
+
+	// FatalError is the "fatal error:" that caused the snapshot, if printed.
+	FatalError *FatalError
+
+	// PanicMessage is the message of the last item of Panics, the panic that
+	// caused the crash, e.g. "runtime error: index out of range [5] with
+	// length 3". It is empty if no "panic: " line was printed.
+	//
+	// Deprecated: use Panics, which also has the nested panics. PanicMessage
+	// is derived from it and kept for compatibility.
+	PanicMessage string
+	// Panics is the chain of panics printed before the goroutines, if any.
+	//
+	// It is in the order printed by the runtime: the first panic raised comes
+	// first and the last one, which was not recovered and caused the crash,
+	// comes last. It has more than one item when a deferred function panicked
+	// while the goroutine was panicking.
+	Panics []*Panic
+
 	// LocalGOROOT is copied from Opts.
 	LocalGOROOT string
 	// LocalGOPATHs is copied from Opts.
@@ -138,6 +319,148 @@ type Snapshot struct {
 	_ struct{}
 }
 
+// Signal is a signal received by the process, as printed by the runtime
+// before the goroutines.
+//
+// For example "SIGQUIT: quit" followed by "PC=0x46c4e1 m=0 sigcode=0", or
+// "[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48d2b6]"
+// when a signal caused a panic. The latter is also recognized when printed
+// right after the goroutine header of the crashing goroutine.
+type Signal struct {
+	// Name is the signal name, e.g. "SIGQUIT".
+	Name string
+	// Description is the signal description, e.g. "quit".
+	Description string
+	// PC is the program counter where the signal was received, if printed.
+	PC uint64
+	// M is the ID of the OS thread that received the signal, if printed.
+	M int
+	// Code is the signal code, if printed. See CodeName().
+	Code int
+	// Addr is the faulting address, as printed by the runtime with "addr=" when
+	// the signal is a panic, e.g. 0 for a nil pointer dereference.
+	Addr uint64
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// CodeName returns the name of the signal code as defined on Linux, e.g.
+// "SEGV_MAPERR" for a SIGSEGV on an unmapped address or "SEGV_ACCERR" for a
+// SIGSEGV on a protected one.
+//
+// Returns an empty string if the code is unknown.
+func (s *Signal) CodeName() string {
+	if n := signalCodes[s.Name][s.Code]; n != "" {
+		return n
+	}
+	return signalCodes[""][s.Code]
+}
+
+// FatalError is a "fatal error: <message>" as printed by the runtime when it
+// throws, e.g. "fatal error: concurrent map writes".
+//
+// It is linked to the goroutine and the OS thread that threw when they are
+// printed; a format that is not recognized leaves them unset.
+type FatalError struct {
+	// Message is the error message, e.g. "concurrent map writes".
+	Message string
+	// GoroutineID is the ID of the goroutine that threw, which is the first
+	// one printed after the error. It is 0 if no goroutine was printed.
+	GoroutineID int
+	// M is the ID of the OS thread that threw, as printed on the
+	// "PC=0x46c4e1 m=3 sigcode=0" line following the error. It is -1 when not
+	// printed.
+	M int
+	// RuntimeMessages are the diagnostic lines printed by the runtime right
+	// before or after the error, without the "runtime: " prefix, e.g. "out of
+	// memory: cannot allocate 1073741824-byte block (3221225472 in use)".
+	RuntimeMessages []string
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Panic is one "panic: " line printed before the goroutines.
+type Panic struct {
+	// Message is the value passed to panic() as printed by the runtime, without
+	// the " [recovered]" marker.
+	Message string
+	// Recovered is true if the panic was recovered, as printed with the
+	// " [recovered]" marker. It happens when a deferred function recovered it
+	// and then panicked again.
+	Recovered bool
+	// GoroutineID is the ID of the goroutine that panicked, which is the first
+	// one printed after the panic. It is 0 if no goroutine was printed.
+	GoroutineID int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// IsOutOfMemory returns true if the process ran out of memory.
+func (f *FatalError) IsOutOfMemory() bool {
+	return strings.Contains(f.Message, "out of memory") || strings.Contains(f.Message, "cannot allocate memory")
+}
+
+// ParseError is the error returned when a line could not be parsed.
+//
+// It tells where the line is in the input, for example to highlight it in an
+// editor.
+type ParseError struct {
+	// Line is the line number in the input, starting at 1.
+	Line int
+	// Offset is the byte offset of the start of the line in the input,
+	// counting the line endings as they are, e.g. 2 bytes for "\r\n".
+	Offset int64
+	// Err is the parsing error.
+	Err error
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Error returns the message of Err, without the position.
+func (p *ParseError) Error() string {
+	return p.Err.Error()
+}
+
+// Unwrap returns Err.
+func (p *ParseError) Unwrap() error {
+	return p.Err
+}
+
+// ExitInfo is how the process terminated, as printed by the tool that ran it
+// after the stack dump, like "exit status 2" or "signal: killed".
+//
+// This is the format of os.ProcessState.String(), used by "go run" and
+// "go test".
+type ExitInfo struct {
+	// Status is the exit status, e.g. 2 for "exit status 2". It is 0 when
+	// the process was killed by a signal.
+	Status int
+	// Signal is the description of the signal that killed the process, e.g.
+	// "killed" for "signal: killed". It is empty when the process exited.
+	Signal string
+	// CoreDumped is true if the process was killed by a signal and dumped
+	// core, e.g. "signal: segmentation fault (core dumped)".
+	CoreDumped bool
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// String returns the line as printed by os.ProcessState.String().
+func (e *ExitInfo) String() string {
+	if e.Signal == "" {
+		return "exit status " + strconv.Itoa(e.Status)
+	}
+	if e.CoreDumped {
+		return "signal: " + e.Signal + " (core dumped)"
+	}
+	return "signal: " + e.Signal
+}
+
 // ScanSnapshot scans the Reader for the output from runtime.Stack() in br.
 //
 // Returns nil *Snapshot if no stack trace was detected.
@@ -150,7 +473,8 @@ type Snapshot struct {
 // Returns a nil *Snapshot if no stack trace was detected and SearchSnapshot()
 // was a false positive.
 //
-// Returns io.EOF if all of reader was read.
+// Returns io.EOF if all of reader was read. A line that cannot be parsed is
+// returned as a *ParseError.
 //
 // The suffix of the stack trace is returned as []byte.
 //
@@ -158,6 +482,113 @@ type Snapshot struct {
 // assumes there is junk before the actual stack trace. The junk is streamed to
 // out.
 func ScanSnapshot(in io.Reader, prefix io.Writer, opts *Opts) (*Snapshot, []byte, error) {
+	return scanSnapshot(in, prefix, opts, nil)
+}
+
+// ParseFiles is ScanSnapshot on the concatenation of the files in paths, in
+// order.
+//
+// It is useful to recover a dump that was split across rotated log files. The
+// files are read as a single stream so a goroutine, or even a line, split at a
+// file boundary is parsed as if it was never split.
+//
+// Returns an error without reading anything if a file cannot be opened. Only
+// the first snapshot is parsed; the returned suffix contains the remainder of
+// the files, which can be passed to ScanSnapshot to find the next one.
+func ParseFiles(paths []string, prefix io.Writer, opts *Opts) (*Snapshot, []byte, error) {
+	readers := make([]io.Reader, 0, len(paths))
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			for _, r := range readers {
+				_ = r.(*os.File).Close()
+			}
+			return nil, nil, err
+		}
+		readers = append(readers, f)
+	}
+	defer func() {
+		for _, r := range readers {
+			_ = r.(*os.File).Close()
+		}
+	}()
+	mr := io.MultiReader(readers...)
+	s, suffix, err := ScanSnapshot(mr, prefix, opts)
+	if err == nil {
+		var rest []byte
+		rest, err = ioutil.ReadAll(mr)
+		suffix = append(suffix, rest...)
+	}
+	return s, suffix, err
+}
+
+// ParseDir parses the first snapshot of each file in dir and returns the
+// snapshots found, with the number of snapshots for each distinct
+// Snapshot.Fingerprint().
+//
+// It is useful to triage a directory of crash reports at once. The files are
+// processed in name order; subdirectories and files without a snapshot are
+// skipped. The junk around the snapshots is discarded and a file is not read
+// past the end of its first snapshot.
+//
+// A file that cannot be read or parsed is skipped and doesn't stop the
+// processing of the others; its error is returned in errs, keyed by file name.
+// err is only set if dir itself cannot be read.
+//
+// guessPaths is Opts.GuessPaths; Opts.AnalyzeSources is only enabled with it.
+func ParseDir(dir string, guessPaths bool) (snapshots []*Snapshot, counts map[string]int, errs map[string]error, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	opts := DefaultOpts()
+	opts.GuessPaths = guessPaths
+	opts.AnalyzeSources = guessPaths
+	counts = map[string]int{}
+	errs = map[string]error{}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() {
+			continue
+		}
+		s, err1 := parseFirstSnapshot(filepath.Join(dir, e.Name()), opts)
+		if err1 != nil {
+			errs[e.Name()] = err1
+			continue
+		}
+		if s != nil {
+			snapshots = append(snapshots, s)
+			counts[s.Fingerprint()]++
+		}
+	}
+	return snapshots, counts, errs, nil
+}
+
+// parseFirstSnapshot parses the first snapshot in the file p, without reading
+// the rest of the file.
+//
+// Reaching the end of the file is not an error.
+func parseFirstSnapshot(p string, opts *Opts) (*Snapshot, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	s, _, err := ScanSnapshot(f, ioutil.Discard, opts)
+	if err == io.EOF {
+		err = nil
+	}
+	return s, err
+}
+
+// scanSnapshot implements ScanSnapshot.
+//
+// If emit is set, each goroutine is passed to it as soon as it is completely
+// parsed and is not kept in the Snapshot, with the exception of the
+// goroutines of a race report which are emitted at the end. The
+// post-processing steps that require all the goroutines are skipped.
+func scanSnapshot(in io.Reader, prefix io.Writer, opts *Opts, emit func(g *Goroutine)) (*Snapshot, []byte, error) {
 	if opts == nil || !opts.isValid() {
 		return nil, nil, errors.New("invalid Opts")
 	}
@@ -167,17 +598,124 @@ func ScanSnapshot(in io.Reader, prefix io.Writer, opts *Opts) (*Snapshot, []byte
 			LocalGOROOT:  opts.LocalGOROOT,
 			LocalGOPATHs: opts.LocalGOPATHs,
 		},
-		state: looking,
+		state:         looking,
+		indexedFrames: opts.IndexedFrames,
+		lenient:       opts.Lenient,
+		traceFrames:   opts.TraceFrames,
+		keepRaw:       opts.KeepRaw,
+		strict:        opts.Strict,
+		endOnDedent:   opts.endOnDedent,
+		stateFilter:   opts.StateFilter,
+		maxArgs:       opts.MaxArgs,
+	}
+	if s.maxArgs <= 0 {
+		s.maxArgs = defaultMaxArgs
 	}
 	r := reader{rd: in}
 	var err error
 	var suffix []byte
+	// completed is the number of goroutines in s.Goroutines already passed to
+	// opts.OnGoroutine.
+	completed := 0
+	// notify passes the first n goroutines to opts.OnGoroutine, drops the ones
+	// it rejects and returns the number of goroutines left out of n.
+	notify := func(n int) int {
+		if opts.OnGoroutine == nil {
+			completed = n
+			return n
+		}
+		kept := completed
+		for i := completed; i < n; i++ {
+			if g := s.Goroutines[i]; opts.OnGoroutine(g) {
+				s.Goroutines[kept] = g
+				kept++
+			}
+		}
+		if kept != n {
+			l := len(s.Goroutines)
+			s.Goroutines = append(s.Goroutines[:kept], s.Goroutines[n:]...)
+			for i := len(s.Goroutines); i < l; i++ {
+				s.Goroutines[:l][i] = nil
+			}
+			if s.goroutineIndex >= n {
+				s.goroutineIndex -= n - kept
+			}
+		}
+		completed = kept
+		return kept
+	}
+	// raw are the lines of rawG not yet appended to its Raw field, with
+	// opts.KeepRaw. They are appended once the lines of another goroutine or
+	// the end of the snapshot are read.
+	var rawG *Goroutine
+	var raw []byte
+	flushRaw := func() {
+		if rawG != nil {
+			rawG.Raw += string(raw)
+			rawG = nil
+			raw = raw[:0]
+		}
+	}
+	// lineNum and offset are the position of the next line in the input.
+	lineNum := 1
+	offset := int64(0)
+	// junk writes lines that are not part of the snapshot back to prefix.
+	junk := func(lines ...[]byte) error {
+		for _, p := range lines {
+			if opts.OnLine != nil {
+				opts.OnLine(string(p), LineJunk, nil)
+			}
+			if _, err := prefix.Write(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// raceHeader are the lines of a race report header not yet confirmed by
+	// the following lines. They are reported to opts.OnLine only once
+	// confirmed, and written back to prefix if it turns out not to be a race
+	// report.
+	var raceHeader [][]byte
 	for err == nil && s.state != done {
 		var d []byte
 		if d, err = r.readLine(); len(d) != 0 {
-			l, err1 := s.scan(d)
+			if s.state == looking {
+				if m := rePanicHeader.FindSubmatchIndex(d); m != nil {
+					// The panic message is directly followed by the first goroutine
+					// header, as done by some loggers. Process the message as a line of
+					// its own.
+					p := append(append(make([]byte, 0, m[3]+1), d[:m[3]]...), '\n')
+					s.scanPreamble(p[:m[3]])
+					if err1 := junk(p); err1 != nil && (err == nil || err == io.EOF) {
+						err = err1
+						break
+					}
+					offset += int64(m[4])
+					d = d[m[4]:]
+				}
+			}
+			l, err1 := s.scanLine(d)
 			if err1 != nil && (err == nil || err == io.EOF) {
-				err = err1
+				err = &ParseError{Line: lineNum, Offset: offset, Err: err1}
+			}
+			lineNum++
+			offset += int64(len(d))
+			buffered := l && (s.state == gotRaceHeader1 || s.state == gotRaceHeader2)
+			if buffered {
+				raceHeader = append(raceHeader, append([]byte{}, d...))
+			} else if raceHeader != nil {
+				if !l {
+					if err1 = junk(raceHeader...); err1 != nil && (err == nil || err == io.EOF) {
+						err = err1
+						break
+					}
+				} else if opts.OnLine != nil {
+					// Confirmed.
+					for _, h := range raceHeader {
+						opts.OnLine(string(h), LineSnapshot, nil)
+					}
+				}
+				raceHeader = nil
 			}
 			if !l {
 				if s.state != looking {
@@ -185,13 +723,69 @@ func ScanSnapshot(in io.Reader, prefix io.Writer, opts *Opts) (*Snapshot, []byte
 					suffix = append(suffix, r.buffered()...)
 					break
 				}
-				if _, err1 = prefix.Write(d); err1 != nil && (err == nil || err == io.EOF) {
+				if err1 = junk(d); err1 != nil && (err == nil || err == io.EOF) {
 					err = err1
 					break
 				}
+			} else {
+				if opts.KeepRaw {
+					if g := s.current(); g != rawG {
+						flushRaw()
+						rawG = g
+					}
+					if rawG != nil {
+						raw = append(raw, d...)
+					}
+				}
+				if opts.OnLine != nil && !buffered {
+					if g := s.current(); g != nil {
+						opts.OnLine(string(d), LineGoroutine, g)
+					} else {
+						opts.OnLine(string(d), LineSnapshot, nil)
+					}
+				}
+				if s.Race == nil && len(s.Goroutines) > 1 {
+					// All the goroutines but the last one are complete.
+					n := notify(len(s.Goroutines) - 1)
+					if emit != nil {
+						for _, g := range s.Goroutines[:n] {
+							emit(g)
+						}
+						s.Goroutines = append(s.Goroutines[:0], s.Goroutines[n])
+						s.goroutineIndex -= n
+						completed -= n
+					}
+				}
 			}
 		}
 	}
+	if raceHeader != nil {
+		// The input ended before the race report was confirmed.
+		if err1 := junk(raceHeader...); err1 != nil && (err == nil || err == io.EOF) {
+			err = err1
+		}
+	}
+	if s.state == done && suffix == nil {
+		// The snapshot ended on its last line, e.g. a race report trailer. Keep
+		// what was already read from the reader.
+		suffix = append([]byte{}, r.buffered()...)
+	}
+	flushRaw()
+	s.dropFiltered()
+	if s.Race != nil {
+		s.Race.finish()
+	}
+	notify(len(s.Goroutines))
+	if emit != nil {
+		if s.Goroutines == nil {
+			return nil, suffix, err
+		}
+		for _, g := range s.Goroutines {
+			emit(g)
+		}
+		s.Goroutines = s.Goroutines[:0]
+		return s.Snapshot, suffix, err
+	}
 	if s.Goroutines != nil {
 		if opts.NameArguments {
 			nameArguments(s.Goroutines)
@@ -200,13 +794,132 @@ func ScanSnapshot(in io.Reader, prefix io.Writer, opts *Opts) (*Snapshot, []byte
 			_ = s.guessPaths()
 		}
 		if opts.AnalyzeSources {
-			_ = s.augment()
+			_ = s.augment(opts.SourceResolver)
+		}
+		if opts.ArgTypes != nil {
+			s.applyArgTypes(opts.ArgTypes)
 		}
 		return s.Snapshot, suffix, err
 	}
 	return nil, suffix, err
 }
 
+// ParseFrame parses a single call as printed in a stack trace: the function
+// line, like "main.f(0x1, 0x2)", followed by its source file line, like
+// "\t/path/to/file.go:12 +0x1a".
+//
+// The file line must be indented more than the function line, as printed by
+// the runtime and the race detector. Trailing EOL characters are ignored.
+//
+// Only the fields derived from the lines themselves are initialized; the
+// paths are not resolved against GOROOT or GOPATH.
+func ParseFrame(funcLine, fileLine string) (*Call, error) {
+	fn := trimEOL([]byte(funcLine))
+	fl := trimEOL([]byte(fileLine))
+	fnTrimmed := trimLeftSpace(fn)
+	if len(fl)-len(trimLeftSpace(fl)) <= len(fn)-len(fnTrimmed) {
+		return nil, fmt.Errorf("file line %q is not indented more than function line %q", fileLine, funcLine)
+	}
+	c := &Call{}
+	if ok, err := parseFunc(c, fnTrimmed, defaultMaxArgs); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("failed to parse function line: %q", funcLine)
+	}
+	if ok, err := parseFile(c, fl); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("failed to parse file line: %q", fileLine)
+	}
+	return c, nil
+}
+
+// ParseDebugStack parses the output of runtime/debug.Stack(), as commonly
+// embedded in error messages.
+//
+// Text before the goroutine header is ignored. The header may be missing, in
+// which case s must start with the stack and the returned goroutine ID is 0
+// and its state is "running". The stack may be indented, as long as all its
+// lines share the same indentation. Text after the stack is ignored.
+//
+// Only the fields derived from the lines themselves are initialized; the
+// paths are not resolved against GOROOT or GOPATH.
+func ParseDebugStack(s string) (*Goroutine, error) {
+	s = strings.TrimLeft(s, "\r\n")
+	hasHeader := false
+	for _, l := range strings.Split(s, "\n") {
+		if reRoutineHeader.MatchString(strings.TrimRight(l, "\r")) {
+			hasHeader = true
+			break
+		}
+	}
+	if !hasHeader {
+		indent := s[:len(s)-len(trimLeftSpace([]byte(s)))]
+		s = indent + "goroutine 0 [running]:\n" + s
+	}
+	snap, _, err := ScanSnapshot(strings.NewReader(s), ioutil.Discard, &Opts{endOnDedent: true})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if snap == nil || len(snap.Goroutines) == 0 {
+		return nil, errors.New("no stack found")
+	}
+	if len(snap.Goroutines) != 1 {
+		return nil, fmt.Errorf("expected one goroutine, found %d", len(snap.Goroutines))
+	}
+	return snap.Goroutines[0], nil
+}
+
+// ParseDelveStack parses the output of the Delve debugger "goroutine <id>
+// stack" command, as the goroutine id.
+//
+// Each frame is printed as a function line, like
+// "0  0x000000000046c4e1 in runtime.gopark", followed by its file line, like
+// "   at /goroot/src/runtime/proc.go:398". Other lines, like deferred calls,
+// are ignored. A "(truncated)" line marks the stack as elided.
+//
+// Delve doesn't print the goroutine state nor the arguments, so they are left
+// empty. Only the fields derived from the lines themselves are initialized;
+// the paths are not resolved against GOROOT or GOPATH.
+func ParseDelveStack(id int, s string) (*Goroutine, error) {
+	g := &Goroutine{ID: id, First: true}
+	var c *Call
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if c != nil {
+			match := reDelveFile.FindStringSubmatch(l)
+			if match == nil {
+				return nil, fmt.Errorf("expected a file after a function, got: %q", strings.TrimSpace(l))
+			}
+			line, ok := atou([]byte(match[2]))
+			if !ok {
+				return nil, fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(l))
+			}
+			c.init(match[1], line)
+			g.Stack.Calls = append(g.Stack.Calls, *c)
+			c = nil
+			continue
+		}
+		if match := reDelveFunc.FindStringSubmatch(l); match != nil {
+			c = &Call{}
+			if err := c.Func.Init(match[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if strings.TrimSpace(l) == "(truncated)" {
+			g.Stack.Elided = true
+		}
+	}
+	if c != nil {
+		return nil, fmt.Errorf("expected a file after function %q", c.Func.Complete)
+	}
+	if len(g.Stack.Calls) == 0 {
+		return nil, errors.New("no stack found")
+	}
+	return g, nil
+}
+
 // IsRace returns true if a race detector stack trace was found.
 //
 // Otherwise, it is a normal goroutines snapshot.
@@ -216,6 +929,47 @@ func (s *Snapshot) IsRace() bool {
 	return s.Goroutines[0].RaceAddr != 0
 }
 
+// InferGOROOT infers the GOROOT used by the process that generated the
+// snapshot from the paths of the runtime source files found in the stack
+// traces. It does no disk I/O.
+//
+// It is not a pure getter: it sets RemoteGOROOT to the GOROOT found if
+// RemoteGOROOT is not yet set. When it does so, the calls in the standard
+// library also have their RelSrcPath, LocalSrcPath, ImportPath and Location
+// updated. This is useful when Opts.GuessPaths was false, for example when
+// processing a snapshot from another host.
+//
+// Returns the GOROOT found, or an empty string if no runtime source file was
+// found.
+func (s *Snapshot) InferGOROOT() string {
+	const runtimeSrc = "/src/runtime/"
+	counts := map[string]int{}
+	root := ""
+	for _, g := range s.Goroutines {
+		for _, st := range []*Stack{&g.Stack, &g.CreatedBy} {
+			for i := range st.Calls {
+				p := st.Calls[i].RemoteSrcPath
+				j := strings.Index(p, runtimeSrc)
+				if j == -1 {
+					continue
+				}
+				r := p[:j]
+				counts[r]++
+				if root == "" || counts[r] > counts[root] || (counts[r] == counts[root] && r < root) {
+					root = r
+				}
+			}
+		}
+	}
+	if root != "" && s.RemoteGOROOT == "" {
+		s.RemoteGOROOT = root
+		for _, g := range s.Goroutines {
+			g.updateLocations(s.RemoteGOROOT, s.LocalGOROOT, nil, nil)
+		}
+	}
+	return root
+}
+
 func (s *Snapshot) guessPaths() bool {
 	b := s.findRoots() == 0
 	for _, r := range s.Goroutines {
@@ -229,13 +983,14 @@ func (s *Snapshot) guessPaths() bool {
 // augment processes source files to improve calls to be more descriptive.
 //
 // It modifies goroutines in place. It requires calling guessPaths() to work
-// properly.
+// properly, unless resolve is set.
 //
 // Returns the last error that occurred while processing files.
-func (s *Snapshot) augment() error {
+func (s *Snapshot) augment(resolve func(srcPath string, line int) (io.ReadCloser, error)) error {
 	c := cacheAST{
-		files:  map[string][]byte{},
-		parsed: map[string]*parsedFile{},
+		files:   map[string][]byte{},
+		parsed:  map[string]*parsedFile{},
+		resolve: resolve,
 	}
 	var err error
 	for _, g := range s.Goroutines {
@@ -248,11 +1003,82 @@ func (s *Snapshot) augment() error {
 
 // Private stuff.
 
+// applyArgTypes sets Args.Processed with the parameters returned by argTypes
+// for the calls that were not already processed.
+func (s *Snapshot) applyArgTypes(argTypes func(funcRaw string) []string) {
+	for _, g := range s.Goroutines {
+		for i := range g.Stack.Calls {
+			a := &g.Stack.Calls[i].Args
+			if len(a.Processed) != 0 || a.Elided || len(a.Values) == 0 {
+				continue
+			}
+			types := argTypes(g.Stack.Calls[i].Func.Complete)
+			if len(types) != len(a.Values) {
+				continue
+			}
+			a.Processed = make([]string, len(types))
+			for j, t := range types {
+				a.Processed[j] = t + " = " + a.Values[j].String()
+			}
+		}
+	}
+}
+
 const pathSeparator = string(filepath.Separator)
 
+// defaultMaxArgs is the default value for Opts.MaxArgs.
+const defaultMaxArgs = 1000
+
+// signalCodes are the names of the signal codes on Linux, by signal name. The
+// codes under "" are shared by all signals.
+var signalCodes = map[string]map[int]string{
+	"": {
+		0:    "SI_USER",
+		0x80: "SI_KERNEL",
+		-1:   "SI_QUEUE",
+		-2:   "SI_TIMER",
+		-3:   "SI_MESGQ",
+		-4:   "SI_ASYNCIO",
+		-5:   "SI_SIGIO",
+		-6:   "SI_TKILL",
+	},
+	"SIGSEGV": {
+		1: "SEGV_MAPERR",
+		2: "SEGV_ACCERR",
+		3: "SEGV_BNDERR",
+		4: "SEGV_PKUERR",
+	},
+	"SIGBUS": {
+		1: "BUS_ADRALN",
+		2: "BUS_ADRERR",
+		3: "BUS_OBJERR",
+		4: "BUS_MCEERR_AR",
+		5: "BUS_MCEERR_AO",
+	},
+	"SIGFPE": {
+		1: "FPE_INTDIV",
+		2: "FPE_INTOVF",
+		3: "FPE_FLTDIV",
+		4: "FPE_FLTOVF",
+		5: "FPE_FLTUND",
+		6: "FPE_FLTRES",
+		7: "FPE_FLTINV",
+		8: "FPE_FLTSUB",
+	},
+	"SIGILL": {
+		1: "ILL_ILLOPC",
+		2: "ILL_ILLOPN",
+		3: "ILL_ILLADR",
+		4: "ILL_ILLTRP",
+		5: "ILL_PRVOPC",
+		6: "ILL_PRVREG",
+		7: "ILL_COPROC",
+		8: "ILL_BADSTK",
+	},
+}
+
 var (
 	lockedToThread = []byte("locked to thread")
-	framesElided   = []byte("...additional frames elided...")
 	// gotRaceHeader1, done
 	raceHeaderFooter = []byte("==================")
 	// gotRaceHeader2
@@ -263,13 +1089,48 @@ var (
 	writeCap   = []byte("Write")
 	writeLow   = []byte("write")
 	threeDots  = []byte("...")
+	// looking
+	runtimePrefix = []byte("runtime: ")
+	// gotRaceGoroutineHeader
+	failedRestoreStack = []byte("[failed to restore the stack]")
+	// gotFileFunc
+	elided = []byte("elided")
 )
 
 // These are effectively constants.
 var (
+	// looking
+	reSignal     = regexp.MustCompile(`^(SIG[A-Z0-9]+): (.+)$`)
+	reSignalPC   = regexp.MustCompile(`^PC=(0x[0-9a-f]+) m=(\d+) sigcode=(\d+)`)
+	reFatalError = regexp.MustCompile(`^fatal error: (.+)$`)
+	rePanic      = regexp.MustCompile(`^(\t?)panic: (.*?)( \[recovered(?:, repanicked)?\])?$`)
+	// A panic message joined with the first goroutine header.
+	rePanicHeader = regexp.MustCompile(`^(panic: .*?)[ \t]*(goroutine \d+ \[[^\]]*\]:\r?\n?)$`)
+	// looking, gotRoutineHeader
+	// The signal name is printed as hex when it is unknown to the runtime.
+	reSignalPanic = regexp.MustCompile(`^\[signal (?:(SIG[A-Z0-9]+): (.+)|(0x[0-9a-f]+)) code=(0x[0-9a-f]+) addr=(0x[0-9a-f]+) pc=(0x[0-9a-f]+)\]$`)
+
+	// betweenRoutine, gotFileFunc, gotFileCreated, gotSkipped
+	// Printed by os.ProcessState.String().
+	reExitStatus = regexp.MustCompile(`^exit status (\d+)$`)
+	reExitSignal = regexp.MustCompile(`^signal: (.+?)( \(core dumped\))?$`)
+
+	// ParseDelveStack
+	reDelveFunc = regexp.MustCompile(`^\s*\d+\s+0x[0-9a-f]+ in (\S+)$`)
+	reDelveFile = regexp.MustCompile(`^\s+at (.+):(\d+)$`)
+
 	// gotRoutineHeader
-	reRoutineHeader = regexp.MustCompile("^([ \t]*)goroutine (\\d+) \\[([^\\]]+)\\]\\:$")
+	// The state is normally never empty but tolerate it for homegrown dumpers.
+	// Since Go 1.21, "gp=0x123 m=1 mp=0x123" or "gp=0x123 m=nil" is printed
+	// before the state when the runtime throws.
+	reRoutineHeader = regexp.MustCompile("^([ \t]*)goroutine (\\d+)(?: gp=(0x[0-9a-f]+)(?: m=(?:nil|(\\d+) mp=(0x[0-9a-f]+)))?)? \\[([^\\]]*)\\]\\:$")
 	reMinutes       = regexp.MustCompile(`^(\d+) minutes$`)
+	// The state may have a qualifier, like "GC worker (idle)".
+	reStateDetail = regexp.MustCompile(`^.+ \(([^()]+)\)$`)
+
+	// betweenRoutine
+	// The dump may be truncated when there are too many goroutines.
+	reSkipped = regexp.MustCompile(`^\s*\.\.\.\s*(\d+) goroutines?,? skipping\s*\.\.\.\s*$`)
 
 	// gotUnavail
 	reUnavail = regexp.MustCompile("^(?:\t| +)goroutine running on other thread; stack unavailable")
@@ -291,23 +1152,38 @@ var (
 	//   when a signal is not correctly handled. It is printed with m.throwing>0.
 	//   These are discarded.
 	// - For cgo, the source file may be "??".
-	reFile = regexp.MustCompile("^(?:\t| +)(\\?\\?|\\<autogenerated\\>|.+\\.(?:c|go|s))\\:(\\d+)(?:| \\+0x[0-9a-f]+)(?:| fp=0x[0-9a-f]+ sp=0x[0-9a-f]+(?:| pc=0x[0-9a-f]+))$")
+	// - The path can't start with whitespace, so the file lines indented with 2
+	//   tabs by "go tool trace" are only accepted with Opts.TraceFrames.
+	reFile = regexp.MustCompile("^(?:\t| +)(\\?\\?|\\<autogenerated\\>|\\S.*\\.(?:c|go|s))\\:(\\d+)(?:| \\+(0x[0-9a-f]+))(?:| fp=0x[0-9a-f]+ sp=0x[0-9a-f]+(?:| pc=0x[0-9a-f]+))$")
 
 	// gotCreated
-	// Sadly, it doesn't note the goroutine number so we could cascade them per
-	// parenthood.
-	reCreated = regexp.MustCompile("^created by (.+)$")
+	// - Since Go 1.21, the creator goroutine ID is printed as
+	//   "created by main.main in goroutine 1".
+	// - Some experimental builds append more metadata, like
+	//   ", started at 12:34:56". It is kept as is in CreatedByInfo.
+	reCreated = regexp.MustCompile(`^created by ([^ ]+)(?: in goroutine (\d+))?(.*)$`)
+
+	// gotFileFunc
+	// The runtime prints "...additional frames elided...". Tolerate reflowed
+	// dumps with extra whitespace or an unicode ellipsis instead of "...".
+	reFramesElided = regexp.MustCompile(`^\s*(?:\.\.\.|…)\s*additional\s+frames\s+elided\s*(?:\.\.\.|…)\s*$`)
 
 	// gotFunc, gotRaceOperationFunc, gotRaceGoroutineFunc
 	reFunc = regexp.MustCompile(`^(.+)\((.*)\)$`)
+	// gotRoutineHeader, gotFileFunc with Opts.IndexedFrames
+	reFrameIndex = regexp.MustCompile(`^\d+: ([^ ]+(?:\(.*\))?)$`)
+	// gotRoutineHeader, gotFileFunc with Opts.TraceFrames
+	reTraceFrame = regexp.MustCompile(`^([^ ]+) @ 0x[0-9a-f]+$`)
+	// gotRoutineHeader, gotFileFunc, gotFileCreated with Opts.Lenient
+	// A function or created line joined with its file line by a third party
+	// tool.
+	reJoinedFrame = regexp.MustCompile(`^(\s*)((?:created by \S+(?: in goroutine \d+)?)|\S+\(.*\))(?:\s+|\s*(?:\||@|->|;)\s*|\s+at\s+)(\S+\.(?:c|go|s):\d+(?: \+0x[0-9a-f]+)?(?: fp=0x[0-9a-f]+ sp=0x[0-9a-f]+(?: pc=0x[0-9a-f]+)?)?)$`)
 
 	// Race:
 	// See https://github.com/llvm/llvm-project/blob/master/compiler-rt/lib/tsan/rtl/tsan_report.cpp
 	// for the code generating these messages. Please note only the block in
 	//   #else  // #if !SANITIZER_GO
 	// is used.
-	// TODO(maruel): "    [failed to restore the stack]\n\n"
-	// TODO(maruel): "Global var %s of size %zu at %p declared at %s:%zu\n"
 
 	// gotRaceOperationHeader
 	reRaceOperationHeader = regexp.MustCompile(`^(Read|Write) at (0x[0-9a-f]+) by goroutine (\d+):$`)
@@ -315,6 +1191,14 @@ var (
 	// gotRaceOperationHeader
 	reRacePreviousOperationHeader = regexp.MustCompile(`^Previous (read|write) at (0x[0-9a-f]+) by goroutine (\d+):$`)
 
+	// gotRaceGlobal
+	// The global variable accessed, printed after the operations.
+	reRaceGlobal = regexp.MustCompile(`^Global var (.+) of size (\d+) at (0x[0-9a-f]+) declared at (.+):(\d+)$`)
+
+	// gotRaceFooter
+	// Printed by the race detector when the process exits.
+	reRaceCount = regexp.MustCompile(`^Found (\d+) data race\(s\)$`)
+
 	// gotRaceGoroutineHeader
 	reRaceGoroutine = regexp.MustCompile(`^Goroutine (\d+) \((running|finished)\) created at:$`)
 
@@ -338,13 +1222,13 @@ const (
 
 	// Signature: ""
 	// An empty line between goroutines.
-	// from: gotFileCreated, gotFileFunc
-	// to: gotRoutineHeader, done
+	// from: gotFileCreated, gotFileFunc, gotSkipped
+	// to: gotRoutineHeader, gotSkipped, done
 	betweenRoutine
 	// Regexp: reRoutineHeader
 	// Signature: "goroutine 1 [running]:"
 	// Goroutine header was found.
-	// from: looking
+	// from: looking, betweenRoutine, gotFileFunc, gotFileCreated, gotSkipped
 	// to: gotUnavail, gotFunc
 	gotRoutineHeader
 	// Regexp: reFunc
@@ -363,13 +1247,13 @@ const (
 	// Signature: "\t/foo/bar/baz.go:116 +0x35"
 	// File header was found.
 	// from: gotFunc
-	// to: gotFunc, gotCreated, betweenRoutine, done
+	// to: gotFunc, gotCreated, betweenRoutine, gotRoutineHeader, done
 	gotFileFunc
 	// Regexp: reFile
 	// Signature: "\t/foo/bar/baz.go:116 +0x35"
 	// File header was found.
 	// from: gotCreated
-	// to: betweenRoutine, done
+	// to: betweenRoutine, gotRoutineHeader, done
 	gotFileCreated
 	// Regexp: reUnavail
 	// Signature: "goroutine running on other thread; stack unavailable"
@@ -377,6 +1261,12 @@ const (
 	// from: gotRoutineHeader
 	// to: betweenRoutine, gotCreated
 	gotUnavail
+	// Regexp: reSkipped
+	// Signature: "...10000 goroutines, skipping..."
+	// Goroutines were not printed.
+	// from: betweenRoutine
+	// to: betweenRoutine, gotRoutineHeader, done
+	gotSkipped
 
 	// Race detector:
 
@@ -392,9 +1282,9 @@ const (
 	gotRaceHeader2
 	// Regexp: reRaceOperationHeader, reRacePreviousOperationHeader
 	// Signature: "Read at 0x00c0000e4030 by goroutine 7:"
-	// A race operation was found.
-	// from: gotRaceHeader2
-	// to: done, gotRaceOperationFunc
+	// A race operation was found. There can be multiple previous operations.
+	// from: gotRaceHeader2, betweenRaceOperations
+	// to: done, gotRaceOperationFunc, gotRaceOperationFile
 	gotRaceOperationHeader
 	// Regexp: reFunc
 	// Signature: "  main.panicRace.func1()"
@@ -404,21 +1294,29 @@ const (
 	gotRaceOperationFunc
 	// Regexp: reFile
 	// Signature: "\t/foo/bar/baz.go:116 +0x35"
-	// File header that caused the race.
-	// from: gotRaceOperationFunc
+	// File header that caused the race. Also the state after
+	// "    [failed to restore the stack]".
+	// from: gotRaceOperationHeader, gotRaceOperationFunc
 	// to: done, betweenRaceOperations, gotRaceOperationFunc
 	gotRaceOperationFile
 	// Signature: ""
 	// Empty line between race operations or just after.
 	// from: gotRaceOperationFile
-	// to: done, gotRaceOperationHeader, gotRaceGoroutineHeader
+	// to: done, gotRaceOperationHeader, gotRaceGlobal, gotRaceGoroutineHeader
 	betweenRaceOperations
+	// Regexp: reRaceGlobal
+	// Signature: "Global var x of size 8 at 0x00000064d560 declared at /foo/bar/baz.go:10"
+	// Global variable that was accessed.
+	// from: betweenRaceOperations
+	// to: betweenRaceGoroutines, gotRaceFooter
+	gotRaceGlobal
 
 	// Regexp: reRaceGoroutine
 	// Signature: "Goroutine 7 (running) created at:"
 	// Goroutine header.
 	// from: betweenRaceOperations, betweenRaceGoroutines
-	// to: done, gotRaceOperationHeader
+	// to: done, gotRaceGoroutineFunc, gotRaceGoroutineFile,
+	//     betweenRaceGoroutines, gotRaceFooter
 	gotRaceGoroutineHeader
 	// Regexp: reFunc
 	// Signature: "  main.panicRace.func1()"
@@ -430,13 +1328,19 @@ const (
 	// Signature: "\t/foo/bar/baz.go:116 +0x35"
 	// File header that caused the race.
 	// from: gotRaceGoroutineFunc
-	// to: done, betweenRaceGoroutines
+	// to: betweenRaceGoroutines, gotRaceFooter
 	gotRaceGoroutineFile
 	// Signature: ""
 	// Empty line between race stack traces.
-	// from: gotRaceGoroutineFile
+	// from: gotRaceGoroutineHeader, gotRaceGoroutineFile, gotRaceGlobal
 	// to: done, gotRaceGoroutineHeader
 	betweenRaceGoroutines
+	// Constant: raceHeaderFooter
+	// Signature: "=================="
+	// End of the race report.
+	// from: gotRaceGoroutineHeader, gotRaceGoroutineFile, gotRaceGlobal
+	// to: done
+	gotRaceFooter
 )
 
 // scanningState is the state of the scan to detect and process a stack trace
@@ -446,6 +1350,67 @@ type scanningState struct {
 	state          state
 	prefix         []byte
 	goroutineIndex int
+	// signal is the signal found while looking for goroutines. It is moved into
+	// Snapshot when the first goroutine is found.
+	signal *Signal
+	// fatal is the fatal error found while looking for goroutines. It is moved
+	// into Snapshot when the first goroutine is found.
+	fatal *FatalError
+	// panics are the panics found while looking for goroutines. They are moved
+	// into Snapshot when the first goroutine is found.
+	panics []*Panic
+	// runtimeMessages are the "runtime: " lines found while looking for
+	// goroutines, not yet associated with a fatal error.
+	runtimeMessages []string
+	// indexedFrames is Opts.IndexedFrames.
+	indexedFrames bool
+	// lenient is Opts.Lenient.
+	lenient bool
+	// traceFrames is Opts.TraceFrames.
+	traceFrames bool
+	// keepRaw is Opts.KeepRaw.
+	keepRaw bool
+	// strict is Opts.Strict.
+	strict bool
+	// endOnDedent is Opts.endOnDedent.
+	endOnDedent bool
+	// stateFilter is Opts.StateFilter.
+	stateFilter func(state string) bool
+	// maxArgs is Opts.MaxArgs, or defaultMaxArgs.
+	maxArgs int
+	// filtered is true when the last goroutine was rejected by stateFilter. It
+	// is removed once it is completely parsed.
+	filtered bool
+}
+
+// scanLine scans one line as read from the input.
+//
+// With Opts.Lenient, a function line joined with its file line is split and
+// both are scanned.
+func (s *scanningState) scanLine(line []byte) (bool, error) {
+	if !utf8.Valid(line) {
+		// Paths and function names are processed per rune later on, make sure
+		// mojibake doesn't confuse it. The original line is still used for
+		// prefix, suffix and Goroutine.Raw.
+		line = toValidUTF8(line)
+	}
+	if s.lenient && s.state != looking && s.state != done {
+		t := trimEOL(line)
+		if m := reJoinedFrame.FindSubmatchIndex(t); m != nil {
+			// Split it in a function line and a file line, keeping the original
+			// indentation and line ending.
+			eol := line[len(t):]
+			f := make([]byte, 0, m[5]+1)
+			f = append(append(f, t[:m[5]]...), '\n')
+			if l, err := s.scan(f); !l || err != nil {
+				return l, err
+			}
+			fl := make([]byte, 0, m[3]+1+m[7]-m[6]+len(eol))
+			fl = append(append(append(append(fl, t[:m[3]]...), '\t'), t[m[6]:m[7]]...), eol...)
+			return s.scan(fl)
+		}
+	}
+	return s.scan(line)
 }
 
 // scan scans one line, updates goroutines and move to the next state.
@@ -484,6 +1449,12 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 		// This can only be the case if s.state != looking | done or the line is
 		// empty.
 		if !bytes.HasPrefix(trimmed, s.prefix) {
+			if s.endOnDedent && (s.state == betweenRoutine || s.state == gotFileFunc || s.state == gotFileCreated) {
+				// The snapshot is complete, this is the text following it.
+				s.state = done
+				s.prefix = nil
+				return false, nil
+			}
 			prefix := s.prefix
 			s.state = done
 			s.prefix = nil
@@ -503,65 +1474,52 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 
 	case betweenRoutine:
 		// Look for a goroutine header.
-		if match := reRoutineHeader.FindSubmatch(trimmed); match != nil {
-			if id, ok := atou(match[2]); ok {
-				// See runtime/traceback.go.
-				// "<state>, \d+ minutes, locked to thread"
-				items := bytes.Split(match[3], commaSpace)
-				sleep := 0
-				locked := false
-				for i := 1; i < len(items); i++ {
-					if bytes.Equal(items[i], lockedToThread) {
-						locked = true
-						continue
-					}
-					// Look for duration, if any.
-					if match2 := reMinutes.FindSubmatch(items[i]); match2 != nil {
-						sleep, _ = atou(match2[1])
-					}
-				}
-				g := &Goroutine{
-					Signature: Signature{
-						State:    string(items[0]),
-						SleepMin: sleep,
-						SleepMax: sleep,
-						Locked:   locked,
-					},
-					ID:    id,
-					First: len(s.Goroutines) == 0,
-				}
-				// Increase performance by always allocating 4 goroutines minimally.
-				if s.Goroutines == nil {
-					s.Goroutines = make([]*Goroutine, 0, 4)
-				}
-				s.Goroutines = append(s.Goroutines, g)
-				s.state = gotRoutineHeader
-				s.prefix = append([]byte{}, match[1]...)
-				return true, nil
-			}
+		if s.scanRoutineHeader(trimmed) {
+			return true, nil
 		}
 		// Switch to race detection mode.
 		if bytes.Equal(trimmed, raceHeaderFooter) {
-			// TODO(maruel): We should buffer it in case the next line is not a
-			// WARNING so we can output it back.
+			// The lines are buffered by scanSnapshot() until the report is
+			// confirmed.
 			s.state = gotRaceHeader1
 			return true, nil
 		}
 		if s.state != looking {
+			if match := reSkipped.FindSubmatch(trimmed); match != nil {
+				if n, ok := atou(match[1]); ok {
+					s.SkippedGoroutines += n
+					s.state = gotSkipped
+					return true, nil
+				}
+			}
+			if s.scanExitInfo(trimmed) {
+				return true, nil
+			}
 			s.state = done
+		} else {
+			s.scanPreamble(trimmed)
 		}
 		return false, nil
 
 	case gotRoutineHeader:
+		if sig := parseSignalPanic(trimLeftSpace(trimmed)); sig != nil {
+			// Some dumps print the signal after the header of the crashing
+			// goroutine instead of before the goroutines.
+			if s.Signal == nil {
+				s.Signal = sig
+			}
+			return true, nil
+		}
 		if reUnavail.Match(trimmed) {
 			// Generate a fake stack entry.
-			cur.Stack.Calls = []Call{{RemoteSrcPath: "<unavailable>"}}
+			cur.Stack.Calls = []Call{{RemoteSrcPath: SrcUnavailable}}
 			// Next line is expected to be an empty line.
 			s.state = gotUnavail
 			return true, nil
 		}
 		c := Call{}
-		if found, err := parseFunc(&c, trimmed); found {
+		// Some tools indent the function lines, like the race detector does.
+		if found, err := s.parseFrameFunc(&c, trimLeftSpace(trimmed)); found {
 			// Increase performance by always allocating 4 calls minimally.
 			if cur.Stack.Calls == nil {
 				cur.Stack.Calls = make([]Call, 0, 4)
@@ -574,7 +1532,7 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 
 	case gotFunc:
 		// cur.Stack.Calls is guaranteed to have at least one item.
-		if found, err := parseFile(&cur.Stack.Calls[len(cur.Stack.Calls)-1], trimmed); err != nil {
+		if found, err := s.parseFrameFile(&cur.Stack.Calls[len(cur.Stack.Calls)-1], trimmed); err != nil {
 			return false, err
 		} else if !found {
 			return false, fmt.Errorf("expected a file after a function, got: %q", bytes.TrimSpace(trimmed))
@@ -592,24 +1550,23 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 		return true, nil
 
 	case gotFileFunc:
-		if match := reCreated.FindSubmatch(trimmed); match != nil {
-			cur.CreatedBy.Calls = make([]Call, 1)
-			if err := cur.CreatedBy.Calls[0].Func.Init(string(match[1])); err != nil {
-				cur.CreatedBy.Calls = nil
+		// Some tools indent the whole stack, including the "created by" line,
+		// like gVisor does with 2 spaces.
+		if match := reCreated.FindSubmatch(trimLeftSpace(trimmed)); match != nil {
+			if err := parseCreated(cur, match); err != nil {
 				return false, err
 			}
-			// This initializes ImportPath.
-			cur.CreatedBy.Calls[0].init("", 0)
 			s.state = gotCreated
 			return true, nil
 		}
-		if bytes.Equal(trimmed, framesElided) {
+		// Skip the regexp on the common function lines.
+		if bytes.Contains(trimmed, elided) && reFramesElided.Match(trimmed) {
 			cur.Stack.Elided = true
 			// TODO(maruel): New state.
 			return true, nil
 		}
 		c := Call{}
-		if found, err := parseFunc(&c, trimmed); found {
+		if found, err := s.parseFrameFunc(&c, trimLeftSpace(trimmed)); found {
 			// Increase performance by always allocating 4 calls minimally.
 			if cur.Stack.Calls == nil {
 				cur.Stack.Calls = make([]Call, 0, 4)
@@ -622,26 +1579,36 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 			s.state = betweenRoutine
 			return true, nil
 		}
-		s.state = done
-		return false, nil
+		// Some tools strip the empty line between goroutines.
+		if s.scanRoutineHeader(trimmed) {
+			return true, nil
+		}
+		if s.scanExitInfo(trimmed) {
+			return true, nil
+		}
+		return false, s.endRoutine(trimmed)
 
 	case gotFileCreated:
 		if len(trimmed) == 0 {
 			s.state = betweenRoutine
 			return true, nil
 		}
-		s.state = done
-		return false, nil
+		// Some tools strip the empty line between goroutines.
+		if s.scanRoutineHeader(trimmed) {
+			return true, nil
+		}
+		if s.scanExitInfo(trimmed) {
+			return true, nil
+		}
+		return false, s.endRoutine(trimmed)
 
 	case gotUnavail:
 		if len(trimmed) == 0 {
 			s.state = betweenRoutine
 			return true, nil
 		}
-		if match := reCreated.FindSubmatch(trimmed); match != nil {
-			cur.CreatedBy.Calls = make([]Call, 1)
-			if err := cur.CreatedBy.Calls[0].Func.Init(string(match[1])); err != nil {
-				cur.CreatedBy.Calls = nil
+		if match := reCreated.FindSubmatch(trimLeftSpace(trimmed)); match != nil {
+			if err := parseCreated(cur, match); err != nil {
 				return false, err
 			}
 			s.state = gotCreated
@@ -649,17 +1616,30 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 		}
 		return false, fmt.Errorf("expected empty line after unavailable stack, got: %q", bytes.TrimSpace(trimmed))
 
+	case gotSkipped:
+		if len(trimmed) == 0 {
+			s.state = betweenRoutine
+			return true, nil
+		}
+		if s.scanRoutineHeader(trimmed) {
+			return true, nil
+		}
+		if s.scanExitInfo(trimmed) {
+			return true, nil
+		}
+		return false, s.endRoutine(trimmed)
+
 		// Race detector.
 
 	case gotRaceHeader1:
 		if bytes.Equal(trimmed, raceHeader) {
-			// TODO(maruel): We should buffer it in case the next line is not a
-			// WARNING so we can output it back.
+			// The lines are buffered by scanSnapshot() until the report is
+			// confirmed.
 			s.state = gotRaceHeader2
 			return true, nil
 		}
-		// TODO(maruel): While this shouldn't error out, it should still force the
-		// output of raceHeaderFooter.
+		// Not a race report. The header line is written back to prefix by
+		// scanSnapshot().
 		s.state = looking
 		s.prefix = nil
 		return false, nil
@@ -678,16 +1658,26 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 			if s.Goroutines != nil {
 				panic("internal failure; expected s.Goroutines to be nil")
 			}
-			s.Goroutines = append(make([]*Goroutine, 0, 4), &Goroutine{ID: id, First: true, RaceWrite: w, RaceAddr: addr})
+			g := &Goroutine{ID: id, First: true, RaceWrite: w, RaceAddr: addr}
+			s.Goroutines = append(make([]*Goroutine, 0, 4), g)
 			s.goroutineIndex = len(s.Goroutines) - 1
+			s.Race = &RaceReport{Operations: []*RaceOperation{{Write: w, Addr: addr, Goroutine: g}}}
 			s.state = gotRaceOperationHeader
 			return true, nil
 		}
 		return false, fmt.Errorf("expected race condition, got: %q", bytes.TrimSpace(trimmed))
 
 	case gotRaceOperationHeader:
+		// The stack of the access may not be restorable, for example when it was
+		// done long before the racing one. Keep the operation with a placeholder
+		// call, see Call.StackUnavailable().
+		if bytes.Equal(trimLeftSpace(trimmed), failedRestoreStack) {
+			cur.Stack.Calls = []Call{{RemoteSrcPath: SrcUnavailable}}
+			s.state = gotRaceOperationFile
+			return true, nil
+		}
 		c := Call{}
-		if found, err := parseFunc(&c, trimLeftSpace(trimmed)); found {
+		if found, err := parseFunc(&c, trimLeftSpace(trimmed), s.maxArgs); found {
 			// Increase performance by always allocating 4 calls minimally.
 			if cur.Stack.Calls == nil {
 				cur.Stack.Calls = make([]Call, 0, 4)
@@ -713,7 +1703,7 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 			return true, nil
 		}
 		c := Call{}
-		if found, err := parseFunc(&c, trimLeftSpace(trimmed)); found {
+		if found, err := parseFunc(&c, trimLeftSpace(trimmed), s.maxArgs); found {
 			cur.Stack.Calls = append(cur.Stack.Calls, c)
 			s.state = gotRaceOperationFunc
 			return err == nil, err
@@ -732,11 +1722,30 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 			if !ok {
 				return false, fmt.Errorf("failed to parse goroutine id on line: %q", bytes.TrimSpace(trimmed))
 			}
-			s.Goroutines = append(s.Goroutines, &Goroutine{ID: id, RaceWrite: w, RaceAddr: addr})
+			g := &Goroutine{ID: id, RaceWrite: w, RaceAddr: addr}
+			s.Goroutines = append(s.Goroutines, g)
 			s.goroutineIndex = len(s.Goroutines) - 1
+			s.Race.Operations = append(s.Race.Operations, &RaceOperation{Write: w, Addr: addr, Goroutine: g})
 			s.state = gotRaceOperationHeader
 			return true, nil
 		}
+		if match := reRaceGlobal.FindSubmatch(trimmed); match != nil {
+			size, err := strconv.ParseUint(string(match[2]), 10, 64)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse size on line: %q", bytes.TrimSpace(trimmed))
+			}
+			addr, err := strconv.ParseUint(string(match[3]), 0, 64)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse address on line: %q", bytes.TrimSpace(trimmed))
+			}
+			num, ok := atou(match[5])
+			if !ok {
+				return false, fmt.Errorf("failed to parse int on line: %q", bytes.TrimSpace(trimmed))
+			}
+			s.Race.Global = &RaceGlobal{Name: string(match[1]), Size: size, Addr: addr, SrcPath: string(match[4]), Line: num}
+			s.state = gotRaceGlobal
+			return true, nil
+		}
 		fallthrough
 
 	case betweenRaceGoroutines:
@@ -771,7 +1780,6 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 		} else if !found {
 			return false, fmt.Errorf("expected a file after a race function, got: %q", trimmed)
 		}
-		// TODO(maruel): Set s.Goroutines[].CreatedBy.
 		s.state = gotRaceGoroutineFile
 		return true, nil
 
@@ -781,29 +1789,338 @@ func (s *scanningState) scan(line []byte) (bool, error) {
 			return true, nil
 		}
 		if bytes.Equal(trimmed, raceHeaderFooter) {
-			s.state = done
+			s.state = gotRaceFooter
 			return true, nil
 		}
 		fallthrough
 
 	case gotRaceGoroutineHeader:
+		// The creation stack of a finished goroutine may not be restorable. Keep
+		// the goroutine with an empty CreatedBy.
+		if bytes.Equal(trimLeftSpace(trimmed), failedRestoreStack) {
+			s.state = gotRaceGoroutineFile
+			return true, nil
+		}
+		if len(trimmed) == 0 {
+			s.state = betweenRaceGoroutines
+			return true, nil
+		}
+		if bytes.Equal(trimmed, raceHeaderFooter) {
+			s.state = gotRaceFooter
+			return true, nil
+		}
 		c := Call{}
-		if found, err := parseFunc(&c, trimLeftSpace(trimmed)); found {
+		if found, err := parseFunc(&c, trimLeftSpace(trimmed), s.maxArgs); found {
 			s.Goroutines[s.goroutineIndex].CreatedBy.Calls = append(s.Goroutines[s.goroutineIndex].CreatedBy.Calls, c)
 			s.state = gotRaceGoroutineFunc
 			return err == nil, err
 		}
 		return false, fmt.Errorf("expected a function after a race operation or a race file, got: %q", trimmed)
 
+	case gotRaceGlobal:
+		if len(trimmed) == 0 {
+			s.state = betweenRaceGoroutines
+			return true, nil
+		}
+		if bytes.Equal(trimmed, raceHeaderFooter) {
+			s.state = gotRaceFooter
+			return true, nil
+		}
+		return false, fmt.Errorf("expected an empty line after a race global variable, got: %q", trimmed)
+
+	case gotRaceFooter:
+		s.state = done
+		if match := reRaceCount.FindSubmatch(trimmed); match != nil {
+			if n, ok := atou(match[1]); ok {
+				s.Race.Count = n
+				return true, nil
+			}
+		}
+		return false, nil
+
 	default:
 		return false, errors.New("internal error")
 	}
 }
 
+// scanPreamble looks for the information printed before the goroutines, like
+// the signal that triggered the snapshot.
+//
+// The lines are still considered junk, so they are written to prefix. They are
+// streamed out before the goroutine header confirming a snapshot is read, and
+// it keeps the output of a caller echoing prefix, like the CLI, verbatim.
+func (s *scanningState) scanPreamble(line []byte) {
+	if match := rePanic.FindSubmatch(line); match != nil {
+		// The runtime prints the nested panics indented with a tab, after the
+		// first one. An unindented line starts a new chain.
+		if len(match[1]) == 0 {
+			s.panics = nil
+		} else if s.panics == nil {
+			return
+		}
+		s.panics = append(s.panics, &Panic{Message: string(match[2]), Recovered: len(match[3]) != 0})
+		return
+	}
+	if match := reFatalError.FindSubmatch(line); match != nil {
+		s.fatal = &FatalError{Message: string(match[1]), M: -1, RuntimeMessages: s.runtimeMessages}
+		s.runtimeMessages = nil
+		return
+	}
+	if s.fatal != nil && s.fatal.M == -1 {
+		if match := reSignalPC.FindSubmatch(line); match != nil {
+			if m, ok := atou(match[2]); ok {
+				s.fatal.M = m
+			}
+		}
+	}
+	if bytes.HasPrefix(line, runtimePrefix) {
+		if s.fatal != nil {
+			s.fatal.RuntimeMessages = append(s.fatal.RuntimeMessages, string(line[len(runtimePrefix):]))
+		} else {
+			s.runtimeMessages = append(s.runtimeMessages, string(line[len(runtimePrefix):]))
+		}
+		return
+	}
+	// The messages must directly precede the fatal error.
+	s.runtimeMessages = nil
+	if sig := parseSignalPanic(line); sig != nil {
+		s.signal = sig
+		return
+	}
+	if match := reSignal.FindSubmatch(line); match != nil {
+		s.signal = &Signal{Name: string(match[1]), Description: string(match[2])}
+		return
+	}
+	if s.signal == nil || len(line) == 0 {
+		return
+	}
+	if match := reSignalPC.FindSubmatch(line); match != nil {
+		s.signal.PC, _ = strconv.ParseUint(string(match[1]), 0, 64)
+		s.signal.M, _ = atou(match[2])
+		// The runtime prints it as unsigned, while it is signed, e.g. SI_TKILL
+		// is -6.
+		if c, err := strconv.ParseUint(string(match[3]), 10, 64); err == nil {
+			s.signal.Code = int(int64(c))
+		}
+		return
+	}
+	// Anything else means the signal was unrelated to the goroutines that may
+	// follow.
+	s.signal = nil
+}
+
+// endRoutine ends the snapshot on a line that directly follows a goroutine
+// and that is not part of it.
+//
+// Returns an error with Opts.Strict, since the line is not separated from the
+// goroutine by an empty line.
+func (s *scanningState) endRoutine(line []byte) error {
+	s.state = done
+	if s.strict {
+		return fmt.Errorf("unexpected line after goroutine %d: %q", s.Goroutines[len(s.Goroutines)-1].ID, line)
+	}
+	return nil
+}
+
+// scanExitInfo parses how the process terminated, as printed after the
+// goroutines, and ends the snapshot if line is one.
+func (s *scanningState) scanExitInfo(line []byte) bool {
+	if match := reExitStatus.FindSubmatch(line); match != nil {
+		n, ok := atou(match[1])
+		if !ok {
+			return false
+		}
+		s.ExitInfo = &ExitInfo{Status: n}
+	} else if match := reExitSignal.FindSubmatch(line); match != nil {
+		s.ExitInfo = &ExitInfo{Signal: string(match[1]), CoreDumped: len(match[2]) != 0}
+	} else {
+		return false
+	}
+	s.state = done
+	return true
+}
+
+// parseSignalPanic parses a signal as printed by the runtime when it caused a
+// panic, like "[signal SIGSEGV: segmentation violation code=0x1 addr=0x0
+// pc=0x48d2b6]".
+//
+// Returns nil if line is not one.
+func parseSignalPanic(line []byte) *Signal {
+	match := reSignalPanic.FindSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	sig := &Signal{Name: string(match[1]), Description: string(match[2])}
+	if len(match[3]) != 0 {
+		sig.Name = string(match[3])
+	}
+	if c, err := strconv.ParseUint(string(match[4]), 0, 64); err == nil {
+		sig.Code = int(int64(c))
+	}
+	sig.Addr, _ = strconv.ParseUint(string(match[5]), 0, 64)
+	sig.PC, _ = strconv.ParseUint(string(match[6]), 0, 64)
+	return sig
+}
+
+// scanRoutineHeader parses a goroutine header and starts a new goroutine if
+// line is one.
+//
+// line must already have the current prefix trimmed off.
+func (s *scanningState) scanRoutineHeader(line []byte) bool {
+	match := reRoutineHeader.FindSubmatch(line)
+	if match == nil {
+		return false
+	}
+	id, ok := atou(match[2])
+	if !ok {
+		return false
+	}
+	// See runtime/traceback.go.
+	// "<state>, \d+ minutes, locked to thread"
+	items := bytes.Split(match[6], commaSpace)
+	sleep := 0
+	locked := false
+	for i := 1; i < len(items); i++ {
+		if bytes.Equal(items[i], lockedToThread) {
+			locked = true
+			continue
+		}
+		// Look for duration, if any.
+		if match2 := reMinutes.FindSubmatch(items[i]); match2 != nil {
+			sleep, _ = atou(match2[1])
+		}
+	}
+	var detail []byte
+	if match2 := reStateDetail.FindSubmatch(items[0]); match2 != nil {
+		detail = match2[1]
+	}
+	g := &Goroutine{
+		Signature: Signature{
+			State:       string(items[0]),
+			StateDetail: string(detail),
+			SleepMin:    sleep,
+			SleepMax:    sleep,
+			Locked:      locked,
+		},
+		ID:    id,
+		First: len(s.Goroutines) == 0,
+	}
+	if s.keepRaw {
+		g.RawState = string(match[6])
+	}
+	if len(match[3]) != 0 {
+		g.GP, _ = strconv.ParseUint(string(match[3]), 0, 64)
+	}
+	if len(match[5]) != 0 {
+		g.MID, _ = atou(match[4])
+		g.MP, _ = strconv.ParseUint(string(match[5]), 0, 64)
+	}
+	s.dropFiltered()
+	s.filtered = s.stateFilter != nil && !s.stateFilter(g.State)
+	// Increase performance by always allocating 4 goroutines minimally.
+	if s.Goroutines == nil {
+		s.Goroutines = make([]*Goroutine, 0, 4)
+	}
+	s.Goroutines = append(s.Goroutines, g)
+	s.goroutineIndex = len(s.Goroutines) - 1
+	if s.signal != nil {
+		s.Signal = s.signal
+		s.signal = nil
+	}
+	if s.fatal != nil {
+		s.fatal.GoroutineID = id
+		s.FatalError = s.fatal
+		s.fatal = nil
+	}
+	if s.panics != nil {
+		for _, p := range s.panics {
+			p.GoroutineID = id
+		}
+		s.Panics = s.panics
+		s.PanicMessage = s.panics[len(s.panics)-1].Message
+		s.panics = nil
+	}
+	s.state = gotRoutineHeader
+	// The indentation is relative to the prefix already trimmed off.
+	s.prefix = append(append([]byte{}, s.prefix...), match[1]...)
+	return true
+}
+
+// dropFiltered removes the last goroutine if it was rejected by
+// Opts.StateFilter.
+func (s *scanningState) dropFiltered() {
+	if s.filtered {
+		s.Goroutines[len(s.Goroutines)-1] = nil
+		s.Goroutines = s.Goroutines[:len(s.Goroutines)-1]
+		s.filtered = false
+	}
+}
+
+// current returns the goroutine the last scanned line belongs to, if any.
+func (s *scanningState) current() *Goroutine {
+	switch s.state {
+	case looking, done, gotRaceHeader1, gotRaceHeader2, gotRaceGlobal:
+		return nil
+	}
+	if s.goroutineIndex < len(s.Goroutines) {
+		return s.Goroutines[s.goroutineIndex]
+	}
+	return nil
+}
+
+// parseFrameFunc is parseFunc for the function lines of a goroutine, with
+// support for Opts.IndexedFrames and Opts.TraceFrames.
+func (s *scanningState) parseFrameFunc(c *Call, line []byte) (bool, error) {
+	if s.traceFrames {
+		if match := reTraceFrame.FindSubmatch(line); match != nil {
+			line = append(append(make([]byte, 0, len(match[1])+2), match[1]...), "()"...)
+		}
+	}
+	if s.indexedFrames {
+		if match := reFrameIndex.FindSubmatch(line); match != nil {
+			line = match[1]
+			if line[len(line)-1] != ')' {
+				line = append(append(make([]byte, 0, len(line)+2), line...), "()"...)
+			}
+		}
+	}
+	return parseFunc(c, line, s.maxArgs)
+}
+
+// parseFrameFile is parseFile for the file lines of a goroutine, with support
+// for Opts.TraceFrames.
+func (s *scanningState) parseFrameFile(c *Call, line []byte) (bool, error) {
+	if s.traceFrames && bytes.HasPrefix(line, []byte("\t\t")) {
+		// "go tool trace" indents the file lines with 2 tabs.
+		line = line[1:]
+	}
+	return parseFile(c, line)
+}
+
+// parseCreated initializes the creator of g from a reCreated match.
+func parseCreated(g *Goroutine, match [][]byte) error {
+	g.CreatedBy.Calls = make([]Call, 1)
+	if err := g.CreatedBy.Calls[0].Func.Init(string(match[1])); err != nil {
+		g.CreatedBy.Calls = nil
+		return err
+	}
+	// This initializes ImportPath.
+	g.CreatedBy.Calls[0].init("", 0)
+	if len(match[2]) != 0 {
+		g.CreatedByGoroutine, _ = atou(match[2])
+	}
+	if info := bytes.TrimLeft(match[3], ", "); len(info) != 0 {
+		g.CreatedByInfo = string(info)
+	}
+	return nil
+}
+
 // parseFunc only return an error if also returning a Call.
 //
+// At most maxArgs values are kept, see Opts.MaxArgs.
+//
 // Uses reFunc.
-func parseFunc(c *Call, line []byte) (bool, error) {
+func parseFunc(c *Call, line []byte, maxArgs int) (bool, error) {
 	if match := reFunc.FindSubmatch(line); match != nil {
 		if err := c.Func.Init(string(match[1])); err != nil {
 			return true, err
@@ -811,7 +2128,11 @@ func parseFunc(c *Call, line []byte) (bool, error) {
 		// It is also done in c.init() but do it here in case of a corrupted trace
 		// for the file section.
 		c.ImportPath = c.Func.ImportPath
-		for _, a := range bytes.Split(match[2], commaSpace) {
+		// Do not use bytes.Split() to not allocate for each value upfront.
+		args := match[2]
+		for more := true; more; {
+			var a []byte
+			a, args, more = splitArg(args)
 			if bytes.Equal(a, threeDots) {
 				c.Args.Elided = true
 				continue
@@ -820,23 +2141,70 @@ func parseFunc(c *Call, line []byte) (bool, error) {
 				// Remaining values were dropped.
 				break
 			}
-			v, err := strconv.ParseUint(string(a), 0, 64)
-			if err != nil {
-				return true, fmt.Errorf("failed to parse int on line: %q", bytes.TrimSpace(line))
+			if len(c.Args.Values) == maxArgs {
+				c.Args.Truncated = true
+				c.Args.Elided = true
+				break
+			}
+			if c.Args.Elided {
+				// The "..." was in the middle of the list.
+				c.Args.AfterElided++
+			}
+			var arg Arg
+			if a[0] == '"' || a[0] == '{' {
+				// Not printed by the runtime but by some custom dumpers.
+				arg.Raw = string(a)
+			} else {
+				v, err := strconv.ParseUint(string(a), 0, 64)
+				if err != nil {
+					return true, fmt.Errorf("failed to parse int on line: %q", bytes.TrimSpace(line))
+				}
+				// Assume the stack was generated with the same bitness (32 vs 64) than
+				// the code processing it.
+				arg = Arg{Value: v, IsPtr: v > pointerFloor && v < pointerCeiling}
 			}
 			// Increase performance by always allocating 4 values minimally.
 			if c.Args.Values == nil {
 				c.Args.Values = make([]Arg, 0, 4)
 			}
-			// Assume the stack was generated with the same bitness (32 vs 64) than
-			// the code processing it.
-			c.Args.Values = append(c.Args.Values, Arg{Value: v, IsPtr: v > pointerFloor && v < pointerCeiling})
+			c.Args.Values = append(c.Args.Values, arg)
 		}
 		return true, nil
 	}
 	return false, nil
 }
 
+// splitArg returns the first argument of args, the remaining ones and
+// whether there are remaining ones.
+//
+// The arguments are separated by ", ", except inside a quoted string or a
+// struct literal in braces.
+func splitArg(args []byte) ([]byte, []byte, bool) {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(args); i++ {
+		switch c := args[i]; {
+		case quoted:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				quoted = false
+			}
+		case c == '"':
+			quoted = true
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth != 0 {
+				depth--
+			}
+		case c == ',' && depth == 0 && bytes.HasPrefix(args[i:], commaSpace):
+			return args[:i], args[i+len(commaSpace):], true
+		}
+	}
+	return args, nil, false
+}
+
 // parseFile only return an error if also processing a Call.
 //
 // Uses reFile.
@@ -847,16 +2215,37 @@ func parseFile(c *Call, line []byte) (bool, error) {
 			return true, fmt.Errorf("failed to parse int on line: %q", bytes.TrimSpace(line))
 		}
 		c.init(string(match[1]), num)
+		if len(match[3]) != 0 {
+			v, err := strconv.ParseUint(string(match[3]), 0, 64)
+			if err != nil {
+				return true, fmt.Errorf("failed to parse int on line: %q", bytes.TrimSpace(line))
+			}
+			c.PCOffset = v
+		}
 		return true, nil
 	}
 	return false, nil
 }
 
+// hasPathPrefix returns true if prefix is the prefix of p.
+//
+// On Windows, the comparison is case insensitive since both "C:/foo" and
+// "c:/foo" can be found in the same stack trace.
+func hasPathPrefix(p, prefix string) bool {
+	if len(p) < len(prefix) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(p[:len(prefix)], prefix)
+	}
+	return p[:len(prefix)] == prefix
+}
+
 // hasPrefix returns true if any of s is the prefix of p.
 func hasPrefix(p string, s map[string]string) bool {
 	lp := len(p)
 	for prefix := range s {
-		if l := len(prefix); lp > l+1 && p[:l] == prefix && p[l] == '/' {
+		if l := len(prefix); lp > l+1 && hasPathPrefix(p, prefix) && p[l] == '/' {
 			return true
 		}
 	}
@@ -871,10 +2260,10 @@ func hasSrcPrefix(p string, s map[string]string) bool {
 	const pkgmod = "/pkg/mod/"
 	for prefix := range s {
 		l := len(prefix)
-		if lp > l+len(src) && p[:l] == prefix && p[l:l+len(src)] == src {
+		if lp > l+len(src) && hasPathPrefix(p, prefix) && p[l:l+len(src)] == src {
 			return true
 		}
-		if lp > l+len(pkgmod) && p[:l] == prefix && p[l:l+len(pkgmod)] == pkgmod {
+		if lp > l+len(pkgmod) && hasPathPrefix(p, prefix) && p[l:l+len(pkgmod)] == pkgmod {
 			return true
 		}
 	}
@@ -886,7 +2275,9 @@ func getFiles(goroutines []*Goroutine) []string {
 	files := map[string]struct{}{}
 	for _, g := range goroutines {
 		for _, c := range g.Stack.Calls {
-			files[c.RemoteSrcPath] = struct{}{}
+			if !c.IsUnknownLocation() {
+				files[c.RemoteSrcPath] = struct{}{}
+			}
 		}
 	}
 	if len(files) == 0 {
@@ -986,12 +2377,10 @@ func (s *Snapshot) findRoots() int {
 	missing := 0
 	gmc := gomodCache{}
 	for _, f := range getFiles(s.Goroutines) {
-		// TODO(maruel): Could a stack dump have mixed cases? I think it's
-		// possible, need to confirm and handle.
 		//log.Printf("  Analyzing %s", f)
 
 		// First checks skip file I/O.
-		if s.RemoteGOROOT != "" && strings.HasPrefix(f, s.RemoteGOROOT+"/src/") {
+		if s.RemoteGOROOT != "" && hasPathPrefix(f, s.RemoteGOROOT+"/src/") {
 			// stdlib.
 			continue
 		}
@@ -1074,25 +2463,35 @@ func getGOPATHs() []string {
 		}
 	}
 	if len(out) == 0 {
-		homeDir := ""
-		u, err := user.Current()
+		homeDir, err := getHomeDir()
 		if err != nil {
-			homeDir = os.Getenv("HOME")
-			if homeDir == "" {
-				panic(fmt.Sprintf("Could not get current user or $HOME: %s\n", err.Error()))
-			}
-		} else {
-			homeDir = u.HomeDir
-		}
-		p := homeDir + "/go"
-		if runtime.GOOS == "windows" {
-			p = strings.Replace(p, pathSeparator, "/", -1)
+			panic(fmt.Sprintf("Could not get current user or $HOME: %s\n", err.Error()))
 		}
-		out = []string{p}
+		out = []string{homeDir + "/go"}
 	}
 	return out
 }
 
+// getHomeDir returns the home directory of the current user.
+//
+// Uses "/" as path separator.
+func getHomeDir() (string, error) {
+	homeDir := ""
+	u, err := user.Current()
+	if err != nil {
+		homeDir = os.Getenv("HOME")
+		if homeDir == "" {
+			return "", err
+		}
+	} else {
+		homeDir = u.HomeDir
+	}
+	if runtime.GOOS == "windows" {
+		homeDir = strings.Replace(homeDir, pathSeparator, "/", -1)
+	}
+	return homeDir, nil
+}
+
 // atou is a fast Atoi() function.
 //
 // It is a very simplified version of strconv.Atoi() that it never go into the
@@ -1115,6 +2514,36 @@ func atou(s []byte) (int, bool) {
 	return 0, false
 }
 
+// trimEOL removes the trailing "\n" or "\r\n", if any.
+func trimEOL(s []byte) []byte {
+	if bytes.HasSuffix(s, crlf) {
+		return s[:len(s)-2]
+	}
+	if bytes.HasSuffix(s, lf) {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// toValidUTF8 returns a copy of s with each invalid UTF-8 byte replaced with
+// U+FFFD.
+//
+// It is bytes.ToValidUTF8() except that each byte is replaced, and it works on
+// go1.11.
+func toValidUTF8(s []byte) []byte {
+	out := make([]byte, 0, len(s)+8)
+	for len(s) != 0 {
+		r, size := utf8.DecodeRune(s)
+		if r == utf8.RuneError && size == 1 {
+			out = append(out, "\uFFFD"...)
+		} else {
+			out = append(out, s[:size]...)
+		}
+		s = s[size:]
+	}
+	return out
+}
+
 // trimLeftSpace is the faster equivalent of bytes.TrimLeft(s, "\t ").
 func trimLeftSpace(s []byte) []byte {
 	for i, ch := range s {
@@ -17,8 +17,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/maruel/panicparse/v2/internal/internaltest"
@@ -93,24 +95,61 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/cockroachdb/cockroach/storage/engine._Cfunc_DBIterSeek",
-									Args{}, "??", 0),
-								newCall(
+									Args{}, "??", 0,
+									0x6d),
+								newCallOffset(
 									"gopkg.in/yaml%2ev2.handleErr",
 									Args{Values: []Arg{{Value: 0x433b20, IsPtr: true}}},
 									"/gopath/src/gopkg.in/yaml.v2/yaml.go",
-									153),
-								newCall(
+									153,
+									0xc6),
+								newCallOffset(
 									"reflect.Value.assignTo",
 									Args{Values: []Arg{{Value: 0x570860, IsPtr: true}, {Value: 0xc20803f3e0, IsPtr: true}, {Value: 0x15}}},
 									"/goroot/src/reflect/value.go",
-									2125),
-								newCall(
+									2125,
+									0x368),
+								newCallOffset(
 									"main.main",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									428),
+									428,
+									0x27),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "InvalidUTF8",
+			in: []string{
+				"panic: caf\xe9",
+				"",
+				"goroutine 1 [running]:",
+				"main.caf\xe9(0x433b20)",
+				"\t/gopath/src/caf\xe9/\xff\xfe.go:153 +0xc6",
+				"",
+			},
+			prefix: "panic: caf\xe9\n\n",
+			err:    io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.caf\uFFFD",
+									Args{Values: []Arg{{Value: 0x433b20, IsPtr: true}}},
+									"/gopath/src/caf\uFFFD/\uFFFD\uFFFD.go",
+									153,
+									0xc6),
 							},
 						},
 					},
@@ -148,11 +187,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						SleepMax: 100,
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"gopkg.in/yaml%2ev2.handleErr",
 									Args{Values: []Arg{{Value: 0x433b20, IsPtr: true}}},
 									"/gopath/src/gopkg.in/yaml.v2/yaml.go",
-									153),
+									153,
+									0xc6),
 							},
 						},
 					},
@@ -165,11 +205,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						Locked: true,
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"gopkg.in/yaml%2ev2.handleErr",
 									Args{Values: []Arg{{Value: 0x8033b21, Name: "#1", IsPtr: true}}},
 									"/gopath/src/gopkg.in/yaml.v2/yaml.go",
-									153),
+									153,
+									0xc6),
 							},
 						},
 					},
@@ -182,11 +223,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						SleepMax: 101,
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"gopkg.in/yaml%2ev2.handleErr",
 									Args{Values: []Arg{{Value: 0x8033b22, Name: "#2", IsPtr: true}}},
 									"/gopath/src/gopkg.in/yaml.v2/yaml.go",
-									153),
+									153,
+									0xc6),
 							},
 						},
 						Locked: true,
@@ -381,6 +423,35 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			},
 		},
 
+		{
+			name: "IndentedFollowedByText",
+			in: []string{
+				"  goroutine 1 [running]:",
+				"  main.main()",
+				"  \t/gopath/src/github.com/maruel/panicparse/stack/stack.go:1",
+				"}",
+				"",
+			},
+			// Only ParseDebugStack ends the snapshot on a line with a different
+			// indentation.
+			suffix: "}\n",
+			err:    errors.New("inconsistent indentation: \"}\", expected \"  \""),
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCall("main.main", Args{}, "/gopath/src/github.com/maruel/panicparse/stack/stack.go", 1),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
 		{
 			name: "OrderErr",
 			in: []string{
@@ -427,16 +498,17 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "garbage collection",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"testing.RunTests",
 									Args{},
 									"/goroot/src/testing/testing.go",
-									555),
+									555,
+									0xa8b),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/maruel/panicparse/stack/stack.recurseType",
 									Args{
 										Values: []Arg{
@@ -448,7 +520,8 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 										},
 									},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									53),
+									53,
+									0x845),
 							},
 							Elided: true,
 						},
@@ -459,6 +532,204 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			},
 		},
 
+		{
+			name: "ElidedMiddle",
+			in: []string{
+				"panic: reflect.Set: value of type",
+				"",
+				"goroutine 1 [running]:",
+				"main.f(0x1, 0x2, ..., 0x9)",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:53 +0x845",
+				"",
+			},
+			prefix: "panic: reflect.Set: value of type\n\n",
+			err:    io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.f",
+									Args{
+										Values:      []Arg{{Value: 1}, {Value: 2}, {Value: 9}},
+										Elided:      true,
+										AfterElided: 1,
+									},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									53,
+									0x845),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "ElidedOnly",
+			in: []string{
+				"goroutine 1 [running]:",
+				"main.f(...)",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:53 +0x845",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.f",
+									Args{Elided: true},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									53,
+									0x845),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "ElidedTrailing",
+			in: []string{
+				"goroutine 1 [running]:",
+				"main.f(0x1, 0x2, 0x3, ...)",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:53 +0x845",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.f",
+									Args{
+										Values: []Arg{{Value: 1}, {Value: 2}, {Value: 3}},
+										Elided: true,
+									},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									53,
+									0x845),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "SpaceIndentedFrames",
+			in: []string{
+				"goroutine 1 [running]:",
+				"  main.f(0x1)",
+				"      /gopath/src/github.com/maruel/panicparse/stack/stack.go:53 +0x845",
+				"  main.main()",
+				"      /gopath/src/github.com/maruel/panicparse/stack/stack.go:60 +0x27",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.f",
+									Args{Values: []Arg{{Value: 1}}},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									53,
+									0x845),
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									60,
+									0x27),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			// The empty value after "..." must not be counted in AfterElided.
+			name: "ElidedTrailingEmpty",
+			in: []string{
+				"goroutine 1 [running]:",
+				"main.f(0x1, ..., )",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:53 +0x845",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.f",
+									Args{Values: []Arg{{Value: 1}}, Elided: true},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									53,
+									0x845),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "EmptyState",
+			in: []string{
+				"goroutine 5 []:",
+				"main.main()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:428 +0x27",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									428,
+									0x27),
+							},
+						},
+					},
+					ID:    5,
+					First: true,
+				},
+			},
+		},
+
 		{
 			name: "Syscall",
 			in: []string{
@@ -485,16 +756,17 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "syscall",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"os/signal.init·1",
 									Args{},
 									"/goroot/src/os/signal/signal_unix.go",
-									27),
+									27,
+									0x35),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"runtime.notetsleepg",
 									Args{
 										Values: []Arg{
@@ -504,22 +776,26 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 										},
 									},
 									"/goroot/src/runtime/lock_futex.go",
-									201),
-								newCall(
+									201,
+									0x52),
+								newCallOffset(
 									"runtime.signal_recv",
 									Args{Values: []Arg{{}}},
 									"/goroot/src/runtime/sigqueue.go",
-									109),
-								newCall(
+									109,
+									0x135),
+								newCallOffset(
 									"os/signal.loop",
 									Args{},
 									"/goroot/src/os/signal/signal_unix.go",
-									21),
-								newCall(
+									21,
+									0x1f),
+								newCallOffset(
 									"runtime.goexit",
 									Args{},
 									"/goroot/src/runtime/asm_amd64.s",
-									2232),
+									2232,
+									0x1),
 							},
 						},
 					},
@@ -548,11 +824,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/maruel/panicparse/stack.New",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									131),
+									131,
+									0x381),
 							},
 						},
 						Stack: Stack{
@@ -637,11 +914,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "runnable",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/maruel/panicparse/stack.New",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									113),
+									113,
+									0x43b),
 							},
 						},
 						Stack: Stack{
@@ -724,7 +1002,6 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 				"exit status 2",
 			},
 			prefix: "panic: reflect.Set: value of type\n\n",
-			suffix: "exit status 2",
 			err:    io.EOF,
 			want: []*Goroutine{
 				{
@@ -732,11 +1009,12 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/maruel/panicparse/stack.New",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									131),
+									131,
+									0x381),
 							},
 						},
 						Stack: Stack{
@@ -755,51 +1033,129 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			},
 		},
 
-		// For coverage of scanLines.
 		{
-			name: "CreatedError",
+			name: "CreatedInGoroutine",
 			in: []string{
 				"panic: reflect.Set: value of type",
 				"",
-				"goroutine 1 [running]:",
-				"github.com/maruel/panicparse/stack.func·002()",
+				"goroutine 6 [running]:",
+				"main.worker()",
 				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:110",
-				"created by github.com/maruel/panicparse/stack.New",
-				"junk",
+				"created by main.main in goroutine 1",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:131 +0x381",
+				"",
+				"goroutine 7 [running]:",
+				"main.worker()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:110",
+				"created by main.main in goroutine 1, started at 12:34:56",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:131 +0x381",
+				"",
 			},
 			prefix: "panic: reflect.Set: value of type\n\n",
-			suffix: "junk",
-			err:    errors.New("expected a file after a created line, got: \"junk\""),
+			err:    io.EOF,
 			want: []*Goroutine{
 				{
 					Signature: Signature{
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall("github.com/maruel/panicparse/stack.New", Args{}, "", 0),
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									131,
+									0x381),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
 								newCall(
-									"github.com/maruel/panicparse/stack.func·002",
+									"main.worker",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 									110),
 							},
 						},
 					},
-					ID:    1,
-					First: true,
+					ID:                 6,
+					First:              true,
+					CreatedByGoroutine: 1,
+				},
+				{
+					Signature: Signature{
+						State: "running",
+						CreatedBy: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									131,
+									0x381),
+							},
+						},
+						Stack: Stack{
+							Calls: []Call{
+								newCall(
+									"main.worker",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									110),
+							},
+						},
+					},
+					ID:                 7,
+					CreatedByGoroutine: 1,
+					CreatedByInfo:      "started at 12:34:56",
 				},
 			},
 		},
 
+		// For coverage of scanLines.
 		{
-			name: "CCode",
+			name: "CreatedError",
 			in: []string{
-				"SIGQUIT: quit",
-				"PC=0x43f349",
+				"panic: reflect.Set: value of type",
+				"",
+				"goroutine 1 [running]:",
+				"github.com/maruel/panicparse/stack.func·002()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:110",
+				"created by github.com/maruel/panicparse/stack.New",
+				"junk",
+			},
+			prefix: "panic: reflect.Set: value of type\n\n",
+			suffix: "junk",
+			err:    errors.New("expected a file after a created line, got: \"junk\""),
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						CreatedBy: Stack{
+							Calls: []Call{
+								newCall("github.com/maruel/panicparse/stack.New", Args{}, "", 0),
+							},
+						},
+						Stack: Stack{
+							Calls: []Call{
+								newCall(
+									"github.com/maruel/panicparse/stack.func·002",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									110),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		{
+			name: "CCode",
+			in: []string{
+				"SIGQUIT: quit",
+				"PC=0x43f349",
 				"",
 				"goroutine 0 [idle]:",
 				"runtime.epollwait(0x4, 0x7fff671c7118, 0xffffffff00000080, 0x0, 0xffffffff0028c1be, 0x0, 0x0, 0x0, 0x0, 0x0, ...)",
@@ -824,7 +1180,7 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "idle",
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"runtime.epollwait",
 									Args{
 										Values: []Arg{
@@ -842,28 +1198,33 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 										Elided: true,
 									},
 									"/goroot/src/runtime/sys_linux_amd64.s",
-									400),
-								newCall(
+									400,
+									0x19),
+								newCallOffset(
 									"runtime.netpoll",
 									Args{Values: []Arg{{Value: 0x901b01, IsPtr: true}, {}}},
 									"/goroot/src/runtime/netpoll_epoll.go",
-									68),
-								newCall(
+									68,
+									0xa3),
+								newCallOffset(
 									"findrunnable",
 									Args{Values: []Arg{{Value: 0xc208012000, IsPtr: true}}},
 									"/goroot/src/runtime/proc.c",
-									1472),
-								newCall("schedule", Args{}, "/goroot/src/runtime/proc.c", 1575),
-								newCall(
+									1472,
+									0x485),
+								newCallOffset("schedule", Args{}, "/goroot/src/runtime/proc.c", 1575, 0x151),
+								newCallOffset(
 									"runtime.park_m",
 									Args{Values: []Arg{{Value: 0xc2080017a0, IsPtr: true}}},
 									"/goroot/src/runtime/proc.c",
-									1654),
-								newCall(
+									1654,
+									0x113),
+								newCallOffset(
 									"runtime.mcall",
 									Args{Values: []Arg{{Value: 0x432684, IsPtr: true}}},
 									"/goroot/src/runtime/asm_amd64.s",
-									186),
+									186,
+									0x5a),
 							},
 						},
 					},
@@ -894,32 +1255,120 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"github.com/cockroachdb/cockroach/storage/engine._Cfunc_DBIterSeek",
 									Args{},
 									"??",
-									0),
-								newCall(
+									0,
+									0x6d),
+								newCallOffset(
 									"gopkg.in/yaml%2ev2.handleErr",
 									Args{Values: []Arg{{Value: 0x433b20, IsPtr: true}}},
 									"/gopath/src/gopkg.in/yaml.v2/yaml.go",
-									153),
-								newCall(
+									153,
+									0xc6),
+								newCallOffset(
 									"reflect.Value.assignTo",
 									Args{Values: []Arg{{Value: 0x570860, IsPtr: true}, {Value: 0xc20803f3e0, IsPtr: true}, {Value: 0x15}}},
 									"/goroot/src/reflect/value.go",
-									2125),
-								newCall(
+									2125,
+									0x368),
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									428,
+									0x27),
+							},
+						},
+					},
+					ID:    1,
+					First: true,
+				},
+			},
+		},
+
+		// Some tools strip the empty line between goroutines.
+		{
+			name: "NoBlankLine",
+			in: []string{
+				"panic: bleh",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:428 +0x27",
+				"goroutine 2 [chan send]:",
+				"main.func·001()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+				"created by main.main",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:74 +0xeb",
+				"goroutine 3 [chan receive]:",
+				"main.func·002()",
+				"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:80 +0x49",
+				"",
+			},
+			prefix: "panic: bleh\n\n",
+			err:    io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
 									"main.main",
 									Args{},
 									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
-									428),
+									428,
+									0x27),
 							},
 						},
 					},
 					ID:    1,
 					First: true,
 				},
+				{
+					Signature: Signature{
+						State: "chan send",
+						CreatedBy: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.main",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									74,
+									0xeb),
+							},
+						},
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.func·001",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									72,
+									0x49),
+							},
+						},
+					},
+					ID: 2,
+				},
+				{
+					Signature: Signature{
+						State: "chan receive",
+						Stack: Stack{
+							Calls: []Call{
+								newCallOffset(
+									"main.func·002",
+									Args{},
+									"/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+									80,
+									0x49),
+							},
+						},
+					},
+					ID: 3,
+				},
 			},
 		},
 
@@ -963,30 +1412,34 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"testing.(*T).Run",
 									Args{},
 									"/home/maruel/golang/go/src/testing/testing.go",
-									916),
+									916,
+									0x35a),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"foo/bar.TestArchiveFail.func1.2",
 									Args{},
 									"/home/maruel/go/foo/bar_test.go",
-									209),
-								newCall(
+									209,
+									0x469),
+								newCallOffset(
 									"foo/bar.TestArchiveFail",
 									Args{Values: []Arg{{Value: 0x3382000, Name: "#1", IsPtr: true}}},
 									"/home/maruel/go/src/foo/bar_test.go",
-									155),
-								newCall(
+									155,
+									0xf1),
+								newCallOffset(
 									"testing.tRunner",
 									Args{Values: []Arg{{Value: 0x3382000, Name: "#1", IsPtr: true}, {Value: 0x1615bf8, IsPtr: true}}},
 									"/home/maruel/golang/go/src/testing/testing.go",
-									865),
+									865,
+									0xc0),
 							},
 						},
 					},
@@ -1000,39 +1453,44 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			name:   "Race",
 			in:     []string{string(internaltest.StaticPanicRaceOutput())},
 			prefix: "\nGOTRACEBACK=all\n",
+			err:    io.EOF,
 			want: []*Goroutine{
 				{
 					Signature: Signature{
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"main.panicRace",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
 									153,
+									0xa1,
 								),
-								newCall(
+								newCallOffset(
 									"main.main",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
 									54,
+									0x6c8,
 								),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"main.panicDoRaceRead",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
 									137,
+									0x3a,
 								),
-								newCall(
+								newCallOffset(
 									"main.panicRace.func2",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
-									154),
+									154,
+									0x38),
 							},
 						},
 					},
@@ -1045,32 +1503,36 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 						State: "running",
 						CreatedBy: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"main.panicRace",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
 									150,
+									0x7f,
 								),
-								newCall(
+								newCallOffset(
 									"main.main",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
 									54,
+									0x6c8,
 								),
 							},
 						},
 						Stack: Stack{
 							Calls: []Call{
-								newCall(
+								newCallOffset(
 									"main.panicDoRaceWrite",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
-									132),
-								newCall(
+									132,
+									0x41),
+								newCallOffset(
 									"main.panicRace.func1",
 									Args{},
 									"/go/src/github.com/maruel/panicparse/cmd/panic/main.go",
-									151),
+									151,
+									0x38),
 							},
 						},
 					},
@@ -1081,6 +1543,83 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			},
 		},
 
+		{
+			name: "RaceMultiplePrevious",
+			in: []string{
+				"==================",
+				"WARNING: DATA RACE",
+				"Read at 0x00c000014100 by goroutine 8:",
+				"  main.read()",
+				"      /go/src/foo/main.go:137 +0x3a",
+				"",
+				"Previous write at 0x00c000014100 by goroutine 7:",
+				"  main.write()",
+				"      /go/src/foo/main.go:132 +0x41",
+				"",
+				"Previous read at 0x00c000014100 by goroutine 9:",
+				"  main.read()",
+				"      /go/src/foo/main.go:137 +0x3a",
+				"",
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"",
+				"Goroutine 7 (finished) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:53 +0x6c8",
+				"",
+				"Goroutine 9 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:55 +0x6c8",
+				"==================",
+				"",
+			},
+			err: io.EOF,
+			want: []*Goroutine{
+				{
+					Signature: Signature{
+						State: "running",
+						CreatedBy: Stack{
+							Calls: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 54, 0x6c8)},
+						},
+						Stack: Stack{
+							Calls: []Call{newCallOffset("main.read", Args{}, "/go/src/foo/main.go", 137, 0x3a)},
+						},
+					},
+					ID:       8,
+					First:    true,
+					RaceAddr: 0xc000014100,
+				},
+				{
+					Signature: Signature{
+						State: "finished",
+						CreatedBy: Stack{
+							Calls: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 53, 0x6c8)},
+						},
+						Stack: Stack{
+							Calls: []Call{newCallOffset("main.write", Args{}, "/go/src/foo/main.go", 132, 0x41)},
+						},
+					},
+					ID:        7,
+					RaceWrite: true,
+					RaceAddr:  0xc000014100,
+				},
+				{
+					Signature: Signature{
+						State: "running",
+						CreatedBy: Stack{
+							Calls: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 55, 0x6c8)},
+						},
+						Stack: Stack{
+							Calls: []Call{newCallOffset("main.read", Args{}, "/go/src/foo/main.go", 137, 0x3a)},
+						},
+					},
+					ID:       9,
+					RaceAddr: 0xc000014100,
+				},
+			},
+		},
+
 		{
 			name: "RaceHdr1Err",
 			in: []string{
@@ -1090,14 +1629,24 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 			err:    io.EOF,
 		},
 
+		{
+			name: "RaceHdrNotRace",
+			in: []string{
+				string(raceHeaderFooter),
+				"junk",
+				"",
+			},
+			prefix: string(raceHeaderFooter) + "\njunk\n",
+			err:    io.EOF,
+		},
+
 		{
 			name: "RaceHdr2Err",
 			in: []string{
 				string(raceHeaderFooter),
 				"",
 			},
-			// TODO(maruel): This is incorrect.
-			prefix: "",
+			prefix: string(raceHeaderFooter) + "\n",
 			err:    io.EOF,
 		},
 
@@ -1107,8 +1656,7 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 				string(raceHeaderFooter),
 				string(raceHeader),
 			},
-			// TODO(maruel): This is incorrect.
-			prefix: "",
+			prefix: string(raceHeaderFooter) + "\n" + string(raceHeader),
 			err:    io.EOF,
 		},
 
@@ -1119,8 +1667,7 @@ func TestScanSnapshotSynthetic(t *testing.T) {
 				string(raceHeader),
 				"",
 			},
-			// TODO(maruel): This is incorrect.
-			prefix: "",
+			prefix: string(raceHeaderFooter) + "\n" + string(raceHeader) + "\n",
 			err:    io.EOF,
 		},
 	}
@@ -1187,6 +1734,7 @@ func TestScanSnapshotSyntheticTwoSnapshots(t *testing.T) {
 			First: true,
 		},
 	}
+	zapPCOffsets(s.Goroutines)
 	compareGoroutines(t, want, s.Goroutines)
 	compareString(t, "Ya\nGOTRACEBACK=all\npanic: simple\n\n", prefix.String())
 
@@ -1228,65 +1776,1884 @@ func TestScanSnapshotSyntheticTwoSnapshots(t *testing.T) {
 			First: true,
 		},
 	}
+	zapPCOffsets(s.Goroutines)
 	compareGoroutines(t, want, s.Goroutines)
 	compareString(t, "Ye\nGOTRACEBACK=all\npanic: 42\n\n", prefix.String())
 	compareString(t, "Yo\n", string(suffix))
 }
 
-func TestSplitPath(t *testing.T) {
+func TestParseFrame(t *testing.T) {
 	t.Parallel()
-	if p := splitPath(""); p != nil {
-		t.Fatalf("expected nil, got: %v", p)
+	data := []struct {
+		name     string
+		funcLine string
+		fileLine string
+		want     *Call
+		err      error
+	}{
+		{
+			name:     "Goroutine",
+			funcLine: "main.f(0x1, 0xc000012345)",
+			fileLine: "\t/gopath/src/foo/main.go:12 +0x1a",
+			want: func() *Call {
+				c := newCallOffset("main.f", Args{Values: []Arg{{Value: 1}, {Value: 0xc000012345, IsPtr: true}}}, "/gopath/src/foo/main.go", 12, 0x1a)
+				return &c
+			}(),
+		},
+		{
+			name:     "RaceEOL",
+			funcLine: "  main.read()\n",
+			fileLine: "      /gopath/src/foo/main.go:137 +0x3a\r\n",
+			want: func() *Call {
+				c := newCallOffset("main.read", Args{}, "/gopath/src/foo/main.go", 137, 0x3a)
+				return &c
+			}(),
+		},
+		{
+			name:     "Swapped",
+			funcLine: "\t/gopath/src/foo/main.go:12 +0x1a",
+			fileLine: "main.f()",
+			err:      errors.New("file line \"main.f()\" is not indented more than function line \"\\t/gopath/src/foo/main.go:12 +0x1a\""),
+		},
+		{
+			name:     "BadFunc",
+			funcLine: "main.f",
+			fileLine: "\t/gopath/src/foo/main.go:12 +0x1a",
+			err:      errors.New("failed to parse function line: \"main.f\""),
+		},
+		{
+			name:     "BadFile",
+			funcLine: "main.f()",
+			fileLine: "\tmain.go",
+			err:      errors.New("failed to parse file line: \"\\tmain.go\""),
+		},
+		{
+			name:     "BadArg",
+			funcLine: "main.f(foo)",
+			fileLine: "\t/gopath/src/foo/main.go:12 +0x1a",
+			err:      errors.New("failed to parse int on line: \"main.f(foo)\""),
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			c, err := ParseFrame(line.funcLine, line.fileLine)
+			compareErr(t, line.err, err)
+			if diff := cmp.Diff(line.want, c); diff != "" {
+				t.Fatalf("Call mismatch (-want +got):\n%s", diff)
+			}
+		})
 	}
 }
 
-func TestGetGOPATHs(t *testing.T) {
-	// This test cannot run in parallel.
-	old := os.Getenv("GOPATH")
-	defer os.Setenv("GOPATH", old)
-	os.Setenv("GOPATH", "")
-	if p := getGOPATHs(); len(p) != 1 {
-		// It's the home directory + /go.
-		t.Fatalf("expected only one path: %v", p)
+func TestScanSnapshotSkipped(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x27",
+		"",
+		"...10000 goroutines, skipping...",
+		"",
+		"goroutine 10002 [chan receive]:",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:20 +0x27",
+		"",
+		"junk",
+		"",
 	}
-
-	root, err := ioutil.TempDir("", "stack")
-	if err != nil {
-		t.Fatal(err)
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, nil, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
 	}
-	defer func() {
-		if err = os.RemoveAll(root); err != nil {
-			t.Error(err)
-		}
-	}()
-	os.Setenv("GOPATH", filepath.Join(root, "a")+string(filepath.ListSeparator)+filepath.Join(root, "b")+string(filepath.Separator))
-	if p := getGOPATHs(); len(p) != 2 {
-		t.Fatalf("expected two paths: %v", p)
+	compareString(t, "junk\n", string(suffix))
+	if len(s.Goroutines) != 2 {
+		t.Fatalf("want 2 goroutines, got %d", len(s.Goroutines))
+	}
+	if s.SkippedGoroutines != 10000 {
+		t.Fatalf("want 10000 skipped goroutines, got %d", s.SkippedGoroutines)
 	}
 }
 
-// TestGomoduleComplex is an integration test that creates a non-trivial tree
-// of go modules using the "replace" statement.
-func TestGomoduleComplex(t *testing.T) {
-	// This test cannot run in parallel.
-	if internaltest.GetGoMinorVersion() < 11 {
-		t.Skip("requires go module support")
+func TestScanSnapshotArgTypes(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.serve(0xc000012345, 0x2)",
+		"\t/gopath/src/foo/main.go:10 +0x27",
+		"main.mismatch(0x1)",
+		"\t/gopath/src/foo/main.go:20 +0x27",
+		"main.elided(0x1, 0x2, ...)",
+		"\t/gopath/src/foo/main.go:30 +0x27",
+		"main.unknown(0x1)",
+		"\t/gopath/src/foo/main.go:40 +0x27",
+		"",
 	}
-	old := os.Getenv("GOPATH")
-	defer os.Setenv("GOPATH", old)
-	root, err := ioutil.TempDir("", "stack")
-	if err != nil {
-		t.Fatal(err)
+	types := map[string][]string{
+		"main.serve":    {"conn *net.TCPConn", "n int"},
+		"main.mismatch": {"a int", "b int"},
+		"main.elided":   {"a int", "b int"},
 	}
-	defer func() {
-		if err = os.RemoveAll(root); err != nil {
-			t.Error(err)
-		}
-	}()
+	opts := &Opts{ArgTypes: func(f string) []string { return types[f] }}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []string{
+		"conn *net.TCPConn = 0xc000012345, n int = 2",
+		"1",
+		"1, 2, ...",
+		"1",
+	}
+	var got []string
+	for _, c := range s.Goroutines[0].Stack.Calls {
+		got = append(got, c.Args.String())
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Args mismatch (-want +got):\n%s", diff)
+	}
+}
 
-	os.Setenv("GOPATH", filepath.Join(root, "go"))
-	tree := map[string]string{
-		"pkg1/go.mod": "module example.com/pkg1\n" +
+func TestParseDebugStack(t *testing.T) {
+	t.Parallel()
+	// As printed by debug.Stack() in an error message.
+	in := []string{
+		"error: boom",
+		"stack:",
+		"  goroutine 7 [running]:",
+		"  runtime/debug.Stack(0xc000012345, 0x1, 0x1)",
+		"  \t/goroot/src/runtime/debug/stack.go:24 +0x9d",
+		"  main.f()",
+		"  \t/gopath/src/foo/main.go:12 +0x1a",
+		"}",
+	}
+	g, err := ParseDebugStack(strings.Join(in, "\n"))
+	compareErr(t, nil, err)
+	want := &Goroutine{
+		Signature: Signature{
+			State: "running",
+			Stack: Stack{
+				Calls: []Call{
+					newCallOffset("runtime/debug.Stack", Args{Values: []Arg{{Value: 0xc000012345, IsPtr: true}, {Value: 1}, {Value: 1}}}, "/goroot/src/runtime/debug/stack.go", 24, 0x9d),
+					newCallOffset("main.f", Args{}, "/gopath/src/foo/main.go", 12, 0x1a),
+				},
+			},
+		},
+		ID:    7,
+		First: true,
+	}
+	if diff := cmp.Diff(want, g); diff != "" {
+		t.Fatalf("Goroutine mismatch (-want +got):\n%s", diff)
+	}
+
+	// Without a header.
+	headerless := []string{
+		"\tmain.f(0x1)",
+		"\t\t/gopath/src/foo/main.go:12 +0x1a",
+		"\tmain.main()",
+		"\t\t/gopath/src/foo/main.go:20 +0x27",
+		"",
+	}
+	g, err = ParseDebugStack(strings.Join(headerless, "\n"))
+	compareErr(t, nil, err)
+	want = &Goroutine{
+		Signature: Signature{
+			State: "running",
+			Stack: Stack{
+				Calls: []Call{
+					newCallOffset("main.f", Args{Values: []Arg{{Value: 1}}}, "/gopath/src/foo/main.go", 12, 0x1a),
+					newCallOffset("main.main", Args{}, "/gopath/src/foo/main.go", 20, 0x27),
+				},
+			},
+		},
+		First: true,
+	}
+	if diff := cmp.Diff(want, g); diff != "" {
+		t.Fatalf("Goroutine mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = ParseDebugStack("junk\n")
+	compareErr(t, errors.New("expected a function after a goroutine header, got: \"junk\""), err)
+}
+
+func TestParseDelveStack(t *testing.T) {
+	t.Parallel()
+	// As printed by "goroutine 6 stack" in Delve.
+	in := []string{
+		" 0  0x000000000043a0d6 in runtime.gopark",
+		"    at /goroot/src/runtime/proc.go:398",
+		" 1  0x0000000000406a5d in runtime.chanrecv",
+		"    at /goroot/src/runtime/chan.go:583",
+		"    defer 1: 0x0000000000490b5a in main.(*T).close",
+		"        deferred by main.worker at /gopath/src/foo/main.go:11",
+		" 2  0x0000000000490b3f in main.worker",
+		"    at /gopath/src/foo/main.go:12",
+		"(truncated)",
+		"",
+	}
+	g, err := ParseDelveStack(6, strings.Join(in, "\r\n"))
+	compareErr(t, nil, err)
+	want := &Goroutine{
+		Signature: Signature{
+			Stack: Stack{
+				Calls: []Call{
+					newCall("runtime.gopark", Args{}, "/goroot/src/runtime/proc.go", 398),
+					newCall("runtime.chanrecv", Args{}, "/goroot/src/runtime/chan.go", 583),
+					newCall("main.worker", Args{}, "/gopath/src/foo/main.go", 12),
+				},
+				Elided: true,
+			},
+		},
+		ID:    6,
+		First: true,
+	}
+	if diff := cmp.Diff(want, g); diff != "" {
+		t.Fatalf("Goroutine mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = ParseDelveStack(1, " 0  0x000000000043a0d6 in runtime.gopark\njunk\n")
+	compareErr(t, errors.New("expected a file after a function, got: \"junk\""), err)
+	_, err = ParseDelveStack(1, " 0  0x000000000043a0d6 in runtime.gopark")
+	compareErr(t, errors.New("expected a file after function \"runtime.gopark\""), err)
+	_, err = ParseDelveStack(1, "junk\n")
+	compareErr(t, errors.New("no stack found"), err)
+}
+
+func TestScanSnapshotIndexedFrames(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"0: main.f(0x1, 0x2)",
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"1: main.(*T).g",
+		"\t/gopath/src/foo/main.go:20",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{IndexedFrames: true})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []Call{
+		newCallOffset("main.f", Args{Values: []Arg{{Value: 1}, {Value: 2}}}, "/gopath/src/foo/main.go", 12, 0x1a),
+		newCall("main.(*T).g", Args{}, "/gopath/src/foo/main.go", 20),
+	}
+	if diff := cmp.Diff(want, s.Goroutines[0].Stack.Calls); diff != "" {
+		t.Fatalf("Calls mismatch (-want +got):\n%s", diff)
+	}
+
+	// It is opt-in.
+	s, _, _ = ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	compareString(t, "0: main.f", s.Goroutines[0].Stack.Calls[0].Func.Complete)
+}
+
+func TestScanSnapshotLenient(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"  goroutine 1 [running]:",
+		"  main.f(0x1, 0x2)\t/gopath/src/foo/main.go:12 +0x1a",
+		"  main.g() | /gopath/src/foo/main.go:20 +0x2b",
+		"  main.h() at /gopath/src/foo/main.go:30",
+		"  main.main()",
+		"  \t/gopath/src/foo/main.go:40 +0x3c",
+		"  created by main.init.0 in goroutine 5 -> /gopath/src/foo/main.go:50 +0x4d",
+		"",
+		"  junk",
+	}
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{Lenient: true})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				Stack: Stack{
+					Calls: []Call{
+						newCall("main.f", Args{Values: []Arg{{Value: 1}, {Value: 2}}}, "/gopath/src/foo/main.go", 12),
+						newCall("main.g", Args{}, "/gopath/src/foo/main.go", 20),
+						newCall("main.h", Args{}, "/gopath/src/foo/main.go", 30),
+						newCall("main.main", Args{}, "/gopath/src/foo/main.go", 40),
+					},
+				},
+				CreatedBy: Stack{
+					Calls: []Call{newCall("main.init.0", Args{}, "/gopath/src/foo/main.go", 50)},
+				},
+			},
+			ID:                 1,
+			First:              true,
+			CreatedByGoroutine: 5,
+		},
+	}
+	similarGoroutines(t, want, s.Goroutines)
+	compareString(t, "  junk", string(suffix))
+
+	// It is opt-in.
+	_, _, err = ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestScanSnapshotStrict(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name   string
+		in     []string
+		suffix string
+		err    error
+	}{
+		{
+			name:   "Junk",
+			in:     []string{"junk", ""},
+			suffix: "junk\n",
+			err:    errors.New("unexpected line after goroutine 6: \"junk\""),
+		},
+		{
+			name:   "JunkAfterEmptyLine",
+			in:     []string{"", "junk", ""},
+			suffix: "junk\n",
+		},
+		{
+			name: "ExitStatus",
+			in:   []string{"exit status 2", ""},
+		},
+		{
+			name: "End",
+			in:   []string{""},
+			err:  io.EOF,
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append([]string{
+				"junk before is fine",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x20",
+				"",
+				"goroutine 6 [chan receive]:",
+				"main.worker()",
+				"\t/gopath/src/foo/main.go:20 +0x20",
+				"created by main.main",
+				"\t/gopath/src/foo/main.go:9 +0x20",
+			}, line.in...)
+			prefix := bytes.Buffer{}
+			s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), &prefix, &Opts{Strict: true})
+			compareErr(t, line.err, err)
+			compareString(t, "junk before is fine\n", prefix.String())
+			compareString(t, line.suffix, string(suffix))
+			if s == nil || len(s.Goroutines) != 2 {
+				t.Fatalf("expected 2 goroutines, got %v", s)
+			}
+		})
+	}
+
+	// Without Strict, the snapshot quietly ends.
+	in := "goroutine 1 [running]:\nmain.main()\n\t/gopath/src/foo/main.go:10 +0x20\njunk\n"
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, nil, err)
+	compareString(t, "junk\n", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+}
+
+func TestScanSnapshotKeepRaw(t *testing.T) {
+	t.Parallel()
+	in := "panic: oh no\n" +
+		"\n" +
+		"goroutine 1 [running]:\n" +
+		"main.main()\n" +
+		"\t/gopath/src/foo/main.go:10 +0x27\r\n" +
+		"\n" +
+		"goroutine 6 [chan receive, 5 minutes, locked to thread]:\n" +
+		"main.worker(0xc000012345)\n" +
+		"\t/gopath/src/foo/main.go:20 +0x27\n" +
+		"created by main.main\n" +
+		"\t/gopath/src/foo/main.go:12 +0x1a\n"
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{KeepRaw: true})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []string{
+		"goroutine 1 [running]:\n" +
+			"main.main()\n" +
+			"\t/gopath/src/foo/main.go:10 +0x27\r\n" +
+			"\n",
+		"goroutine 6 [chan receive, 5 minutes, locked to thread]:\n" +
+			"main.worker(0xc000012345)\n" +
+			"\t/gopath/src/foo/main.go:20 +0x27\n" +
+			"created by main.main\n" +
+			"\t/gopath/src/foo/main.go:12 +0x1a\n",
+	}
+	var got []string
+	for _, g := range s.Goroutines {
+		got = append(got, g.Raw)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Raw mismatch (-want +got):\n%s", diff)
+	}
+	wantState := []string{"running", "chan receive, 5 minutes, locked to thread"}
+	var gotState []string
+	for _, g := range s.Goroutines {
+		gotState = append(gotState, g.RawState)
+	}
+	if diff := cmp.Diff(wantState, gotState); diff != "" {
+		t.Fatalf("RawState mismatch (-want +got):\n%s", diff)
+	}
+
+	// It is complete when the goroutine is passed to OnGoroutine.
+	got = nil
+	opts := &Opts{
+		KeepRaw: true,
+		OnGoroutine: func(g *Goroutine) bool {
+			got = append(got, g.Raw)
+			return true
+		},
+	}
+	_, _, err = ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("OnGoroutine Raw mismatch (-want +got):\n%s", diff)
+	}
+
+	// It is opt-in.
+	s, _, err = ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	compareString(t, "", s.Goroutines[0].Raw)
+}
+
+func TestScanSnapshotAlpineCgo(t *testing.T) {
+	t.Parallel()
+	opts := defaultOpts()
+	opts.GuessPaths = true
+	opts.AnalyzeSources = true
+	s, suffix, err := ScanSnapshot(bytes.NewReader(internaltest.StaticAlpineCgoOutput()), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	compareString(t, "", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if len(s.Goroutines) != 2 {
+		t.Fatalf("want 2 goroutines, got %d", len(s.Goroutines))
+	}
+	var unknown []string
+	for _, g := range s.Goroutines {
+		for i := range g.Stack.Calls {
+			c := &g.Stack.Calls[i]
+			if c.IsUnknownLocation() {
+				unknown = append(unknown, c.Func.Complete)
+				if c.Line != 0 || c.Location != LocationUnknown {
+					t.Errorf("%s: unexpected line %d or location %s", c.Func.Complete, c.Line, c.Location)
+				}
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{"crash", "__libc_start_main"}, unknown); diff != "" {
+		t.Fatalf("unknown locations mismatch (-want +got):\n%s", diff)
+	}
+	if got := len(s.Aggregate(AnyPointer).Buckets); got != 2 {
+		t.Fatalf("want 2 buckets, got %d", got)
+	}
+}
+
+func TestScanSnapshotGVisor(t *testing.T) {
+	t.Parallel()
+	in := internaltest.StaticGVisorOutput()
+	s, suffix, err := ScanSnapshot(bytes.NewReader(in), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	compareString(t, "", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	compareString(t, "unexpected state", s.PanicMessage)
+	// The same snapshot indented with tabs, like the Go runtime does.
+	r := strings.NewReplacer("\n    ", "\n\t", "\n  ", "\n")
+	want, _, err := ScanSnapshot(bytes.NewBufferString(r.Replace(string(in))), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if want == nil || len(want.Goroutines) != 3 {
+		t.Fatalf("unexpected reference snapshot %v", want)
+	}
+	if diff := cmp.Diff(want.Goroutines, s.Goroutines); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+	if !s.Goroutines[2].Stack.Elided || len(s.Goroutines[2].CreatedBy.Calls) != 1 {
+		t.Fatalf("unexpected goroutine %v", s.Goroutines[2])
+	}
+}
+
+func TestScanSnapshotGoroutinePointers(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		header string
+		gp     uint64
+		mid    int
+		mp     uint64
+	}{
+		{"goroutine 1 [running]:", 0, 0, 0},
+		{"goroutine 1 gp=0xc000002380 m=0 mp=0x5b6b00 [running]:", 0xc000002380, 0, 0x5b6b00},
+		{"goroutine 1 gp=0xc000002380 m=3 mp=0xc000080008 [running]:", 0xc000002380, 3, 0xc000080008},
+		{"goroutine 1 gp=0xc000002380 m=nil [running]:", 0xc000002380, 0, 0},
+	}
+	for i, line := range data {
+		in := []string{
+			"panic: oh no",
+			"",
+			line.header,
+			"main.main()",
+			"\t/gopath/src/foo/main.go:12 +0x1a",
+			"",
+		}
+		s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+		compareErr(t, io.EOF, err)
+		if s == nil || len(s.Goroutines) != 1 {
+			t.Fatalf("#%d: unexpected snapshot %v", i, s)
+		}
+		g := s.Goroutines[0]
+		if g.ID != 1 || g.State != "running" || len(g.Stack.Calls) != 1 {
+			t.Errorf("#%d: unexpected goroutine %v", i, g)
+		}
+		if g.GP != line.gp || g.MID != line.mid || g.MP != line.mp {
+			t.Errorf("#%d: want gp=%#x m=%d mp=%#x, got gp=%#x m=%d mp=%#x", i, line.gp, line.mid, line.mp, g.GP, g.MID, g.MP)
+		}
+	}
+}
+
+func TestScanSnapshotNonNumericArgs(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		`main.f(0x1, "a, \"b\" {c}", {0x2, {0x3, ""}}, 0xc000012345, ...)`,
+		"\t/gopath/src/foo/main.go:12 +0x1a",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil || len(s.Goroutines) != 1 || len(s.Goroutines[0].Stack.Calls) != 1 {
+		t.Fatalf("unexpected snapshot %v", s)
+	}
+	want := Args{
+		Values: []Arg{
+			{Value: 1},
+			{Raw: `"a, \"b\" {c}"`},
+			{Raw: `{0x2, {0x3, ""}}`},
+			{Value: 0xc000012345, IsPtr: true},
+		},
+		Elided: true,
+	}
+	got := s.Goroutines[0].Stack.Calls[0].Args
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+	compareString(t, `1, "a, \"b\" {c}", {0x2, {0x3, ""}}, 0xc000012345, ...`, got.String())
+}
+
+func TestScanSnapshotTraceFrames(t *testing.T) {
+	t.Parallel()
+	in := internaltest.StaticTraceOutput()
+	opts := defaultOpts()
+	opts.TraceFrames = true
+	s, suffix, err := ScanSnapshot(bytes.NewReader(in), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	compareString(t, "", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	// The same snapshot laid out like the Go runtime does.
+	ref := regexp.MustCompile(`(?m)^\t([^ \t]+) @ 0x[0-9a-f]+$`).ReplaceAllString(string(in), "$1()")
+	ref = strings.Replace(ref, "\n\t\t", "\n\t", -1)
+	want, _, err := ScanSnapshot(bytes.NewBufferString(ref), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if want == nil || len(want.Goroutines) != 2 || len(want.Goroutines[1].Stack.Calls) != 3 {
+		t.Fatalf("unexpected reference snapshot %v", want)
+	}
+	if diff := cmp.Diff(want.Goroutines, s.Goroutines); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+
+	// Without the option, the function line isn't recognized.
+	_, _, err = ScanSnapshot(bytes.NewReader(in), ioutil.Discard, defaultOpts())
+	compareErr(t, errors.New("expected a function after a goroutine header, got: \"main.main @ 0x4a7b35\""), err)
+
+	// Neither is the file line indented with 2 tabs.
+	_, _, err = ScanSnapshot(bytes.NewBufferString("goroutine 1 [running]:\nmain.main()\n\t\t/gopath/src/foo/main.go:12\n"), ioutil.Discard, defaultOpts())
+	compareErr(t, errors.New("expected a file after a function, got: \"/gopath/src/foo/main.go:12\""), err)
+}
+
+func TestScanSnapshotElided(t *testing.T) {
+	t.Parallel()
+	data := []string{
+		"...additional frames elided...",
+		"... additional frames elided ...",
+		"  ...additional  frames elided...  ",
+		"\t...additional frames\telided...",
+		"…additional frames elided…",
+		"… additional frames elided …",
+		"...additional frames elided…",
+	}
+	for i, line := range data {
+		in := []string{
+			"goroutine 1 [running]:",
+			"main.main()",
+			"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:428 +0x27",
+			line,
+			"",
+		}
+		s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+		compareErr(t, io.EOF, err)
+		compareString(t, "", string(suffix))
+		if s == nil {
+			t.Fatalf("#%d: %q: expected snapshot", i, line)
+		}
+		if !s.Goroutines[0].Stack.Elided {
+			t.Errorf("#%d: %q: expected Elided", i, line)
+		}
+	}
+}
+
+func TestSnapshotInferGOROOT(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"runtime.gopark(0x1, 0x2)",
+		"\t/remote/goroot/src/runtime/proc.go:307 +0xe5",
+		"github.com/foo/bar.Baz()",
+		"\t/remote/gopath/src/github.com/foo/bar/bar.go:12 +0x27",
+		"created by sync.(*Once).Do",
+		"\t/remote/goroot/src/sync/once.go:66 +0xec",
+		"",
+	}
+	opts := &Opts{LocalGOROOT: "/local/goroot"}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	compareString(t, "", s.RemoteGOROOT)
+	compareString(t, "/remote/goroot", s.InferGOROOT())
+	compareString(t, "/remote/goroot", s.RemoteGOROOT)
+
+	g := s.Goroutines[0]
+	c := g.Stack.Calls[0]
+	compareString(t, "runtime/proc.go", c.RelSrcPath)
+	compareString(t, "/local/goroot/src/runtime/proc.go", c.LocalSrcPath)
+	compareString(t, "runtime", c.ImportPath)
+	if c.Location != Stdlib {
+		t.Errorf("want Stdlib, got %s", c.Location)
+	}
+	c = g.CreatedBy.Calls[0]
+	compareString(t, "sync", c.ImportPath)
+	if c.Location != Stdlib {
+		t.Errorf("want Stdlib, got %s", c.Location)
+	}
+	// Non-stdlib calls are untouched.
+	c = g.Stack.Calls[1]
+	compareString(t, "", c.RelSrcPath)
+	if c.Location != LocationUnknown {
+		t.Errorf("want LocationUnknown, got %s", c.Location)
+	}
+
+	// Without runtime source files, nothing can be inferred.
+	s = &Snapshot{Goroutines: []*Goroutine{{Signature: Signature{Stack: Stack{Calls: []Call{g.Stack.Calls[1]}}}}}}
+	compareString(t, "", s.InferGOROOT())
+	compareString(t, "", s.RemoteGOROOT)
+}
+
+func TestScanSnapshotSignal(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name   string
+		in     []string
+		prefix string
+		want   *Signal
+	}{
+		{
+			name: "SIGQUIT",
+			in: []string{
+				"SIGQUIT: quit",
+				"PC=0x46c4e1 m=0 sigcode=0",
+				"",
+			},
+			prefix: "SIGQUIT: quit\nPC=0x46c4e1 m=0 sigcode=0\n\n",
+			want:   &Signal{Name: "SIGQUIT", Description: "quit", PC: 0x46c4e1},
+		},
+		{
+			name: "SIGABRT",
+			in: []string{
+				"SIGABRT: abort",
+				"PC=0x7f0c3a5f5e97 m=3 sigcode=18446744073709551610",
+				"",
+			},
+			prefix: "SIGABRT: abort\nPC=0x7f0c3a5f5e97 m=3 sigcode=18446744073709551610\n\n",
+			// SI_TKILL.
+			want: &Signal{Name: "SIGABRT", Description: "abort", PC: 0x7f0c3a5f5e97, M: 3, Code: -6},
+		},
+		{
+			name:   "NoPC",
+			in:     []string{"SIGQUIT: quit", ""},
+			prefix: "SIGQUIT: quit\n\n",
+			want:   &Signal{Name: "SIGQUIT", Description: "quit"},
+		},
+		{
+			name:   "Unrelated",
+			in:     []string{"SIGQUIT: quit", "junk", ""},
+			prefix: "SIGQUIT: quit\njunk\n\n",
+		},
+		{
+			name: "Panic",
+			in: []string{
+				"panic: runtime error: invalid memory address or nil pointer dereference",
+				"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48d2b6]",
+				"",
+			},
+			prefix: "panic: runtime error: invalid memory address or nil pointer dereference\n[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48d2b6]\n\n",
+			want:   &Signal{Name: "SIGSEGV", Description: "segmentation violation", PC: 0x48d2b6, Code: 1},
+		},
+		{
+			name: "PanicAccErr",
+			in: []string{
+				"unexpected fault address 0xc000100000",
+				"fatal error: fault",
+				"[signal SIGSEGV: segmentation violation code=0x2 addr=0xc000100000 pc=0x48d2b6]",
+				"",
+			},
+			prefix: "unexpected fault address 0xc000100000\nfatal error: fault\n[signal SIGSEGV: segmentation violation code=0x2 addr=0xc000100000 pc=0x48d2b6]\n\n",
+			want:   &Signal{Name: "SIGSEGV", Description: "segmentation violation", PC: 0x48d2b6, Code: 2, Addr: 0xc000100000},
+		},
+		{
+			name: "PanicBus",
+			in: []string{
+				"fatal error: fault",
+				"[signal SIGBUS: bus error code=0x2 addr=0x7f0c3a5f5000 pc=0x46c4e1]",
+				"",
+			},
+			prefix: "fatal error: fault\n[signal SIGBUS: bus error code=0x2 addr=0x7f0c3a5f5000 pc=0x46c4e1]\n\n",
+			want:   &Signal{Name: "SIGBUS", Description: "bus error", PC: 0x46c4e1, Code: 2, Addr: 0x7f0c3a5f5000},
+		},
+		{
+			name: "PanicUnknownSignal",
+			in: []string{
+				"panic: oh no",
+				"[signal 0x40 code=0x0 addr=0x0 pc=0x1000]",
+				"",
+			},
+			prefix: "panic: oh no\n[signal 0x40 code=0x0 addr=0x0 pc=0x1000]\n\n",
+			want:   &Signal{Name: "0x40", PC: 0x1000},
+		},
+		{
+			name:   "None",
+			in:     []string{"panic: 42", ""},
+			prefix: "panic: 42\n\n",
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append(line.in,
+				"goroutine 0 [idle]:",
+				"runtime.futex(0x5b6f30, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x7ffd6b8a4a08, 0x40c2c2, ...)",
+				"\t/goroot/src/runtime/sys_linux_amd64.s:588 +0x21",
+				"",
+				"goroutine 1 [select (no cases)]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x20",
+				"",
+			)
+			prefix := bytes.Buffer{}
+			s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), &prefix, defaultOpts())
+			compareErr(t, io.EOF, err)
+			compareString(t, line.prefix, prefix.String())
+			compareString(t, "", string(suffix))
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			if len(s.Goroutines) != 2 {
+				t.Fatalf("expected 2 goroutines, got %d", len(s.Goroutines))
+			}
+			if diff := cmp.Diff(line.want, s.Signal); diff != "" {
+				t.Fatalf("Signal mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSignal_CodeName(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		code int
+		want string
+	}{
+		{"SIGSEGV", 1, "SEGV_MAPERR"},
+		{"SIGSEGV", 2, "SEGV_ACCERR"},
+		{"SIGBUS", 1, "BUS_ADRALN"},
+		{"SIGBUS", 2, "BUS_ADRERR"},
+		{"SIGFPE", 1, "FPE_INTDIV"},
+		{"SIGILL", 4, "ILL_ILLTRP"},
+		{"SIGSEGV", 0x80, "SI_KERNEL"},
+		{"SIGABRT", -6, "SI_TKILL"},
+		{"SIGQUIT", 0, "SI_USER"},
+		{"SIGQUIT", 1, ""},
+		{"SIGSEGV", 100, ""},
+	}
+	for i, line := range data {
+		s := Signal{Name: line.name, Code: line.code}
+		if got := s.CodeName(); got != line.want {
+			t.Errorf("#%d: %s/%d: CodeName() = %q; want %q", i, line.name, line.code, got, line.want)
+		}
+	}
+}
+
+func TestScanSnapshotSignalAfterHeader(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"goroutine 1 [running]:",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48d2b6]",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x20",
+		"",
+		"goroutine 6 [running]:",
+		"\t[signal SIGBUS: bus error code=0x2 addr=0x0 pc=0x48d2b7]",
+		"main.worker()",
+		"\t/gopath/src/foo/main.go:20 +0x20",
+		"",
+	}
+	prefix := bytes.Buffer{}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), &prefix, defaultOpts())
+	compareErr(t, io.EOF, err)
+	compareString(t, "panic: runtime error: invalid memory address or nil pointer dereference\n", prefix.String())
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if len(s.Goroutines) != 2 {
+		t.Fatalf("expected 2 goroutines, got %d", len(s.Goroutines))
+	}
+	for i, g := range s.Goroutines {
+		if len(g.Stack.Calls) != 1 {
+			t.Fatalf("#%d: expected 1 call, got %d", i, len(g.Stack.Calls))
+		}
+	}
+	// Only the first signal is kept.
+	want := &Signal{Name: "SIGSEGV", Description: "segmentation violation", PC: 0x48d2b6, Code: 1}
+	if diff := cmp.Diff(want, s.Signal); diff != "" {
+		t.Fatalf("Signal mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanSnapshotParseError(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"junk",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x20",
+		"junk",
+		"",
+	}, "\r\n")
+	_, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{Strict: true})
+	p, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError, got %#v", err)
+	}
+	compareErr(t, errors.New("unexpected line after goroutine 1: \"junk\""), err)
+	if p.Line != 5 || p.Offset != 78 {
+		t.Fatalf("unexpected position: line %d, offset %d", p.Line, p.Offset)
+	}
+	compareString(t, "junk\r\n", in[p.Offset:p.Offset+6])
+	if p.Unwrap() != p.Err {
+		t.Fatal("expected Unwrap() to return Err")
+	}
+}
+
+func TestScanSnapshotPartialLastLine(t *testing.T) {
+	t.Parallel()
+	main := newCallOffset("main.main", Args{}, "/gopath/src/foo/main.go", 10, 0x20)
+	worker := newCall("main.worker", Args{Values: []Arg{{Value: 0x1}}}, "/gopath/src/foo/main.go", 20)
+	workerPC := newCallOffset("main.worker", Args{Values: []Arg{{Value: 0x1}}}, "/gopath/src/foo/main.go", 20, 0x20)
+	data := []struct {
+		name   string
+		in     string
+		prefix string
+		suffix string
+		want   []Call
+	}{
+		{
+			// The last line is parsed even without its line ending.
+			name: "File",
+			in:   "junk\ngoroutine 1 [running]:\nmain.main()\n\t/gopath/src/foo/main.go:10 +0x20\nmain.worker(0x1)\n\t/gopath/src/foo/main.go:20 +0x20",
+			want: []Call{main, workerPC},
+		},
+		{
+			name: "FileNoOffset",
+			in:   "junk\ngoroutine 1 [running]:\nmain.main()\n\t/gopath/src/foo/main.go:10 +0x20\nmain.worker(0x1)\n\t/gopath/src/foo/main.go:20",
+			want: []Call{main, worker},
+		},
+		{
+			name:   "Junk",
+			in:     "junk\ngoroutine 1 [running]:\nmain.main()\n\t/gopath/src/foo/main.go:10 +0x20\n\njunk",
+			suffix: "junk",
+			want:   []Call{main},
+		},
+		{
+			name:   "OnlyJunk",
+			in:     "junk\nmore junk",
+			prefix: "junk\nmore junk",
+		},
+	}
+	readers := []struct {
+		name string
+		new  func(r io.Reader) io.Reader
+	}{
+		{"OneByte", iotest.OneByteReader},
+		{"Half", iotest.HalfReader},
+		{"DataErr", iotest.DataErrReader},
+	}
+	for i, line := range data {
+		for _, r := range readers {
+			line := line
+			r := r
+			t.Run(fmt.Sprintf("%d-%s-%s", i, line.name, r.name), func(t *testing.T) {
+				t.Parallel()
+				prefix := bytes.Buffer{}
+				s, suffix, err := ScanSnapshot(r.new(bytes.NewBufferString(line.in)), &prefix, &Opts{})
+				compareErr(t, io.EOF, err)
+				if line.prefix == "" {
+					compareString(t, "junk\n", prefix.String())
+				} else {
+					compareString(t, line.prefix, prefix.String())
+				}
+				compareString(t, line.suffix, string(suffix))
+				if line.want == nil {
+					if s != nil {
+						t.Fatalf("unexpected snapshot %v", s)
+					}
+					return
+				}
+				if s == nil || len(s.Goroutines) != 1 {
+					t.Fatalf("expected one goroutine, got %v", s)
+				}
+				if diff := cmp.Diff(line.want, s.Goroutines[0].Stack.Calls); diff != "" {
+					t.Fatalf("Calls mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestScanSnapshotExitInfo(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name   string
+		in     []string
+		suffix string
+		want   *ExitInfo
+	}{
+		{
+			name:   "ExitStatus",
+			in:     []string{"", "exit status 2", "FAIL\tfoo\t0.012s", ""},
+			suffix: "FAIL\tfoo\t0.012s\n",
+			want:   &ExitInfo{Status: 2},
+		},
+		{
+			name: "NoBlankLine",
+			in:   []string{"signal: killed", ""},
+			want: &ExitInfo{Signal: "killed"},
+		},
+		{
+			name: "CoreDumped",
+			in:   []string{"", "signal: segmentation fault (core dumped)", ""},
+			want: &ExitInfo{Signal: "segmentation fault", CoreDumped: true},
+		},
+		{
+			name:   "None",
+			in:     []string{"", "exit status: 2", ""},
+			suffix: "exit status: 2\n",
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append([]string{
+				"panic: oh no",
+				"",
+				"goroutine 1 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x20",
+			}, line.in...)
+			s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			compareString(t, line.suffix, string(suffix))
+			if diff := cmp.Diff(line.want, s.ExitInfo); diff != "" {
+				t.Fatalf("ExitInfo mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExitInfoString(t *testing.T) {
+	t.Parallel()
+	compareString(t, "exit status 2", (&ExitInfo{Status: 2}).String())
+	compareString(t, "signal: killed", (&ExitInfo{Signal: "killed"}).String())
+	compareString(t, "signal: segmentation fault (core dumped)", (&ExitInfo{Signal: "segmentation fault", CoreDumped: true}).String())
+}
+
+func TestScanSnapshotFatalError(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+		want *FatalError
+	}{
+		{
+			name: "Simple",
+			in:   []string{"fatal error: concurrent map writes", ""},
+			want: &FatalError{Message: "concurrent map writes", GoroutineID: 7, M: -1},
+		},
+		{
+			name: "Thread",
+			in: []string{
+				"fatal error: unexpected signal during runtime execution",
+				"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48d2b6]",
+				"",
+				"runtime stack:",
+				"runtime.throw(0x4c4e23, 0x2a)",
+				"\t/goroot/src/runtime/panic.go:1117 +0x72",
+				"PC=0x46c4e1 m=3 sigcode=0",
+				"",
+			},
+			want: &FatalError{Message: "unexpected signal during runtime execution", GoroutineID: 7, M: 3},
+		},
+		{
+			name: "Panic",
+			in:   []string{"panic: oh no", ""},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append(line.in,
+				"goroutine 7 [running]:",
+				"main.main()",
+				"\t/gopath/src/foo/main.go:10 +0x20",
+				"",
+			)
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			if diff := cmp.Diff(line.want, s.FatalError); diff != "" {
+				t.Fatalf("FatalError mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotOutOfMemory(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"runtime: unrelated",
+		"starting",
+		"runtime: out of memory: cannot allocate 1073741824-byte block (3221225472 in use)",
+		"fatal error: out of memory",
+		"",
+		"runtime stack:",
+		"runtime.throw(0x4c4e23, 0xd)",
+		"\t/goroot/src/runtime/panic.go:1117 +0x72",
+		"runtime.(*mcache).allocLarge(0x7f2b4c1e6108, 0x40000000, 0x101, 0x7f2b4c1e6108)",
+		"\t/goroot/src/runtime/mcache.go:226 +0x29e",
+		"runtime.mallocgc.func1()",
+		"\t/goroot/src/runtime/malloc.go:1052 +0x4c",
+		"",
+		"goroutine 1 [running]:",
+		"runtime.mallocgc(0x40000000, 0x4b0f60, 0x1, 0x0)",
+		"\t/goroot/src/runtime/malloc.go:1051 +0x785",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x20",
+		"",
+	}, "\n")
+	prefix := bytes.Buffer{}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), &prefix, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil || len(s.Goroutines) != 1 || len(s.Goroutines[0].Stack.Calls) != 2 {
+		t.Fatalf("unexpected snapshot %v", s)
+	}
+	// The runtime doesn't print the thread on an out of memory error.
+	want := &FatalError{
+		Message:     "out of memory",
+		GoroutineID: 1,
+		M:           -1,
+		RuntimeMessages: []string{
+			"out of memory: cannot allocate 1073741824-byte block (3221225472 in use)",
+		},
+	}
+	if diff := cmp.Diff(want, s.FatalError); diff != "" {
+		t.Fatalf("FatalError mismatch (-want +got):\n%s", diff)
+	}
+	if !s.FatalError.IsOutOfMemory() {
+		t.Fatal("expected out of memory")
+	}
+	if (&FatalError{Message: "concurrent map writes"}).IsOutOfMemory() {
+		t.Fatal("unexpected out of memory")
+	}
+	// The lines are still written to prefix.
+	compareString(t, strings.Join(strings.SplitAfter(in, "\n")[:13], ""), prefix.String())
+}
+
+func TestScanSnapshotPanicMessage(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name   string
+		in     []string
+		prefix string
+		want   string
+	}{
+		{
+			name:   "Normal",
+			in:     []string{"junk", "panic: oh no", "", "goroutine 1 [running]:"},
+			prefix: "junk\npanic: oh no\n\n",
+			want:   "oh no",
+		},
+		{
+			name:   "Combined",
+			in:     []string{"junk", "panic: oh nogoroutine 1 [running]:"},
+			prefix: "junk\npanic: oh no\n",
+			want:   "oh no",
+		},
+		{
+			name:   "CombinedSpace",
+			in:     []string{"panic: runtime error: index out of range [5] with length 3 goroutine 1 [running]:"},
+			prefix: "panic: runtime error: index out of range [5] with length 3\n",
+			want:   "runtime error: index out of range [5] with length 3",
+		},
+		{
+			name: "None",
+			in:   []string{"goroutine 1 [running]:"},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append(line.in, "main.main()", "\t/gopath/src/foo/main.go:10 +0x20", "")
+			prefix := bytes.Buffer{}
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), &prefix, &Opts{})
+			compareErr(t, io.EOF, err)
+			compareString(t, line.prefix, prefix.String())
+			if s == nil || len(s.Goroutines) != 1 || len(s.Goroutines[0].Stack.Calls) != 1 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			compareString(t, "running", s.Goroutines[0].State)
+			compareString(t, line.want, s.PanicMessage)
+		})
+	}
+}
+
+func TestScanSnapshotNestedPanics(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+		want []*Panic
+		msg  string
+	}{
+		{
+			name: "ThreeLevels",
+			in: []string{
+				"panic: first [recovered]",
+				"\tpanic: second [recovered]",
+				"\tpanic: third",
+				"",
+				"goroutine 6 [running]:",
+			},
+			want: []*Panic{
+				{Message: "first", Recovered: true, GoroutineID: 6},
+				{Message: "second", Recovered: true, GoroutineID: 6},
+				{Message: "third", GoroutineID: 6},
+			},
+			msg: "third",
+		},
+		{
+			name: "Repanicked",
+			in: []string{
+				"panic: first [recovered, repanicked]",
+				"goroutine 6 [running]:",
+			},
+			want: []*Panic{{Message: "first", Recovered: true, GoroutineID: 6}},
+			msg:  "first",
+		},
+		{
+			name: "Signal",
+			in: []string{
+				"panic: first [recovered]",
+				"\tpanic: runtime error: invalid memory address or nil pointer dereference",
+				"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a8a2e]",
+				"",
+				"goroutine 6 [running]:",
+			},
+			want: []*Panic{
+				{Message: "first", Recovered: true, GoroutineID: 6},
+				{Message: "runtime error: invalid memory address or nil pointer dereference", GoroutineID: 6},
+			},
+			msg: "runtime error: invalid memory address or nil pointer dereference",
+		},
+		{
+			name: "NewChain",
+			in: []string{
+				"panic: stale [recovered]",
+				"\tpanic: stale too",
+				"junk",
+				"panic: first [recovered]",
+				"\tpanic: second",
+				"goroutine 6 [running]:",
+			},
+			want: []*Panic{
+				{Message: "first", Recovered: true, GoroutineID: 6},
+				{Message: "second", GoroutineID: 6},
+			},
+			msg: "second",
+		},
+		{
+			name: "IndentedOnly",
+			in:   []string{"\tpanic: orphan", "goroutine 6 [running]:"},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := append(line.in, "main.worker()", "\t/gopath/src/foo/main.go:10 +0x20", "created by main.main", "\t/gopath/src/foo/main.go:5 +0x10", "")
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil || len(s.Goroutines) != 1 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			if diff := cmp.Diff(line.want, s.Panics); diff != "" {
+				t.Fatalf("-want, +got:\n%s", diff)
+			}
+			compareString(t, line.msg, s.PanicMessage)
+		})
+	}
+}
+
+func TestScanSnapshotMaxArgs(t *testing.T) {
+	t.Parallel()
+	many := make([]string, 2000)
+	for i := range many {
+		many[i] = "0x1"
+	}
+	data := []struct {
+		name    string
+		args    string
+		maxArgs int
+		want    Args
+	}{
+		{
+			name:    "Capped",
+			args:    "0x1, 0x2, 0x3, 0x4, 0x5",
+			maxArgs: 3,
+			want:    Args{Values: []Arg{{Value: 1}, {Value: 2}, {Value: 3}}, Elided: true, Truncated: true},
+		},
+		{
+			name:    "Exact",
+			args:    "0x1, 0x2, 0x3, ...",
+			maxArgs: 3,
+			want:    Args{Values: []Arg{{Value: 1}, {Value: 2}, {Value: 3}}, Elided: true},
+		},
+		{
+			name: "Default",
+			args: strings.Join(many, ", "),
+			want: Args{Values: make([]Arg, 1000), Elided: true, Truncated: true},
+		},
+	}
+	for i := range data[2].want.Values {
+		data[2].want.Values[i].Value = 1
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			in := "goroutine 1 [running]:\nmain.main(" + line.args + ")\n\t/gopath/src/foo/main.go:10 +0x20\n"
+			s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{MaxArgs: line.maxArgs})
+			compareErr(t, io.EOF, err)
+			if s == nil || len(s.Goroutines) != 1 || len(s.Goroutines[0].Stack.Calls) != 1 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			if diff := cmp.Diff(line.want, s.Goroutines[0].Stack.Calls[0].Args); diff != "" {
+				t.Fatalf("Args mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotOnLine(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"junk",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"",
+		"suffix",
+	}
+	type line struct {
+		line string
+		kind LineKind
+		id   int
+	}
+	var got []line
+	opts := defaultOpts()
+	opts.OnLine = func(l string, kind LineKind, g *Goroutine) {
+		id := 0
+		if g != nil {
+			id = g.ID
+		}
+		got = append(got, line{l, kind, id})
+	}
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []line{
+		{"junk\n", LineJunk, 0},
+		{"goroutine 1 [running]:\n", LineGoroutine, 1},
+		{"main.main()\n", LineGoroutine, 1},
+		{"\t/gopath/src/foo/main.go:12 +0x1\n", LineGoroutine, 1},
+		{"\n", LineGoroutine, 1},
+		{"goroutine 2 [chan receive]:\n", LineGoroutine, 2},
+		{"main.foo()\n", LineGoroutine, 2},
+		{"\t/gopath/src/foo/main.go:20 +0x1\n", LineGoroutine, 2},
+		{"\n", LineGoroutine, 2},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(line{})); diff != "" {
+		t.Fatalf("OnLine mismatch (-want +got):\n%s", diff)
+	}
+	compareString(t, "suffix", string(suffix))
+}
+
+func TestScanSnapshotOnLineRaceHeader(t *testing.T) {
+	t.Parallel()
+	type reported struct {
+		line string
+		kind LineKind
+		id   int
+	}
+	data := []struct {
+		name string
+		in   []string
+		err  error
+		want []reported
+	}{
+		{
+			name: "NotRace",
+			in: []string{
+				"==================",
+				"junk",
+				"",
+			},
+			err: io.EOF,
+			want: []reported{
+				{"==================\n", LineJunk, 0},
+				{"junk\n", LineJunk, 0},
+			},
+		},
+		{
+			name: "NotRaceAfterWarning",
+			in: []string{
+				"==================",
+				"WARNING: DATA RACE",
+				"junk",
+				"",
+			},
+			err: &ParseError{Line: 3, Offset: 38, Err: errors.New("expected race condition, got: \"junk\"")},
+			want: []reported{
+				{"==================\n", LineJunk, 0},
+				{"WARNING: DATA RACE\n", LineJunk, 0},
+			},
+		},
+		{
+			name: "EOF",
+			in: []string{
+				"==================",
+				"WARNING: DATA RACE",
+			},
+			err: io.EOF,
+			want: []reported{
+				{"==================\n", LineJunk, 0},
+				{"WARNING: DATA RACE", LineJunk, 0},
+			},
+		},
+		{
+			name: "Race",
+			in: []string{
+				"==================",
+				"WARNING: DATA RACE",
+				"Read at 0x00c000014100 by goroutine 8:",
+				"  main.read()",
+				"      /go/src/foo/main.go:137 +0x3a",
+				"",
+				"Previous write at 0x00c000014100 by goroutine 7:",
+				"  main.write()",
+				"      /go/src/foo/main.go:132 +0x41",
+				"",
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"",
+				"Goroutine 7 (finished) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:53 +0x6c8",
+				"==================",
+				"",
+			},
+			err: io.EOF,
+			want: []reported{
+				{"==================\n", LineSnapshot, 0},
+				{"WARNING: DATA RACE\n", LineSnapshot, 0},
+				{"Read at 0x00c000014100 by goroutine 8:\n", LineGoroutine, 8},
+			},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			var got []reported
+			opts := defaultOpts()
+			opts.OnLine = func(l string, kind LineKind, g *Goroutine) {
+				id := 0
+				if g != nil {
+					id = g.ID
+				}
+				got = append(got, reported{l, kind, id})
+			}
+			_, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, opts)
+			compareErr(t, line.err, err)
+			// Only the start of the race report matters.
+			if len(got) > len(line.want) {
+				got = got[:len(line.want)]
+			}
+			if diff := cmp.Diff(line.want, got, cmp.AllowUnexported(reported{})); diff != "" {
+				t.Fatalf("OnLine mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotOnGoroutine(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x2",
+		"",
+		"suffix",
+	}
+	// Log both callbacks to assert the goroutines are reported while parsing.
+	var got []string
+	opts := defaultOpts()
+	opts.OnLine = func(l string, kind LineKind, g *Goroutine) {
+		got = append(got, l)
+	}
+	opts.OnGoroutine = func(g *Goroutine) bool {
+		got = append(got, fmt.Sprintf("goroutine %d: %d calls, %d creators", g.ID, len(g.Stack.Calls), len(g.CreatedBy.Calls)))
+		return true
+	}
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []string{
+		"goroutine 1 [running]:\n",
+		"main.main()\n",
+		"\t/gopath/src/foo/main.go:12 +0x1\n",
+		"\n",
+		"goroutine 2 [chan receive]:\n",
+		"goroutine 1: 1 calls, 0 creators",
+		"main.foo()\n",
+		"\t/gopath/src/foo/main.go:20 +0x1\n",
+		"created by main.main\n",
+		"\t/gopath/src/foo/main.go:11 +0x2\n",
+		"\n",
+		"goroutine 2: 1 calls, 1 creators",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("OnGoroutine mismatch (-want +got):\n%s", diff)
+	}
+	compareString(t, "suffix", string(suffix))
+}
+
+func TestScanSnapshotOnGoroutineRace(t *testing.T) {
+	t.Parallel()
+	var got []int
+	opts := defaultOpts()
+	opts.OnGoroutine = func(g *Goroutine) bool {
+		got = append(got, g.ID)
+		return true
+	}
+	s, _, err := ScanSnapshot(bytes.NewReader(internaltest.PanicOutputs()["race"]), ioutil.Discard, opts)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if s == nil || !s.IsRace() {
+		t.Fatal("expected a race")
+	}
+	var want []int
+	for _, g := range s.Goroutines {
+		want = append(want, g.ID)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("OnGoroutine mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanSnapshotOnGoroutineDrop(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x2",
+		"",
+		"goroutine 3 [select]:",
+		"main.bar()",
+		"\t/gopath/src/foo/main.go:30 +0x1",
+		"",
+		"goroutine 4 [chan receive]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x2",
+		"",
+		"suffix",
+	}
+	data := []struct {
+		name string
+		drop []int
+		want []int
+	}{
+		{"None", nil, []int{1, 2, 3, 4}},
+		{"First", []int{1}, []int{2, 3, 4}},
+		{"Middle", []int{2, 3}, []int{1, 4}},
+		{"Last", []int{4}, []int{1, 2, 3}},
+		{"All", []int{1, 2, 3, 4}, []int{}},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			var notified []int
+			opts := defaultOpts()
+			opts.OnGoroutine = func(g *Goroutine) bool {
+				notified = append(notified, g.ID)
+				for _, id := range line.drop {
+					if g.ID == id {
+						return false
+					}
+				}
+				return true
+			}
+			s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+			compareErr(t, io.EOF, err)
+			compareString(t, "suffix", string(suffix))
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			got := []int{}
+			for _, g := range s.Goroutines {
+				got = append(got, g.ID)
+			}
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]int{1, 2, 3, 4}, notified); diff != "" {
+				t.Fatalf("OnGoroutine mismatch (-want +got):\n%s", diff)
+			}
+
+			// Streaming.
+			a, _, err := StreamAggregate(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts, AnyValue, nil)
+			compareErr(t, io.EOF, err)
+			got = []int{}
+			if a != nil {
+				for _, b := range a.Buckets {
+					got = append(got, b.IDs...)
+				}
+			}
+			sort.Ints(got)
+			if diff := cmp.Diff(line.want, got); diff != "" {
+				t.Fatalf("streamed IDs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotStateFilter(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:12 +0x1",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x2",
+		"",
+		"goroutine 3 [select]:",
+		"main.bar()",
+		"\t/gopath/src/foo/main.go:30 +0x1",
+		"",
+		"goroutine 4 [chan receive, 3 minutes]:",
+		"main.foo()",
+		"\t/gopath/src/foo/main.go:20 +0x1",
+		"created by main.main",
+		"\t/gopath/src/foo/main.go:11 +0x2",
+		"",
+		"goroutine 5 [select]:",
+		"main.bar()",
+		"\t/gopath/src/foo/main.go:30 +0x1",
+		"",
+		"suffix",
+	}, "\n")
+	var notified []int
+	opts := defaultOpts()
+	opts.StateFilter = func(state string) bool {
+		return state == "chan receive"
+	}
+	opts.OnGoroutine = func(g *Goroutine) bool {
+		notified = append(notified, g.ID)
+		return true
+	}
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	compareString(t, "suffix", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	var got []int
+	for _, g := range s.Goroutines {
+		if g.First {
+			t.Errorf("goroutine %d is not the first one", g.ID)
+		}
+		got = append(got, g.ID)
+	}
+	if diff := cmp.Diff([]int{2, 4}, got); diff != "" {
+		t.Fatalf("Goroutines mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{2, 4}, notified); diff != "" {
+		t.Fatalf("OnGoroutine mismatch (-want +got):\n%s", diff)
+	}
+
+	// Streaming.
+	a, _, err := StreamAggregate(bytes.NewBufferString(in), ioutil.Discard, opts, AnyValue, nil)
+	compareErr(t, io.EOF, err)
+	if a == nil || len(a.Buckets) != 1 {
+		t.Fatalf("expected one bucket, got %v", a)
+	}
+	if diff := cmp.Diff([]int{2, 4}, a.Buckets[0].IDs); diff != "" {
+		t.Fatalf("IDs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	t.Parallel()
+	root, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}()
+	// The dump is split in the middle of a goroutine, and in the middle of a
+	// line.
+	parts := []string{
+		"junk\npanic: oh no\n\ngoroutine 1 [running]:\nmain.main()\n\t/gopath/src/foo/ma",
+		"in.go:10 +0x20\n\ngoroutine 6 [chan receive]:\nmain.worker()\n",
+		"\t/gopath/src/foo/main.go:20 +0x20\n\nsuffix\nmore\n",
+	}
+	var paths []string
+	for i, c := range parts {
+		p := filepath.Join(root, fmt.Sprintf("log.%d", i))
+		if err = ioutil.WriteFile(p, []byte(c), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	prefix := bytes.Buffer{}
+	got, suffix, err := ParseFiles(paths, &prefix, &Opts{})
+	compareErr(t, nil, err)
+	compareString(t, "junk\npanic: oh no\n\n", prefix.String())
+	compareString(t, "suffix\nmore\n", string(suffix))
+	if got == nil {
+		t.Fatal("expected snapshot")
+	}
+	want := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				Stack: Stack{Calls: []Call{newCall("main.main", Args{}, "/gopath/src/foo/main.go", 10)}},
+			},
+			ID:    1,
+			First: true,
+		},
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{Calls: []Call{newCallOffset("main.worker", Args{}, "/gopath/src/foo/main.go", 20, 0x20)}},
+			},
+			ID: 6,
+		},
+	}
+	similarGoroutines(t, want, got.Goroutines)
+
+	if _, _, err = ParseFiles([]string{paths[0], filepath.Join(root, "missing")}, ioutil.Discard, &Opts{}); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestParseDir(t *testing.T) {
+	t.Parallel()
+	root, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}()
+	files := map[string]string{
+		// The same crash twice, from different builds.
+		"a.log": "panic: oh no\n\ngoroutine 1 [running]:\nmain.crash(0x1)\n\t/gopath/src/foo/main.go:10 +0x20\nmain.main()\n\t/gopath/src/foo/main.go:20 +0x20\n",
+		"b.log": "panic: oh no\n\ngoroutine 1 [running]:\nmain.crash(0x2)\n\t/gopath/src/foo/main.go:12 +0x20\nmain.main()\n\t/gopath/src/foo/main.go:22 +0x20\n",
+		"c.log": "panic: oh no\n\ngoroutine 1 [running]:\nmain.other()\n\t/gopath/src/foo/main.go:10 +0x20\nmain.main()\n\t/gopath/src/foo/main.go:20 +0x20\n",
+		"d.txt": "not a dump\n",
+		// A broken file doesn't stop the processing of the next ones.
+		"b0rked.log": "goroutine 1 [running]:\nmain.main()\nfoo\n",
+	}
+	for n, c := range files {
+		if err = ioutil.WriteFile(filepath.Join(root, n), []byte(c), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(root, "e"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, counts, errs, err := ParseDir(root, false)
+	compareErr(t, nil, err)
+	if len(errs) != 1 || errs["b0rked.log"] == nil {
+		t.Fatalf("expected an error for b0rked.log, got %v", errs)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(snapshots))
+	}
+	a := snapshots[0].Fingerprint()
+	c := snapshots[2].Fingerprint()
+	if diff := cmp.Diff(map[string]int{a: 2, c: 1}, counts); diff != "" {
+		t.Fatalf("counts mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, _, err = ParseDir(filepath.Join(root, "missing"), false); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	t.Parallel()
+	if p := splitPath(""); p != nil {
+		t.Fatalf("expected nil, got: %v", p)
+	}
+}
+
+func TestGetGOPATHs(t *testing.T) {
+	// This test cannot run in parallel.
+	old := os.Getenv("GOPATH")
+	defer os.Setenv("GOPATH", old)
+	os.Setenv("GOPATH", "")
+	if p := getGOPATHs(); len(p) != 1 {
+		// It's the home directory + /go.
+		t.Fatalf("expected only one path: %v", p)
+	}
+
+	root, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}()
+	os.Setenv("GOPATH", filepath.Join(root, "a")+string(filepath.ListSeparator)+filepath.Join(root, "b")+string(filepath.Separator))
+	if p := getGOPATHs(); len(p) != 2 {
+		t.Fatalf("expected two paths: %v", p)
+	}
+}
+
+// TestGomoduleComplex is an integration test that creates a non-trivial tree
+// of go modules using the "replace" statement.
+func TestGomoduleComplex(t *testing.T) {
+	// This test cannot run in parallel.
+	if internaltest.GetGoMinorVersion() < 11 {
+		t.Skip("requires go module support")
+	}
+	old := os.Getenv("GOPATH")
+	defer os.Setenv("GOPATH", old)
+	root, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	os.Setenv("GOPATH", filepath.Join(root, "go"))
+	tree := map[string]string{
+		"pkg1/go.mod": "module example.com/pkg1\n" +
 			"require (\n" +
 			"\texample.com/pkg2 v0.0.1\n" +
 			"\texample.com/pkg3 v0.0.1\n" +
@@ -1482,10 +3849,13 @@ func TestGoRun(t *testing.T) {
 	s, suffix, err := ScanSnapshot(bytes.NewReader(out), &prefix, defaultOpts())
 	compareErr(t, nil, err)
 	compareString(t, "panic: 42\n\n", prefix.String())
-	compareString(t, "exit status 2\n", string(suffix))
+	compareString(t, "", string(suffix))
 	if s == nil {
 		t.Fatal("expected snapshot")
 	}
+	if diff := cmp.Diff(&ExitInfo{Status: 2}, s.ExitInfo); diff != "" {
+		t.Fatalf("ExitInfo mismatch (-want +got):\n%s", diff)
+	}
 	if runtime.GOOS == "windows" {
 		// On Windows, we must make the path to be POSIX style.
 		p = strings.Replace(p, pathSeparator, "/", -1)
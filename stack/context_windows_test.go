@@ -0,0 +1,57 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"testing"
+)
+
+func TestHasPathPrefixWindows(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		p      string
+		prefix string
+		want   bool
+	}{
+		{"C:/go/src/runtime/proc.go", "C:/go/src/", true},
+		{"c:/go/src/runtime/proc.go", "C:/go/src/", true},
+		{"C:/go/src/runtime/proc.go", "c:/go/src/", true},
+		{"C:/Go/src/runtime/proc.go", "c:/go/src/", true},
+		{"D:/go/src/runtime/proc.go", "C:/go/src/", false},
+		{"C:/go", "C:/go/src/", false},
+	}
+	for i, line := range data {
+		if got := hasPathPrefix(line.p, line.prefix); got != line.want {
+			t.Errorf("#%d: hasPathPrefix(%q, %q) = %t", i, line.p, line.prefix, got)
+		}
+	}
+}
+
+func TestHasSrcPrefixWindows(t *testing.T) {
+	t.Parallel()
+	roots := map[string]string{"C:/Users/joe/go": "c:/users/joe/go"}
+	if !hasSrcPrefix("c:/Users/joe/go/src/foo/bar.go", roots) {
+		t.Error("expected /src/ match")
+	}
+	if !hasSrcPrefix("c:/users/joe/go/pkg/mod/foo@v1.0.0/bar.go", roots) {
+		t.Error("expected /pkg/mod/ match")
+	}
+	if !hasPrefix("c:/Users/joe/go/foo/bar.go", roots) {
+		t.Error("expected match")
+	}
+}
+
+func TestUpdateLocationsDriveLetterWindows(t *testing.T) {
+	t.Parallel()
+	c := Call{RemoteSrcPath: "c:/go/src/runtime/proc.go", Line: 307}
+	if !c.updateLocations("C:/go", "C:/local/go", nil, nil) {
+		t.Fatal("expected GOROOT match")
+	}
+	compareString(t, "runtime/proc.go", c.RelSrcPath)
+	compareString(t, "C:/local/go/src/runtime/proc.go", c.LocalSrcPath)
+	if c.Location != Stdlib {
+		t.Fatalf("want Stdlib, got %s", c.Location)
+	}
+}
@@ -16,25 +16,23 @@
 {{- /* Accepts a Args */ -}}
 {{- define "RenderArgs" -}}
   <span class="args"><span>
-  {{- $elided := .Elided -}}
+  {{- $at := elidedAt . -}}
+  {{- $l := len .Values -}}
   {{- if .Processed -}}
-    {{- $l := len .Processed -}}
-    {{- $last := minus $l 1 -}}
+    {{- $l = len .Processed -}}
     {{- range $i, $e := .Processed -}}
+      {{- if $i}}, {{end -}}
+      {{- if eq $i $at}}…, {{end -}}
       {{- $e -}}
-      {{- $isNotLast := ne $i $last -}}
-      {{- if or $elided $isNotLast}}, {{end -}}
     {{- end -}}
   {{- else -}}
-    {{- $l := len .Values -}}
-    {{- $last := minus $l 1 -}}
     {{- range $i, $e := .Values -}}
+      {{- if $i}}, {{end -}}
+      {{- if eq $i $at}}…, {{end -}}
       {{- $e.String -}}
-      {{- $isNotLast := ne $i $last -}}
-      {{- if or $elided $isNotLast}}, {{end -}}
     {{- end -}}
   {{- end -}}
-  {{- if $elided}}…{{end -}}
+  {{- if eq $at $l}}{{if $l}}, {{end}}…{{end -}}
   </span></span>
 {{- end -}}
 
@@ -80,6 +78,18 @@
           <span class="{{funcClass $e}}"><a href="{{pkgURL $e}}">{{$e.Func.Name}}</a></span>({{template "RenderArgs" $e.Args}})
         </td>
       </tr>
+      {{- with source $e -}}
+        <tr>
+          <td></td>
+          <td colspan="3">
+            <pre class="source">
+              {{- range . -}}
+                <span{{if .Current}} class="current"{{end}}>{{printf "%5d" .Line}}  {{.Text}}</span>{{"\n"}}
+              {{- end -}}
+            </pre>
+          </td>
+        </tr>
+      {{- end -}}
     {{- end -}}
     {{- if .Elided}}<tr><td>(…)</td><tr>{{end -}}
   </table>
@@ -193,6 +203,14 @@
   .bottom-padding {
     margin-top: 5em;
   }
+  .source {
+    font-family: monospace;
+    margin: 0;
+  }
+  .source .current {
+    background-color: #FDD;
+    font-weight: 700;
+  }
 
   {{- /* Highlights based on stack.Location value. */ -}}
   .FuncMain {
@@ -44,10 +44,13 @@ func (s *Snapshot) ToHTML(w io.Writer, footer template.HTML) error {
 // Private stuff.
 
 func toHTML(w io.Writer, data map[string]interface{}) error {
+	src, _ := data["Source"].(*sourceCache)
 	m := template.FuncMap{
+		"elidedAt":  elidedAt,
 		"funcClass": funcClass,
 		"minus":     minus,
 		"pkgURL":    pkgURL,
+		"source":    src.lines,
 		"srcURL":    srcURL,
 		"symbol":    symbol,
 	}
@@ -75,6 +78,11 @@ func funcClass(c *Call) template.HTML {
 	return template.HTML("Func" + s)
 }
 
+// elidedAt returns the index at which the ellipsis must be rendered, or -1.
+func elidedAt(a Args) int {
+	return a.elidedAt()
+}
+
 func minus(i, j int) int {
 	return i - j
 }
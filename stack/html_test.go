@@ -242,7 +242,7 @@ func TestSnapshot_ToHTML(t *testing.T) {
 	t.Parallel()
 	data := internaltest.PanicOutputs()["race"]
 	s, _, err := ScanSnapshot(bytes.NewReader(data), ioutil.Discard, DefaultOpts())
-	if err != nil {
+	if err != nil && err != io.EOF {
 		t.Fatal(err)
 	}
 	if s.Goroutines == nil {
@@ -259,6 +259,44 @@ func TestSnapshot_ToHTML(t *testing.T) {
 	}
 }
 
+func TestSnapshot_ToHTML_ElidedArgs(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		args Args
+		want string
+	}{
+		{Args{Values: []Arg{{Value: 1}, {Value: 2}}, Elided: true}, "1, 2, …"},
+		{Args{Values: []Arg{{Value: 1}, {Value: 2}, {Value: 9}}, Elided: true, AfterElided: 1}, "1, 2, …, 9"},
+		{Args{Elided: true}, "<span>…"},
+		{Args{Values: []Arg{{Value: 1}, {Value: 9}}, Processed: []string{"int = 1", "int = 9"}, Elided: true, AfterElided: 1}, "int = 1, …, int = 9"},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Parallel()
+			s := &Snapshot{
+				Goroutines: []*Goroutine{
+					{
+						Signature: Signature{
+							State: "running",
+							Stack: Stack{Calls: []Call{newCall("main.f", line.args, "/gopath/src/foo/main.go", 10)}},
+						},
+						ID:    1,
+						First: true,
+					},
+				},
+			}
+			buf := bytes.Buffer{}
+			if err := s.ToHTML(&buf, ""); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), line.want+"</span></span>") {
+				t.Fatalf("missing %q", line.want)
+			}
+		})
+	}
+}
+
 func BenchmarkAggregated_ToHTML(b *testing.B) {
 	b.ReportAllocs()
 	s, _, err := ScanSnapshot(bytes.NewReader(internaltest.StaticPanicwebOutput()), ioutil.Discard, DefaultOpts())
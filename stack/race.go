@@ -0,0 +1,87 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// This file contains the data race report structures.
+
+package stack
+
+// RaceReport is a data race report as printed by the race detector.
+type RaceReport struct {
+	// Operations are the memory accesses that raced, in the order they were
+	// printed. The first one is the current access, the following ones are the
+	// previous accesses.
+	Operations []*RaceOperation
+	// Global is the global variable that was accessed, if the race detector
+	// found one. It is nil otherwise, e.g. for an access to the heap.
+	Global *RaceGlobal
+	// Count is the total number of data races found by the race detector, as
+	// printed in the "Found N data race(s)" line when the process exits. It is
+	// only set when this line directly follows the report, otherwise it is 0.
+	Count int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// RaceOperation is a memory access part of a data race.
+type RaceOperation struct {
+	// Write is true if the access was a write, otherwise it was a read.
+	Write bool
+	// Addr is the address accessed.
+	Addr uint64
+	// Stack is the call stack of the access.
+	Stack Stack
+	// Goroutine is the goroutine that did the access.
+	//
+	// Its CreatedBy is the stack where the goroutine was created, as printed
+	// in the "Goroutine N (running) created at:" section. Its Stack is the same
+	// as the operation's Stack.
+	Goroutine *Goroutine
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// RaceGlobal is a global variable involved in a data race, as printed by the
+// race detector.
+type RaceGlobal struct {
+	// Name is the name of the variable, e.g. "main.counter".
+	Name string
+	// Size is the size of the variable in bytes.
+	Size uint64
+	// Addr is the address of the variable.
+	Addr uint64
+	// SrcPath is the source file where the variable is declared, as printed
+	// by the race detector.
+	SrcPath string
+	// Line is the line where the variable is declared.
+	Line int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Private stuff.
+
+// finish completes the report once all the lines were parsed.
+//
+// The creation stack of a goroutine is printed once even if the goroutine did
+// multiple accesses, so it is copied to all the goroutines with the same ID.
+func (r *RaceReport) finish() {
+	for i, op := range r.Operations {
+		op.Stack = op.Goroutine.Stack
+		for _, prev := range r.Operations[:i] {
+			if prev.Goroutine.ID != op.Goroutine.ID {
+				continue
+			}
+			if len(op.Goroutine.CreatedBy.Calls) == 0 {
+				op.Goroutine.CreatedBy = prev.Goroutine.CreatedBy
+			}
+			if op.Goroutine.State == "" {
+				op.Goroutine.State = prev.Goroutine.State
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScanSnapshotRaceReport(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"==================",
+		"WARNING: DATA RACE",
+		"Read at 0x00c000014100 by goroutine 8:",
+		"  main.read()",
+		"      /go/src/foo/main.go:137 +0x3a",
+		"",
+		"Previous write at 0x00c000014100 by goroutine 7:",
+		"  main.write()",
+		"      /go/src/foo/main.go:132 +0x41",
+		"",
+		"Previous write at 0x00c000014100 by goroutine 8:",
+		"  main.write()",
+		"      /go/src/foo/main.go:133 +0x41",
+		"",
+		"Goroutine 8 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:54 +0x6c8",
+		"",
+		"Goroutine 7 (finished) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:53 +0x6c8",
+		"==================",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil || s.Race == nil {
+		t.Fatal("expected race report")
+	}
+	if len(s.Race.Operations) != 3 {
+		t.Fatalf("want 3 operations, got %d", len(s.Race.Operations))
+	}
+	type op struct {
+		Write   bool
+		Addr    uint64
+		ID      int
+		State   string
+		Stack   []Call
+		Created []Call
+	}
+	want := []op{
+		{
+			Addr:    0xc000014100,
+			ID:      8,
+			State:   "running",
+			Stack:   []Call{newCallOffset("main.read", Args{}, "/go/src/foo/main.go", 137, 0x3a)},
+			Created: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 54, 0x6c8)},
+		},
+		{
+			Write:   true,
+			Addr:    0xc000014100,
+			ID:      7,
+			State:   "finished",
+			Stack:   []Call{newCallOffset("main.write", Args{}, "/go/src/foo/main.go", 132, 0x41)},
+			Created: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 53, 0x6c8)},
+		},
+		{
+			Write: true,
+			Addr:  0xc000014100,
+			ID:    8,
+			State: "running",
+			Stack: []Call{newCallOffset("main.write", Args{}, "/go/src/foo/main.go", 133, 0x41)},
+			// The creation stack is printed only once per goroutine.
+			Created: []Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 54, 0x6c8)},
+		},
+	}
+	var got []op
+	for i, o := range s.Race.Operations {
+		if o.Goroutine != s.Goroutines[i] {
+			t.Errorf("#%d: operation doesn't point to its goroutine", i)
+		}
+		got = append(got, op{
+			Write:   o.Write,
+			Addr:    o.Addr,
+			ID:      o.Goroutine.ID,
+			State:   o.Goroutine.State,
+			Stack:   o.Stack.Calls,
+			Created: o.Goroutine.CreatedBy.Calls,
+		})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Operations mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanSnapshotRaceFinishedNoStack(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+	}{
+		{
+			"FailedToRestore",
+			[]string{
+				"Goroutine 7 (finished) created at:",
+				"    [failed to restore the stack]",
+				"",
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"==================",
+			},
+		},
+		{
+			"Empty",
+			[]string{
+				"Goroutine 7 (finished) created at:",
+				"",
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"==================",
+			},
+		},
+		{
+			"Last",
+			[]string{
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"",
+				"Goroutine 7 (finished) created at:",
+				"    [failed to restore the stack]",
+				"==================",
+			},
+		},
+		{
+			"LastEmpty",
+			[]string{
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"",
+				"Goroutine 7 (finished) created at:",
+				"==================",
+			},
+		},
+	}
+	for _, line := range data {
+		line := line
+		t.Run(line.name, func(t *testing.T) {
+			t.Parallel()
+			in := append([]string{
+				"==================",
+				"WARNING: DATA RACE",
+				"Read at 0x00c000014100 by goroutine 8:",
+				"  main.read()",
+				"      /go/src/foo/main.go:137 +0x3a",
+				"",
+				"Previous write at 0x00c000014100 by goroutine 7:",
+				"  main.write()",
+				"      /go/src/foo/main.go:132 +0x41",
+				"",
+			}, line.in...)
+			in = append(in, "Found 1 data race(s)", "")
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+			compareErr(t, nil, err)
+			if s == nil || s.Race == nil || len(s.Goroutines) != 2 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			compareString(t, "running", s.Goroutines[0].State)
+			if diff := cmp.Diff([]Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 54, 0x6c8)}, s.Goroutines[0].CreatedBy.Calls); diff != "" {
+				t.Fatalf("-want, +got:\n%s", diff)
+			}
+			g := s.Goroutines[1]
+			compareString(t, "finished", g.State)
+			if g.HasCreator() {
+				t.Fatalf("unexpected creator %v", g.CreatedBy.Calls)
+			}
+			if s.Race.Count != 1 {
+				t.Fatalf("unexpected count %d", s.Race.Count)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotRaceOperationNoStack(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"==================",
+		"WARNING: DATA RACE",
+		"Write at 0x00c000014100 by goroutine 8:",
+		"  main.write()",
+		"      /go/src/foo/main.go:137 +0x3a",
+		"",
+		"Previous write at 0x00c000014100 by goroutine 7:",
+		"    [failed to restore the stack]",
+		"",
+		"Goroutine 8 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:54 +0x6c8",
+		"",
+		"Goroutine 7 (finished) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:53 +0x6c8",
+		"==================",
+		"Found 1 data race(s)",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, nil, err)
+	if s == nil || s.Race == nil || len(s.Race.Operations) != 2 {
+		t.Fatalf("unexpected snapshot %v", s)
+	}
+	if diff := cmp.Diff([]Call{newCallOffset("main.write", Args{}, "/go/src/foo/main.go", 137, 0x3a)}, s.Race.Operations[0].Stack.Calls); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+	o := s.Race.Operations[1]
+	if !o.Write || o.Goroutine.ID != 7 || len(o.Stack.Calls) != 1 || !o.Stack.Calls[0].StackUnavailable() {
+		t.Fatalf("unexpected operation %v", o)
+	}
+	compareString(t, "finished", o.Goroutine.State)
+	if diff := cmp.Diff([]Call{newCallOffset("main.main", Args{}, "/go/src/foo/main.go", 53, 0x6c8)}, o.Goroutine.CreatedBy.Calls); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+}
+
+func TestScanSnapshotRaceGlobal(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		name string
+		in   []string
+	}{
+		{
+			"Goroutines",
+			[]string{
+				"Global var main.counter of size 8 at 0x00000064d560 declared at /go/src/foo/main.go:10",
+				"",
+				"Goroutine 8 (running) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:54 +0x6c8",
+				"",
+				"Goroutine 7 (finished) created at:",
+				"  main.main()",
+				"      /go/src/foo/main.go:53 +0x6c8",
+				"==================",
+			},
+		},
+		{
+			"Last",
+			[]string{
+				"Global var main.counter of size 8 at 0x00000064d560 declared at /go/src/foo/main.go:10",
+				"==================",
+			},
+		},
+	}
+	for _, line := range data {
+		line := line
+		t.Run(line.name, func(t *testing.T) {
+			t.Parallel()
+			in := append([]string{
+				"==================",
+				"WARNING: DATA RACE",
+				"Read at 0x00000064d560 by goroutine 8:",
+				"  main.read()",
+				"      /go/src/foo/main.go:137 +0x3a",
+				"",
+				"Previous write at 0x00000064d560 by goroutine 7:",
+				"  main.write()",
+				"      /go/src/foo/main.go:132 +0x41",
+				"",
+			}, line.in...)
+			in = append(in, "Found 1 data race(s)", "")
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+			compareErr(t, nil, err)
+			if s == nil || s.Race == nil || len(s.Race.Operations) != 2 {
+				t.Fatalf("unexpected snapshot %v", s)
+			}
+			want := &RaceGlobal{Name: "main.counter", Size: 8, Addr: 0x64d560, SrcPath: "/go/src/foo/main.go", Line: 10}
+			if diff := cmp.Diff(want, s.Race.Global); diff != "" {
+				t.Fatalf("-want, +got:\n%s", diff)
+			}
+			if diff := cmp.Diff([]Call{newCallOffset("main.write", Args{}, "/go/src/foo/main.go", 132, 0x41)}, s.Race.Operations[1].Stack.Calls); diff != "" {
+				t.Fatalf("-want, +got:\n%s", diff)
+			}
+			if s.Race.Count != 1 {
+				t.Fatalf("unexpected count %d", s.Race.Count)
+			}
+		})
+	}
+}
+
+func TestScanSnapshotNoRaceReport(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/go/src/foo/main.go:10 +0x27",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if s.Race != nil {
+		t.Fatalf("unexpected race report: %#v", s.Race)
+	}
+}
+
+func TestScanSnapshotRaceThenAbort(t *testing.T) {
+	t.Parallel()
+	data := []string{
+		"==================",
+		"WARNING: DATA RACE",
+		"Write at 0x00c000014100 by goroutine 7:",
+		"  main.write()",
+		"      /go/src/foo/main.go:132 +0x41",
+		"",
+		"Previous read at 0x00c000014100 by goroutine 6:",
+		"  main.read()",
+		"      /go/src/foo/main.go:137 +0x3a",
+		"",
+		"Goroutine 7 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:53 +0x6c8",
+		"",
+		"Goroutine 6 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:52 +0x6c8",
+		"==================",
+		"Found 1 data race(s)",
+		"SIGABRT: abort",
+		"PC=0x47c6e1 m=0 sigcode=0",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/go/src/foo/main.go:60 +0x27",
+		"",
+	}
+	in := bytes.NewBufferString(strings.Join(data, "\n"))
+	s, suffix, err := ScanSnapshot(in, ioutil.Discard, defaultOpts())
+	compareErr(t, nil, err)
+	if s == nil || s.Race == nil {
+		t.Fatal("expected race report")
+	}
+	if s.Race.Count != 1 {
+		t.Fatalf("want Count 1, got %d", s.Race.Count)
+	}
+	if len(s.Race.Operations) != 2 {
+		t.Fatalf("want 2 operations, got %d", len(s.Race.Operations))
+	}
+
+	// The abort dump follows.
+	s, suffix, err = ScanSnapshot(io.MultiReader(bytes.NewReader(suffix), in), ioutil.Discard, defaultOpts())
+	compareErr(t, io.EOF, err)
+	compareString(t, "", string(suffix))
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if s.Race != nil {
+		t.Fatal("unexpected race report")
+	}
+	if s.Signal == nil || s.Signal.Name != "SIGABRT" {
+		t.Fatalf("expected SIGABRT, got %#v", s.Signal)
+	}
+	if len(s.Goroutines) != 1 || s.Goroutines[0].ID != 1 {
+		t.Fatalf("unexpected goroutines: %v", s.Goroutines)
+	}
+}
+
+func TestScanSnapshotRaceNoCount(t *testing.T) {
+	t.Parallel()
+	data := []string{
+		"==================",
+		"WARNING: DATA RACE",
+		"Write at 0x00c000014100 by goroutine 7:",
+		"  main.write()",
+		"      /go/src/foo/main.go:132 +0x41",
+		"",
+		"Goroutine 7 (running) created at:",
+		"  main.main()",
+		"      /go/src/foo/main.go:53 +0x6c8",
+		"==================",
+		"some output",
+		"",
+	}
+	s, suffix, err := ScanSnapshot(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, defaultOpts())
+	compareErr(t, nil, err)
+	compareString(t, "some output\n", string(suffix))
+	if s == nil || s.Race == nil {
+		t.Fatal("expected race report")
+	}
+	if s.Race.Count != 0 {
+		t.Fatalf("want Count 0, got %d", s.Race.Count)
+	}
+}
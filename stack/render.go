@@ -0,0 +1,464 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// This file contains the pluggable renderers.
+
+package stack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Renderer writes a snapshot in a specific format.
+type Renderer interface {
+	// Render writes the snapshot to w.
+	Render(w io.Writer, s *Snapshot) error
+}
+
+// Renderers are the available renderers, by name.
+//
+// It can be used to select a renderer at runtime, for example via a command
+// line flag. Additional renderers can be registered by adding them to the map
+// at initialization time.
+var Renderers = map[string]Renderer{
+	"color": &ColorRenderer{},
+	"html":  &HTMLRenderer{},
+	"json":  &JSONRenderer{},
+	"jsonl": &JSONLRenderer{},
+	"text":  &TextRenderer{},
+}
+
+// TextRenderer renders the snapshot in the same format as the Go runtime.
+//
+// Arguments are printed as the raw values, the processed arguments and names
+// are not used.
+type TextRenderer struct {
+	// ArgFormat is how the argument values are printed. The default ArgHex
+	// prints them exactly like the Go runtime.
+	ArgFormat ArgFormat
+	// AbbreviateHome tells to print the source paths under the home directory
+	// of the current user with a "~" prefix, like shells do, e.g.
+	// "~/src/foo/main.go".
+	//
+	// It is only useful for stack traces generated on the local host.
+	AbbreviateHome bool
+	// SourceContext is the number of source lines to print before and after
+	// the line of each call, which is marked with ">". 0 disables printing
+	// the source.
+	//
+	// It requires the local source paths to be resolved, e.g. with
+	// Opts.GuessPaths, unless SourceResolver is set. The calls for which the
+	// source file can't be read are printed without it.
+	SourceContext int
+	// SourceResolver is used to read the source files for SourceContext
+	// instead of the local disk, like Opts.SourceResolver. It is usually set to
+	// the same function.
+	SourceResolver func(srcPath string, line int) (io.ReadCloser, error)
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Render implements Renderer.
+func (t *TextRenderer) Render(w io.Writer, s *Snapshot) error {
+	b := bufio.NewWriter(w)
+	home := ""
+	if t.AbbreviateHome {
+		// Silently print the paths as-is if the home directory is unknown.
+		home, _ = getHomeDir()
+	}
+	var src *sourceCache
+	if t.SourceContext > 0 {
+		src = newSourceCache(t.SourceContext, t.SourceResolver)
+	}
+	for i, g := range s.Goroutines {
+		if i != 0 {
+			_, _ = b.WriteString("\n")
+		}
+		writeGoroutine(b, g, t.ArgFormat, home, src, textColors{})
+	}
+	return b.Flush()
+}
+
+// ColorRenderer renders the snapshot like TextRenderer, with the goroutine
+// headers and the function names highlighted with ANSI escape codes.
+type ColorRenderer struct {
+	// ArgFormat is like TextRenderer.ArgFormat.
+	ArgFormat ArgFormat
+	// Header is the escape code used for the goroutine headers. Defaults to
+	// bold magenta.
+	Header string
+	// Func is the escape code used for the function names. Defaults to bold
+	// yellow.
+	Func string
+	// Reset is the escape code used after a highlighted text. Defaults to
+	// "\033[0m".
+	Reset string
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Render implements Renderer.
+func (c *ColorRenderer) Render(w io.Writer, s *Snapshot) error {
+	colors := textColors{header: c.Header, funcName: c.Func, reset: c.Reset}
+	if colors.header == "" {
+		colors.header = "\033[35;1m"
+	}
+	if colors.funcName == "" {
+		colors.funcName = "\033[33;1m"
+	}
+	if colors.reset == "" {
+		colors.reset = "\033[0m"
+	}
+	b := bufio.NewWriter(w)
+	for i, g := range s.Goroutines {
+		if i != 0 {
+			_, _ = b.WriteString("\n")
+		}
+		writeGoroutine(b, g, c.ArgFormat, "", nil, colors)
+	}
+	return b.Flush()
+}
+
+// HTMLRenderer renders the snapshot as an HTML page.
+type HTMLRenderer struct {
+	// Footer is custom HTML added at the bottom of the page.
+	Footer template.HTML
+	// SourceContext is the number of source lines to show before and after
+	// the line of each call, which is highlighted. 0 disables showing the
+	// source.
+	//
+	// Like with TextRenderer, it requires the local source paths to be
+	// resolved, unless SourceResolver is set.
+	SourceContext int
+	// SourceResolver is like TextRenderer.SourceResolver.
+	SourceResolver func(srcPath string, line int) (io.ReadCloser, error)
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Render implements Renderer.
+func (h *HTMLRenderer) Render(w io.Writer, s *Snapshot) error {
+	data := map[string]interface{}{
+		"Footer":   h.Footer,
+		"Snapshot": s,
+	}
+	if h.SourceContext > 0 {
+		data["Source"] = newSourceCache(h.SourceContext, h.SourceResolver)
+	}
+	return toHTML(w, data)
+}
+
+// JSONRenderer renders the snapshot as JSON, as returned by
+// Snapshot.MarshalJSON().
+type JSONRenderer struct {
+	// Indent is the indentation to use, if any.
+	Indent string
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Render implements Renderer.
+func (j *JSONRenderer) Render(w io.Writer, s *Snapshot) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", j.Indent)
+	return e.Encode(s)
+}
+
+// JSONLRenderer renders the goroutines as JSON Lines: one JSON object per
+// goroutine, each on its own line.
+//
+// Unlike JSONRenderer, the output can be processed one goroutine at a time,
+// e.g. with line oriented tools. The fields of the Snapshot itself are not
+// included.
+type JSONLRenderer struct {
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// Render implements Renderer.
+func (j *JSONLRenderer) Render(w io.Writer, s *Snapshot) error {
+	e := json.NewEncoder(w)
+	for _, g := range s.Goroutines {
+		if err := e.Encode(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSONSchemaVersion is the version of the JSON representation of a Snapshot,
+// found in its "SchemaVersion" field.
+//
+// It is incremented when a field is renamed or removed or when its meaning
+// changes. Adding a field doesn't change it.
+const JSONSchemaVersion = 1
+
+// MarshalJSON implements json.Marshaler.
+//
+// The object has a "SchemaVersion" field set to JSONSchemaVersion, followed
+// by the fields of the Snapshot describing the process. The fields of the
+// nested structures, like Goroutine, Signature, Call and Args, are named after
+// the Go fields and are kept stable across releases.
+//
+// LocalGOROOT, LocalGOPATHs and LocalGomods are not included since they
+// describe the host running the parser, not the process that crashed.
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonSnapshot{
+		SchemaVersion:     JSONSchemaVersion,
+		Goroutines:        s.Goroutines,
+		Signal:            s.Signal,
+		SkippedGoroutines: s.SkippedGoroutines,
+		Race:              s.Race,
+		ExitInfo:          s.ExitInfo,
+		FatalError:        s.FatalError,
+		PanicMessage:      s.PanicMessage,
+		Panics:            s.Panics,
+		RemoteGOROOT:      s.RemoteGOROOT,
+		RemoteGOPATHs:     s.RemoteGOPATHs,
+	})
+}
+
+// MarshalText implements encoding.TextMarshaler.
+//
+// It returns the goroutines in the same format as the Go runtime, as rendered
+// by a zero TextRenderer. The output is deterministic.
+//
+// encoding/json still uses MarshalJSON, so a Snapshot is not marshaled as a
+// JSON string.
+func (s *Snapshot) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	err := (&TextRenderer{}).Render(&b, s)
+	return b.Bytes(), err
+}
+
+// String returns the same text as MarshalText.
+func (s *Snapshot) String() string {
+	b, _ := s.MarshalText()
+	return string(b)
+}
+
+// String returns the goroutine in the same format as the Go runtime, like
+// TextRenderer does.
+func (g *Goroutine) String() string {
+	var b bytes.Buffer
+	w := bufio.NewWriter(&b)
+	writeGoroutine(w, g, ArgHex, "", nil, textColors{})
+	_ = w.Flush()
+	return b.String()
+}
+
+// Private stuff.
+
+// writeGoroutine writes a goroutine in the same format as the Go runtime,
+// including the program counter offsets.
+//
+// Argument values are printed in format f, except that ArgHex always uses the
+// "0x" prefix. The source paths under home, if not empty, are abbreviated
+// with "~". The source lines around each call are printed when src is not
+// nil. The goroutine header and the function names are highlighted with
+// colors.
+func writeGoroutine(b *bufio.Writer, g *Goroutine, f ArgFormat, home string, src *sourceCache, colors textColors) {
+	fmt.Fprintf(b, "%sgoroutine %d [%s]:%s\n", colors.header, g.ID, g.Signature.header(), colors.reset)
+	for i := range g.Stack.Calls {
+		c := &g.Stack.Calls[i]
+		fmt.Fprintf(b, "%s%s%s(", colors.funcName, c.Func.Complete, colors.reset)
+		elidedAt := -1
+		if c.Args.Elided {
+			elidedAt = len(c.Args.Values) - c.Args.AfterElided
+		}
+		for j, a := range c.Args.Values {
+			if j != 0 {
+				_, _ = b.WriteString(", ")
+			}
+			if j == elidedAt {
+				_, _ = b.WriteString("..., ")
+			}
+			if f == ArgHex && a.Raw == "" {
+				fmt.Fprintf(b, "0x%x", a.Value)
+			} else {
+				a.Name = ""
+				_, _ = b.WriteString(a.Text(f))
+			}
+		}
+		if elidedAt == len(c.Args.Values) {
+			if len(c.Args.Values) != 0 {
+				_, _ = b.WriteString(", ")
+			}
+			_, _ = b.WriteString("...")
+		}
+		fmt.Fprintf(b, ")\n\t%s:%d", abbreviateHome(c.RemoteSrcPath, home), c.Line)
+		writePCOffset(b, c)
+		for _, l := range src.lines(c) {
+			m := " "
+			if l.Current {
+				m = ">"
+			}
+			fmt.Fprintf(b, "\t%s%5d  %s\n", m, l.Line, l.Text)
+		}
+	}
+	if g.Stack.Elided {
+		_, _ = b.WriteString("...additional frames elided...\n")
+	}
+	if len(g.CreatedBy.Calls) != 0 {
+		c := &g.CreatedBy.Calls[0]
+		fmt.Fprintf(b, "created by %s%s%s", colors.funcName, c.Func.Complete, colors.reset)
+		if g.CreatedByGoroutine != 0 {
+			fmt.Fprintf(b, " in goroutine %d", g.CreatedByGoroutine)
+		}
+		fmt.Fprintf(b, "\n\t%s:%d", abbreviateHome(c.RemoteSrcPath, home), c.Line)
+		writePCOffset(b, c)
+	}
+}
+
+// textColors are the escape codes used by writeGoroutine. The zero value
+// disables colors.
+type textColors struct {
+	header   string
+	funcName string
+	reset    string
+}
+
+// writePCOffset terminates the file line of a call, with the program counter
+// offset if it was printed.
+func writePCOffset(b *bufio.Writer, c *Call) {
+	if c.PCOffset != 0 {
+		fmt.Fprintf(b, " +0x%x", c.PCOffset)
+	}
+	_, _ = b.WriteString("\n")
+}
+
+// sourceLine is a line of source code printed along a call.
+type sourceLine struct {
+	// Line is the line number, starting at 1.
+	Line int
+	// Text is the content of the line, without the EOL.
+	Text string
+	// Current is true if it is the line of the call.
+	Current bool
+}
+
+// sourceCache reads the source files to print the lines around calls.
+type sourceCache struct {
+	// context is the number of lines to print before and after the line of the
+	// call.
+	context int
+	// src reads the files like when analyzing the sources, with the resolver
+	// if set.
+	src cacheAST
+	// files are the lines of the files already read, by the path used to read
+	// them. A file that couldn't be read is nil.
+	files map[string][]string
+}
+
+// newSourceCache returns a sourceCache printing context lines around each
+// call.
+func newSourceCache(context int, resolve func(srcPath string, line int) (io.ReadCloser, error)) *sourceCache {
+	return &sourceCache{
+		context: context,
+		src:     cacheAST{resolve: resolve},
+		files:   map[string][]string{},
+	}
+}
+
+// lines returns the source lines around the line of the call.
+//
+// Returns nil if s is nil, the call has no source path to read or the file
+// couldn't be read.
+func (s *sourceCache) lines(c *Call) []sourceLine {
+	if s == nil || c.Line <= 0 {
+		return nil
+	}
+	// Like cacheAST.augmentGoroutine, the resolver is passed the remote path.
+	name := c.LocalSrcPath
+	if s.src.resolve != nil {
+		name = c.RemoteSrcPath
+	}
+	if name == "" {
+		return nil
+	}
+	content, ok := s.files[name]
+	if !ok {
+		if b, err := s.src.readFile(name, c.Line); err == nil {
+			content = strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n")
+		}
+		s.files[name] = content
+	}
+	if c.Line > len(content) {
+		return nil
+	}
+	start := c.Line - s.context
+	if start < 1 {
+		start = 1
+	}
+	end := c.Line + s.context
+	if end > len(content) {
+		end = len(content)
+	}
+	out := make([]sourceLine, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		out = append(out, sourceLine{Line: i, Text: content[i-1], Current: i == c.Line})
+	}
+	return out
+}
+
+// abbreviateHome replaces the home directory prefix of p with "~".
+//
+// p is returned as-is if home is empty or p is not under home.
+func abbreviateHome(p, home string) string {
+	home = strings.TrimSuffix(home, "/")
+	if home == "" {
+		return p
+	}
+	if p == home {
+		return "~"
+	}
+	if strings.HasPrefix(p, home+"/") {
+		return "~" + p[len(home):]
+	}
+	return p
+}
+
+// header returns the state part of the goroutine header, as printed by the Go
+// runtime between brackets.
+//
+// The runtime prints a single wait time, so SleepMax is printed when SleepMin
+// and SleepMax differ, like for a merged Bucket.
+func (s *Signature) header() string {
+	h := s.State
+	if s.SleepMax != 0 {
+		h += fmt.Sprintf(", %d minutes", s.SleepMax)
+	}
+	if s.Locked {
+		h += ", locked to thread"
+	}
+	return h
+}
+
+// jsonSnapshot is the JSON representation of a Snapshot.
+//
+// The names are part of the schema and must not change without incrementing
+// JSONSchemaVersion.
+type jsonSnapshot struct {
+	SchemaVersion     int               `json:"SchemaVersion"`
+	Goroutines        []*Goroutine      `json:"Goroutines"`
+	Signal            *Signal           `json:"Signal"`
+	SkippedGoroutines int               `json:"SkippedGoroutines"`
+	Race              *RaceReport       `json:"Race"`
+	ExitInfo          *ExitInfo         `json:"ExitInfo"`
+	FatalError        *FatalError       `json:"FatalError"`
+	PanicMessage      string            `json:"PanicMessage"`
+	Panics            []*Panic          `json:"Panics"`
+	RemoteGOROOT      string            `json:"RemoteGOROOT"`
+	RemoteGOPATHs     map[string]string `json:"RemoteGOPATHs"`
+}
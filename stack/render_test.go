@@ -0,0 +1,533 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTextRenderer(t *testing.T) {
+	t.Parallel()
+	// The output must be the same as the input.
+	in := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.crash(0x11000000, 0x2, ...)",
+		"\t/gopath/src/foo/main.go:12",
+		"main.main(0x1, ..., 0x9)",
+		"\t/gopath/src/foo/main.go:10 +0x1d",
+		"",
+		"goroutine 6 [GC worker (idle), 10 minutes, locked to thread]:",
+		"runtime.gopark(0x0)",
+		"\t/goroot/src/runtime/proc.go:307 +0xce",
+		"...additional frames elided...",
+		"created by main.main in goroutine 1",
+		"\t/gopath/src/foo/main.go:8 +0x4c",
+		"",
+	}, "\n")
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	b := bytes.Buffer{}
+	if err := Renderers["text"].Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, in, b.String())
+}
+
+func TestSnapshotString(t *testing.T) {
+	t.Parallel()
+	in := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.main(0x1, 0x11000000)",
+		"\t/gopath/src/foo/main.go:10",
+		"",
+		"goroutine 6 [chan receive, 2 minutes]:",
+		"main.worker()",
+		"\t/gopath/src/foo/worker.go:30",
+		"created by main.main in goroutine 1",
+		"\t/gopath/src/foo/main.go:8",
+		"",
+	}, "\n")
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	var _ encoding.TextMarshaler = s
+	compareString(t, in, s.String())
+	compareString(t, in, fmt.Sprintf("%s", s))
+	b, err := s.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, in, string(b))
+	compareString(t, strings.Join(strings.Split(in, "\n")[:4], "\n"), s.Goroutines[0].String())
+	compareString(t, strings.Join(strings.Split(in, "\n")[4:], "\n"), fmt.Sprintf("%v", s.Goroutines[1]))
+	// JSONRenderer renders the fields, not the text.
+	buf := bytes.Buffer{}
+	if err := Renderers["json"].Render(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "{") {
+		t.Fatalf("unexpected JSON: %s", buf.String())
+	}
+}
+
+func TestTextRendererArgFormat(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{
+				Signature: Signature{
+					State: "running",
+					Stack: Stack{
+						Calls: []Call{
+							newCall(
+								"main.main",
+								Args{Values: []Arg{{Value: 0x3}, {Value: 0x20}, {Value: 0xc000012345, IsPtr: true}}},
+								"/gopath/src/foo/main.go",
+								10),
+						},
+					},
+				},
+				ID: 1,
+			},
+		},
+	}
+	b := bytes.Buffer{}
+	if err := (&TextRenderer{ArgFormat: ArgAuto}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	want := "goroutine 1 [running]:\nmain.main(3, 32, 0xc000012345)\n\t/gopath/src/foo/main.go:10\n"
+	compareString(t, want, b.String())
+}
+
+func TestTextRendererAbbreviateHome(t *testing.T) {
+	t.Parallel()
+	home, err := getHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{
+				Signature: Signature{
+					State: "running",
+					Stack: Stack{
+						Calls: []Call{newCall("main.main", Args{}, home+"/src/foo/main.go", 10)},
+					},
+				},
+				ID: 1,
+			},
+		},
+	}
+	b := bytes.Buffer{}
+	if err := (&TextRenderer{AbbreviateHome: true}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "goroutine 1 [running]:\nmain.main()\n\t~/src/foo/main.go:10\n", b.String())
+}
+
+func TestTextRendererSourceContext(t *testing.T) {
+	t.Parallel()
+	s, clean := getSourceContextSnapshot(t)
+	defer clean()
+	b := bytes.Buffer{}
+	if err := (&TextRenderer{SourceContext: 1}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	want := "goroutine 1 [running]:\n" +
+		"main.crash()\n" +
+		"\t/gopath/src/foo/main.go:4\n" +
+		"\t     3  func crash() {\n" +
+		"\t>    4  \tpanic(\"oh no\")\n" +
+		"\t     5  }\n" +
+		"main.main()\n" +
+		"\t/gopath/src/foo/main.go:1\n" +
+		"\t>    1  package main\n" +
+		"\t     2  \n" +
+		"runtime.main()\n" +
+		"\t/goroot/src/runtime/proc.go:250\n"
+	compareString(t, want, b.String())
+}
+
+func TestTextRendererSourceResolver(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{
+				Signature: Signature{
+					State: "running",
+					Stack: Stack{
+						Calls: []Call{newCall("main.crash", Args{}, "/gopath/src/foo/main.go", 4)},
+					},
+				},
+				ID: 1,
+			},
+		},
+	}
+	var got []string
+	resolve := func(srcPath string, line int) (io.ReadCloser, error) {
+		got = append(got, fmt.Sprintf("%s:%d", srcPath, line))
+		return ioutil.NopCloser(strings.NewReader("package main\n\nfunc crash() {\n\tpanic(\"oh no\")\n}\n")), nil
+	}
+	b := bytes.Buffer{}
+	if err := (&TextRenderer{SourceContext: 1, SourceResolver: resolve}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	want := "goroutine 1 [running]:\n" +
+		"main.crash()\n" +
+		"\t/gopath/src/foo/main.go:4\n" +
+		"\t     3  func crash() {\n" +
+		"\t>    4  \tpanic(\"oh no\")\n" +
+		"\t     5  }\n"
+	compareString(t, want, b.String())
+	// The resolver is called with the remote path, like for AnalyzeSources.
+	if diff := cmp.Diff([]string{"/gopath/src/foo/main.go:4"}, got); diff != "" {
+		t.Fatalf("resolver calls mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHTMLRendererSourceContext(t *testing.T) {
+	t.Parallel()
+	s, clean := getSourceContextSnapshot(t)
+	defer clean()
+	b := bytes.Buffer{}
+	if err := (&HTMLRenderer{SourceContext: 1}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	want := "<pre class=\"source\"><span>    3  func crash() {</span>\n<span class=\"current\">    4  \tpanic(&#34;oh no&#34;)</span>\n<span>    5  }</span>\n</pre>"
+	if !strings.Contains(b.String(), want) {
+		t.Fatalf("expected source in:\n%s", b.String())
+	}
+	b.Reset()
+	if err := (&HTMLRenderer{}).Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "<pre class=\"source\">") {
+		t.Fatal("unexpected source")
+	}
+}
+
+func TestAbbreviateHome(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		p, home, want string
+	}{
+		{"/home/joe/src/main.go", "/home/joe", "~/src/main.go"},
+		{"/home/joe/src/main.go", "/home/joe/", "~/src/main.go"},
+		{"/home/joe", "/home/joe", "~"},
+		{"/home/joey/src/main.go", "/home/joe", "/home/joey/src/main.go"},
+		{"/home/joe/src/main.go", "", "/home/joe/src/main.go"},
+		{"/home/joe/src/main.go", "/", "/home/joe/src/main.go"},
+		{"C:/Users/joe/src/main.go", "C:/Users/joe", "~/src/main.go"},
+	}
+	for i, line := range data {
+		if got := abbreviateHome(line.p, line.home); got != line.want {
+			t.Errorf("#%d: abbreviateHome(%q, %q) = %q; want %q", i, line.p, line.home, got, line.want)
+		}
+	}
+}
+
+func TestHTMLRenderer(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{Goroutines: []*Goroutine{{Signature: Signature{State: "running"}, ID: 1}}}
+	b := bytes.Buffer{}
+	r := &HTMLRenderer{Footer: "<p>footer</p>"}
+	if err := r.Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "<!DOCTYPE html>") {
+		t.Fatal("expected HTML")
+	}
+	if !strings.Contains(b.String(), "<p>footer</p>") {
+		t.Fatal("expected footer")
+	}
+}
+
+func TestJSONRenderer(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{Goroutines: []*Goroutine{{Signature: Signature{State: "running"}, ID: 1}}}
+	b := bytes.Buffer{}
+	if err := Renderers["json"].Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	got := &Snapshot{}
+	if err := json.Unmarshal(b.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(s, got); diff != "" {
+		t.Fatalf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONLRenderer(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.worker()",
+		"\t/gopath/src/foo/worker.go:30",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	b := bytes.Buffer{}
+	if err := Renderers["jsonl"].Render(&b, s); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != len(s.Goroutines) {
+		t.Fatalf("expected %d lines, got %q", len(s.Goroutines), b.String())
+	}
+	for i, l := range lines {
+		got := &Goroutine{}
+		if err := json.Unmarshal([]byte(l), got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(s.Goroutines[i], got); diff != "" {
+			t.Fatalf("mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestColorRenderer(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 6 [chan receive]:",
+		"main.worker(0x1)",
+		"\t/gopath/src/foo/worker.go:30 +0x1d",
+		"created by main.main in goroutine 1",
+		"\t/gopath/src/foo/main.go:8 +0x4c",
+		"",
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, &Opts{})
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	data := []struct {
+		name string
+		r    *ColorRenderer
+		want []string
+	}{
+		{
+			name: "Default",
+			r:    &ColorRenderer{},
+			want: []string{
+				"\033[35;1mgoroutine 6 [chan receive]:\033[0m",
+				"\033[33;1mmain.worker\033[0m(0x1)",
+				"\t/gopath/src/foo/worker.go:30 +0x1d",
+				"created by \033[33;1mmain.main\033[0m in goroutine 1",
+				"\t/gopath/src/foo/main.go:8 +0x4c",
+				"",
+			},
+		},
+		{
+			name: "Custom",
+			r:    &ColorRenderer{Header: "<h>", Func: "<f>", Reset: "</>"},
+			want: []string{
+				"<h>goroutine 6 [chan receive]:</>",
+				"<f>main.worker</>(0x1)",
+				"\t/gopath/src/foo/worker.go:30 +0x1d",
+				"created by <f>main.main</> in goroutine 1",
+				"\t/gopath/src/foo/main.go:8 +0x4c",
+				"",
+			},
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			b := bytes.Buffer{}
+			if err := line.r.Render(&b, s); err != nil {
+				t.Fatal(err)
+			}
+			compareString(t, strings.Join(line.want, "\n"), b.String())
+		})
+	}
+	if _, ok := Renderers["color"].(*ColorRenderer); !ok {
+		t.Fatal("expected the color renderer to be registered")
+	}
+}
+
+func TestSnapshotMarshalJSON(t *testing.T) {
+	t.Parallel()
+	s := &Snapshot{
+		Goroutines:   []*Goroutine{{Signature: Signature{State: "running"}, ID: 1}},
+		LocalGOROOT:  "/goroot",
+		LocalGOPATHs: []string{"/gopath"},
+		RemoteGOROOT: "/remote/goroot",
+		LocalGomods:  map[string]string{"/src/foo": "example.com/foo"},
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if v := got["SchemaVersion"]; v != float64(JSONSchemaVersion) {
+		t.Fatalf("unexpected SchemaVersion %v", v)
+	}
+	for _, k := range []string{"Goroutines", "RemoteGOROOT", "RemoteGOPATHs"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing %q", k)
+		}
+	}
+	// The paths on the host running the parser are not part of the schema.
+	for _, k := range []string{"LocalGOROOT", "LocalGOPATHs", "LocalGomods"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("unexpected %q", k)
+		}
+	}
+	if !strings.HasPrefix(string(b), `{"SchemaVersion":1,`) {
+		t.Fatalf("unexpected JSON: %s", b)
+	}
+	// The renderer uses the same representation.
+	buf := bytes.Buffer{}
+	if err := (&JSONRenderer{}).Render(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, string(b)+"\n", buf.String())
+}
+
+func TestSnapshotMarshalJSONGolden(t *testing.T) {
+	t.Parallel()
+	// Locks the field names of the JSON schema. When this test fails because a
+	// field was renamed or removed, JSONSchemaVersion must be incremented and
+	// the golden file updated.
+	in := strings.Join([]string{
+		"panic: boom",
+		"",
+		"goroutine 1 [running]:",
+		"main.crash(0x11000000, {0x4b2e7a, 0x4})",
+		"\t/gopath/src/foo/main.go:12 +0x1d",
+		"main.main()",
+		"\t/gopath/src/foo/main.go:10 +0x25",
+		"",
+		"goroutine 6 [chan receive, 2 minutes, locked to thread]:",
+		"main.worker(...)",
+		"\t/gopath/src/foo/worker.go:30",
+		"created by main.main in goroutine 1",
+		"\t/gopath/src/foo/main.go:8 +0x4c",
+		"exit status 2",
+		"",
+	}, "\n")
+	s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+	compareErr(t, nil, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	s.LocalGOROOT = "/goroot"
+	s.LocalGOPATHs = []string{"/gopath"}
+	buf := bytes.Buffer{}
+	if err := (&JSONRenderer{Indent: "  "}).Render(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, string(want), buf.String())
+}
+
+func TestSignatureJSONHeader(t *testing.T) {
+	t.Parallel()
+	// The header must be rebuilt from the JSON representation of the Signature.
+	data := []string{
+		"running",
+		"running, locked to thread",
+		"chan receive, 5 minutes",
+		"chan receive (nil chan), 5 minutes, locked to thread",
+		"GC worker (idle)",
+		"GC worker (idle), 10 minutes, locked to thread",
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line), func(t *testing.T) {
+			t.Parallel()
+			in := "goroutine 1 [" + line + "]:\nmain.main()\n\t/gopath/src/foo/main.go:12 +0x1\n"
+			s, _, err := ScanSnapshot(bytes.NewBufferString(in), ioutil.Discard, &Opts{})
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			b, err := json.Marshal(&s.Goroutines[0].Signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Signature{}
+			if err = json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			compareString(t, line, got.header())
+		})
+	}
+}
+
+func TestSignatureHeaderRange(t *testing.T) {
+	t.Parallel()
+	s := Signature{State: "chan receive", SleepMin: 2, SleepMax: 5, Locked: true}
+	compareString(t, "chan receive, 5 minutes, locked to thread", s.header())
+}
+
+// getSourceContextSnapshot returns a snapshot with calls in a local source
+// file. The last call has no local path.
+func getSourceContextSnapshot(t *testing.T) (*Snapshot, func()) {
+	root, err := ioutil.TempDir("", "stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean := func() {
+		if err := os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}
+	p := filepath.Join(root, "main.go")
+	if err := ioutil.WriteFile(p, []byte("package main\n\nfunc crash() {\n\tpanic(\"oh no\")\n}\n"), 0600); err != nil {
+		clean()
+		t.Fatal(err)
+	}
+	c1 := newCall("main.crash", Args{}, "/gopath/src/foo/main.go", 4)
+	c1.LocalSrcPath = p
+	c2 := newCall("main.main", Args{}, "/gopath/src/foo/main.go", 1)
+	c2.LocalSrcPath = p
+	s := &Snapshot{
+		Goroutines: []*Goroutine{
+			{
+				Signature: Signature{
+					State: "running",
+					Stack: Stack{
+						Calls: []Call{c1, c2, newCall("runtime.main", Args{}, "/goroot/src/runtime/proc.go", 250)},
+					},
+				},
+				ID: 1,
+			},
+		},
+	}
+	return s, clean
+}
@@ -13,6 +13,7 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io"
 	"io/ioutil"
 	"math"
 	"strings"
@@ -24,6 +25,9 @@ import (
 type cacheAST struct {
 	files  map[string][]byte
 	parsed map[string]*parsedFile
+	// resolve is Opts.SourceResolver. When set, files are keyed by their
+	// RemoteSrcPath instead of LocalSrcPath.
+	resolve func(srcPath string, line int) (io.ReadCloser, error)
 }
 
 // augmentGoroutine processes source files to improve call to be more
@@ -34,14 +38,18 @@ func (c *cacheAST) augmentGoroutine(g *Goroutine) error {
 	var err error
 	for i, call := range g.Stack.Calls {
 		// Only load the AST if there's an argument to process.
-		if len(call.Args.Values) == 0 {
+		if len(call.Args.Values) == 0 || call.IsUnknownLocation() {
 			continue
 		}
-		if err1 := c.loadFile(g.Stack.Calls[i].LocalSrcPath); err1 != nil {
+		src := call.LocalSrcPath
+		if c.resolve != nil {
+			src = call.RemoteSrcPath
+		}
+		if err1 := c.loadFile(src, call.Line); err1 != nil {
 			//log.Printf("%s", err)
 			err = err1
 		}
-		if p := c.parsed[call.LocalSrcPath]; p != nil {
+		if p := c.parsed[src]; p != nil {
 			f, err1 := p.getFuncAST(call.Func.Name, call.Line)
 			if err1 != nil {
 				err = err1
@@ -56,7 +64,9 @@ func (c *cacheAST) augmentGoroutine(g *Goroutine) error {
 }
 
 // loadFile loads a Go source file and parses the AST tree.
-func (c *cacheAST) loadFile(fileName string) error {
+//
+// line is the line referenced in the stack trace, passed to the resolver.
+func (c *cacheAST) loadFile(fileName string, line int) error {
 	if fileName == "" {
 		return nil
 	}
@@ -70,7 +80,7 @@ func (c *cacheAST) loadFile(fileName string) error {
 		// Ignore C and assembly.
 		return fmt.Errorf("cannot load non-go file %q", fileName)
 	}
-	src, err := ioutil.ReadFile(fileName)
+	src, err := c.readFile(fileName, line)
 	if err != nil {
 		return err
 	}
@@ -87,6 +97,23 @@ func (c *cacheAST) loadFile(fileName string) error {
 	return nil
 }
 
+// readFile reads a source file, either via the resolver if set or from the
+// local disk.
+func (c *cacheAST) readFile(fileName string, line int) ([]byte, error) {
+	if c.resolve == nil {
+		return ioutil.ReadFile(fileName)
+	}
+	r, err := c.resolve(fileName, line)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ioutil.ReadAll(r)
+	if err1 := r.Close(); err == nil {
+		err = err1
+	}
+	return src, err
+}
+
 // lineToByteOffsets extract the line number into raw file offset.
 //
 // Inserts a dummy 0 at offset 0 so line offsets can be 1 based.
@@ -228,7 +255,15 @@ func extractArgumentsType(f *ast.FuncDecl) ([]string, bool) {
 }
 
 // augmentCall walks the function and populate call accordingly.
+//
+// The call is left as is when an argument is not a number, e.g. a struct
+// printed as "{}", since the words can't be mapped to the declared types.
 func augmentCall(call *Call, f *ast.FuncDecl) {
+	for i := range call.Args.Values {
+		if call.Args.Values[i].Raw != "" {
+			return
+		}
+	}
 	values := make([]uint64, len(call.Args.Values))
 	for i := range call.Args.Values {
 		values[i] = call.Args.Values[i].Value
@@ -7,6 +7,7 @@ package stack
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -582,7 +583,7 @@ func testAugmentCommon(t *testing.T, content []byte, mayBeInlined bool, want Sta
 		t.Error("expected success")
 	}
 
-	if err := s.augment(); err != nil {
+	if err := s.augment(nil); err != nil {
 		t.Errorf("augment() returned %v", err)
 	}
 	got := s.Goroutines[0].Signature.Stack
@@ -717,7 +718,7 @@ func TestAugmentErr(t *testing.T) {
 						{LocalSrcPath: filepath.Join(root, line.src), Args: line.args, Line: l},
 					}}}},
 				}}
-			if err := s.augment(); (line.errRe == "") != (err == nil) {
+			if err := s.augment(nil); (line.errRe == "") != (err == nil) {
 				t.Fatalf("want: %q; got:  %q", line.errRe, err)
 			} else if err != nil {
 				if m, err2 := regexp.MatchString(line.errRe, err.Error()); err2 != nil {
@@ -730,6 +731,94 @@ func TestAugmentErr(t *testing.T) {
 	}
 }
 
+func TestAugmentSourceResolver(t *testing.T) {
+	t.Parallel()
+	in := []string{
+		"goroutine 1 [running]:",
+		"main.f(0x2a)",
+		"\t/remote/src/main.go:4 +0x1",
+		"",
+	}
+	type req struct {
+		srcPath string
+		line    int
+	}
+	var got []req
+	opts := &Opts{
+		AnalyzeSources: true,
+		SourceResolver: func(srcPath string, line int) (io.ReadCloser, error) {
+			got = append(got, req{srcPath, line})
+			return ioutil.NopCloser(strings.NewReader("package main\n\nfunc f(i int) {\n\tpanic(i)\n}\n")), nil
+		},
+	}
+	s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, opts)
+	compareErr(t, io.EOF, err)
+	if s == nil {
+		t.Fatal("expected snapshot")
+	}
+	if diff := cmp.Diff([]req{{"/remote/src/main.go", 4}}, got, cmp.AllowUnexported(req{})); diff != "" {
+		t.Fatalf("resolver mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"42"}, s.Goroutines[0].Stack.Calls[0].Args.Processed); diff != "" {
+		t.Fatalf("Processed mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAugmentRaw(t *testing.T) {
+	t.Parallel()
+	src := "package main\n\ntype crashy struct{}\n\nfunc (c crashy) die(f float64) {\n\tpanic(int(f))\n}\n"
+	data := []struct {
+		name string
+		in   []string
+		want []string
+		args string
+	}{
+		{
+			name: "Words",
+			in: []string{
+				"goroutine 1 [running]:",
+				"main.crashy.die(0x4045000000000000)",
+				"\t/remote/src/main.go:6 +0x1",
+				"",
+			},
+			want: []string{"42"},
+			args: "42",
+		},
+		{
+			name: "Raw",
+			in: []string{
+				"goroutine 1 [running]:",
+				"main.crashy.die({}, 0x4045000000000000)",
+				"\t/remote/src/main.go:6 +0x1",
+				"",
+			},
+			args: "{}, 0x4045000000000000",
+		},
+	}
+	for i, line := range data {
+		line := line
+		t.Run(fmt.Sprintf("%d-%s", i, line.name), func(t *testing.T) {
+			t.Parallel()
+			opts := &Opts{
+				AnalyzeSources: true,
+				SourceResolver: func(srcPath string, line int) (io.ReadCloser, error) {
+					return ioutil.NopCloser(strings.NewReader(src)), nil
+				},
+			}
+			s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, opts)
+			compareErr(t, io.EOF, err)
+			if s == nil {
+				t.Fatal("expected snapshot")
+			}
+			args := &s.Goroutines[0].Stack.Calls[0].Args
+			if diff := cmp.Diff(line.want, args.Processed); diff != "" {
+				t.Fatalf("Processed mismatch (-want +got):\n%s", diff)
+			}
+			compareString(t, line.args, args.String())
+		})
+	}
+}
+
 func TestLineToByteOffsets(t *testing.T) {
 	src := "\n\n\n"
 	want := []int{0, 0, 1, 2, 3}
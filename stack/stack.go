@@ -13,7 +13,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -102,6 +104,96 @@ func (f *Func) String() string {
 	return f.Complete
 }
 
+// IsGenerated returns true if the function is a closure or a wrapper
+// generated by the compiler, like "main.main.func1", "main.func·001",
+// "main.main.gowrap1", "main.main.deferwrap1" or "main.main-range1".
+//
+// The number in the name is assigned by the compiler in the order the
+// closures appear in the function, so it can change between compiler
+// versions, for example when Go 1.22 started generating "gowrapN" wrappers
+// for go statements instead of "funcN" closures.
+func (f *Func) IsGenerated() bool {
+	_, ok := normalizeGenerated(f.Name)
+	return ok
+}
+
+// normalized returns Complete with the numbering of compiler generated
+// closures and wrappers removed, so the same function is named the same way
+// across compiler versions.
+//
+// The nesting is kept, e.g. "main.main.func1.2" becomes "main.main.#.#", but
+// the sibling closures of a function, like "main.main.func1" and
+// "main.main.func2", are named the same.
+func (f *Func) normalized() string {
+	n, ok := normalizeGenerated(f.Name)
+	if !ok {
+		return f.Complete
+	}
+	if f.ImportPath == "" {
+		return n
+	}
+	return f.ImportPath + "." + n
+}
+
+// normalizeGenerated replaces each compiler generated closure or wrapper
+// element of the function name with "#", and the number of each
+// range-over-func body, e.g. "main-range1", with "#".
+//
+// Returns false if none was found.
+func normalizeGenerated(name string) (string, bool) {
+	parts := strings.Split(name, ".")
+	found := false
+	for i, p := range parts {
+		// Go 1.23 range-over-func bodies, e.g. "main-range1", which can be
+		// nested, e.g. "main-range1-range2".
+		if r := strings.Split(p, "-range"); len(r) > 1 {
+			for k := 1; k < len(r); k++ {
+				if isDigits(r[k]) {
+					r[k] = "#"
+					found = true
+				}
+			}
+			p = strings.Join(r, "-range")
+		}
+		// Go 1.4 and earlier closures, e.g. "func·001".
+		if j := strings.Index(p, "·"); j != -1 && isDigits(p[j+len("·"):]) {
+			p = p[:j] + "·#"
+			found = true
+		}
+		if isGeneratedElement(p) || i != 0 && parts[i-1] == "#" && isDigits(p) {
+			// A number following a closure is a nested closure, e.g. "func1.1".
+			p = "#"
+			found = true
+		}
+		parts[i] = p
+	}
+	if !found {
+		return name, false
+	}
+	return strings.Join(parts, "."), true
+}
+
+// isGeneratedElement returns true if p is the name of a closure or a wrapper
+// generated by the compiler, e.g. "func1", "gowrap1" or "deferwrap1".
+func isGeneratedElement(p string) bool {
+	for _, prefix := range []string{"func", "gowrap", "deferwrap"} {
+		if strings.HasPrefix(p, prefix) && isDigits(p[len(prefix):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDigits returns true if s is non-empty and only contains ASCII digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
 // Arg is an argument on a Call.
 type Arg struct {
 	// Value is the raw value as found in the stack trace
@@ -111,21 +203,56 @@ type Arg struct {
 	// IsPtr is true if we guess it's a pointer. It's only a guess, it can be
 	// easily be confused by a bitmask.
 	IsPtr bool
+	// Raw is the argument as found in the stack trace when it is not a number,
+	// like a quoted string or a struct literal in braces as printed by some
+	// custom dumpers. Value is 0 then.
+	Raw string
 
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
 }
 
+// ArgFormat is how the value of an Arg is printed.
+type ArgFormat int
+
+const (
+	// ArgHex prints the values in hexadecimal, except for 0 to 9.
+	ArgHex ArgFormat = iota
+	// ArgAuto prints the values below DecimalCeiling that are not guessed to
+	// be pointers in decimal, and the others in hexadecimal.
+	//
+	// It is more readable for values that are clearly counts or indices.
+	ArgAuto
+	// ArgDecimal prints the values that are not guessed to be pointers in
+	// decimal, and the pointers in hexadecimal.
+	ArgDecimal
+)
+
+// DecimalCeiling is the value up to which ArgAuto prints in decimal.
+const DecimalCeiling = 0x10000
+
 const zeroToNine = "0123456789"
 
 // String prints the argument as the name if present, otherwise as the value.
 func (a *Arg) String() string {
+	return a.Text(ArgHex)
+}
+
+// Text prints the argument as the name if present, otherwise as the value in
+// format f.
+func (a *Arg) Text(f ArgFormat) string {
 	if a.Name != "" {
 		return a.Name
 	}
+	if a.Raw != "" {
+		return a.Raw
+	}
 	if a.Value < uint64(len(zeroToNine)) {
 		return zeroToNine[a.Value : a.Value+1]
 	}
+	if !a.IsPtr && (f == ArgDecimal || (f == ArgAuto && a.Value < DecimalCeiling)) {
+		return strconv.FormatUint(a.Value, 10)
+	}
 	return fmt.Sprintf("0x%x", a.Value)
 }
 
@@ -144,7 +271,7 @@ const (
 
 // similar returns true if the two Arg are equal or almost but not quite equal.
 func (a *Arg) similar(r *Arg, similar Similarity) bool {
-	switch similar {
+	switch similar & similarityLevels {
 	case ExactFlags, ExactLines:
 		return *a == *r
 	case AnyValue:
@@ -153,7 +280,7 @@ func (a *Arg) similar(r *Arg, similar Similarity) bool {
 		if a.IsPtr != r.IsPtr {
 			return false
 		}
-		return a.IsPtr || a.Value == r.Value
+		return a.IsPtr || (a.Value == r.Value && a.Raw == r.Raw)
 	default:
 		return false
 	}
@@ -169,30 +296,47 @@ type Args struct {
 	Processed []string
 	// Elided when set means there was a trailing ", ...".
 	Elided bool
+	// AfterElided is the number of values in Values that were printed after the
+	// "...", when the values were elided in the middle of the list, like
+	// "0x1, ..., 0x9". It is 0 when the "..." is trailing.
+	AfterElided int
+	// Truncated is set when more values than Opts.MaxArgs were printed. The
+	// values past it were dropped and Elided is also set.
+	Truncated bool
 
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
 }
 
 func (a *Args) String() string {
+	return a.Text(ArgHex)
+}
+
+// Text is like String but prints the values in format f.
+//
+// Processed is used as-is when present.
+func (a *Args) Text(f ArgFormat) string {
 	var v []string
 	if len(a.Processed) != 0 {
-		v = a.Processed
+		v = make([]string, 0, len(a.Processed)+1)
+		v = append(v, a.Processed...)
 	} else {
-		v = make([]string, 0, len(a.Values))
+		v = make([]string, 0, len(a.Values)+1)
 		for _, item := range a.Values {
-			v = append(v, item.String())
+			v = append(v, item.Text(f))
 		}
 	}
-	if a.Elided {
-		v = append(v, "...")
+	if i := a.elidedAt(); i != -1 {
+		v = append(v, "")
+		copy(v[i+1:], v[i:])
+		v[i] = "..."
 	}
 	return strings.Join(v, ", ")
 }
 
 // equal returns true only if both arguments are exactly equal.
 func (a *Args) equal(r *Args) bool {
-	if a.Elided != r.Elided || len(a.Values) != len(r.Values) {
+	if a.Elided != r.Elided || a.AfterElided != r.AfterElided || a.Truncated != r.Truncated || len(a.Values) != len(r.Values) {
 		return false
 	}
 	for i, l := range a.Values {
@@ -206,11 +350,28 @@ func (a *Args) equal(r *Args) bool {
 // similar returns true if the two Args are equal or almost but not quite
 // equal.
 func (a *Args) similar(r *Args, similar Similarity) bool {
-	if a.Elided != r.Elided || len(a.Values) != len(r.Values) {
+	if a.Elided == r.Elided && a.AfterElided == r.AfterElided && len(a.Values) == len(r.Values) {
+		for i := range a.Values {
+			if !a.Values[i].similar(&r.Values[i], similar) {
+				return false
+			}
+		}
+		return true
+	}
+	// The elision differs. With AnyPointer and AnyValue, accept it when the
+	// shorter list is elided at its end and is a prefix of the other one.
+	if similar&similarityLevels < AnyPointer || a.AfterElided != 0 || r.AfterElided != 0 {
 		return false
 	}
-	for i := range a.Values {
-		if !a.Values[i].similar(&r.Values[i], similar) {
+	short, long := a, r
+	if len(short.Values) > len(long.Values) {
+		short, long = long, short
+	}
+	if !short.Elided {
+		return false
+	}
+	for i := range short.Values {
+		if !short.Values[i].similar(&long.Values[i], similar) {
 			return false
 		}
 	}
@@ -218,13 +379,23 @@ func (a *Args) similar(r *Args, similar Similarity) bool {
 }
 
 // merge merges two similar Args, zapping out differences.
+//
+// When the elision differs, the more complete list of arguments is kept and
+// only the values present in both are compared.
 func (a *Args) merge(r *Args) Args {
+	// Prefer the more complete list of arguments.
+	long, short := a, r
+	if len(r.Values) > len(a.Values) || (len(r.Values) == len(a.Values) && a.Elided && !r.Elided) {
+		long, short = r, a
+	}
 	out := Args{
-		Values: make([]Arg, len(a.Values)),
-		Elided: a.Elided,
+		Values:      make([]Arg, len(long.Values)),
+		Elided:      long.Elided,
+		AfterElided: long.AfterElided,
+		Truncated:   long.Truncated,
 	}
-	for i, l := range a.Values {
-		if l != r.Values[i] {
+	for i, l := range long.Values {
+		if i < len(short.Values) && l != short.Values[i] {
 			out.Values[i].Name = "*"
 			out.Values[i].Value = l.Value
 			out.Values[i].IsPtr = l.IsPtr
@@ -276,6 +447,10 @@ type Call struct {
 	RemoteSrcPath string
 	// Line is the line number.
 	Line int
+	// PCOffset is the offset of the program counter in the function, as
+	// printed after the line number, e.g. "+0x1d". It is 0 when it was not
+	// printed, like for inlined calls.
+	PCOffset uint64
 	// SrcName is the base file name of the source file.
 	SrcName string
 	// DirSrc is one directory plus the file name of the source file. It is a
@@ -327,6 +502,50 @@ func (c *Call) init(srcPath string, line int) {
 
 const testMainSrc = "_test" + string(os.PathSeparator) + "_testmain.go"
 
+// IsUnknownLocation returns true if the source file of the call is unknown.
+//
+// The runtime prints "??:0" for frames without debug information, like cgo
+// frames in a C library built without symbols, e.g. musl on Alpine.
+func (c *Call) IsUnknownLocation() bool {
+	return c.RemoteSrcPath == SrcUnknown
+}
+
+// StackUnavailable returns true if the call is the placeholder for a stack
+// that the runtime couldn't print, as "goroutine running on other thread;
+// stack unavailable", or that the race detector couldn't restore, as
+// "[failed to restore the stack]".
+func (c *Call) StackUnavailable() bool {
+	return c.RemoteSrcPath == SrcUnavailable
+}
+
+// IsAutogenerated returns true if the call is in a wrapper generated by the
+// compiler, like a method value or an embedded interface method.
+func (c *Call) IsAutogenerated() bool {
+	return c.RemoteSrcPath == SrcAutogenerated
+}
+
+// IsCollapsed returns true if the call is the placeholder for a run of
+// frames removed by Goroutine.TrimExternal().
+func (c *Call) IsCollapsed() bool {
+	return c.RemoteSrcPath == SrcCollapsed
+}
+
+// Special values of Call.RemoteSrcPath.
+const (
+	// SrcUnknown is the source file printed by the runtime when it is unknown,
+	// see Call.IsUnknownLocation().
+	SrcUnknown = "??"
+	// SrcUnavailable is the source file of the placeholder call used when the
+	// stack is unavailable, see Call.StackUnavailable().
+	SrcUnavailable = "<unavailable>"
+	// SrcAutogenerated is the source file printed by the runtime for the
+	// wrappers generated by the compiler, see Call.IsAutogenerated().
+	SrcAutogenerated = "<autogenerated>"
+	// SrcCollapsed is the source file of the placeholder call for frames
+	// removed by Goroutine.TrimExternal(), see Call.IsCollapsed().
+	SrcCollapsed = "<collapsed>"
+)
+
 // updateLocations initializes LocalSrcPath, RelSrcPath, Location and ImportPath.
 //
 // goroot, localgoroot, localgomod, gomodImportPath and gopaths are expected to
@@ -340,7 +559,7 @@ func (c *Call) updateLocations(goroot, localgoroot string, localgomods, gopaths
 	}
 	// Check GOROOT first.
 	if goroot != "" {
-		if prefix := goroot + "/src/"; strings.HasPrefix(c.RemoteSrcPath, prefix) {
+		if prefix := goroot + "/src/"; hasPathPrefix(c.RemoteSrcPath, prefix) {
 			// Replace remote GOROOT with local GOROOT.
 			c.RelSrcPath = c.RemoteSrcPath[len(prefix):]
 			c.LocalSrcPath = pathJoin(localgoroot, "src", c.RelSrcPath)
@@ -356,7 +575,7 @@ func (c *Call) updateLocations(goroot, localgoroot string, localgomods, gopaths
 	// Check GOPATH.
 	// TODO(maruel): Sort for deterministic behavior?
 	for prefix, dest := range gopaths {
-		if p := prefix + "/src/"; strings.HasPrefix(c.RemoteSrcPath, p) {
+		if p := prefix + "/src/"; hasPathPrefix(c.RemoteSrcPath, p) {
 			c.RelSrcPath = c.RemoteSrcPath[len(p):]
 			c.LocalSrcPath = pathJoin(dest, "src", c.RelSrcPath)
 			if i := strings.LastIndexByte(c.RelSrcPath, '/'); i != -1 {
@@ -368,7 +587,7 @@ func (c *Call) updateLocations(goroot, localgoroot string, localgomods, gopaths
 			return true
 		}
 		// For modules, the path has to be altered, as it contains the version.
-		if p := prefix + "/pkg/mod/"; strings.HasPrefix(c.RemoteSrcPath, p) {
+		if p := prefix + "/pkg/mod/"; hasPathPrefix(c.RemoteSrcPath, p) {
 			c.RelSrcPath = c.RemoteSrcPath[len(p):]
 			c.LocalSrcPath = pathJoin(dest, "pkg/mod", c.RelSrcPath)
 			if i := strings.LastIndexByte(c.RelSrcPath, '/'); i != -1 {
@@ -384,7 +603,7 @@ func (c *Call) updateLocations(goroot, localgoroot string, localgomods, gopaths
 	// Go module path detection only works with stack traces created on the local
 	// file system.
 	for prefix, pkg := range localgomods {
-		if strings.HasPrefix(c.RemoteSrcPath, prefix+"/") {
+		if hasPathPrefix(c.RemoteSrcPath, prefix+"/") {
 			c.RelSrcPath = c.RemoteSrcPath[len(prefix)+1:]
 			c.LocalSrcPath = c.RemoteSrcPath
 			if i := strings.LastIndexByte(c.RelSrcPath, '/'); i != -1 {
@@ -410,16 +629,28 @@ func (c *Call) equal(r *Call) bool {
 // similar returns true if the two Call are equal or almost but not quite
 // equal.
 func (c *Call) similar(r *Call, similar Similarity) bool {
-	return c.Line == r.Line && c.Func.Complete == r.Func.Complete && c.RemoteSrcPath == r.RemoteSrcPath && c.Args.similar(&r.Args, similar)
+	if c.Func.Complete != r.Func.Complete && (similar&AnyClosure == 0 || c.Func.normalized() != r.Func.normalized()) {
+		return false
+	}
+	if c.Line != r.Line && similar&AnyLine == 0 {
+		return false
+	}
+	if !similarSrcPath(c.RemoteSrcPath, r.RemoteSrcPath, similar) {
+		return false
+	}
+	return c.Args.similar(&r.Args, similar)
 }
 
 // merge merges two similar Call, zapping out differences.
+//
+// The function, source path and line of the left side are kept.
 func (c *Call) merge(r *Call) Call {
 	return Call{
 		Func:          c.Func,
 		Args:          c.Args.merge(&r.Args),
 		RemoteSrcPath: c.RemoteSrcPath,
 		Line:          c.Line,
+		PCOffset:      c.PCOffset,
 		SrcName:       c.SrcName,
 		DirSrc:        c.DirSrc,
 		LocalSrcPath:  c.LocalSrcPath,
@@ -568,6 +799,12 @@ func (s *Stack) updateLocations(goroot, localgoroot string, localgomods, gopaths
 // It is effectively the stack trace plus the goroutine internal bits, like
 // it's state, if it is thread locked, which call site created this goroutine,
 // etc.
+//
+// The JSON names of the fields are part of the schema versioned by
+// JSONSchemaVersion; they are locked by testdata/snapshot.json. State,
+// SleepMax and Locked are enough to rebuild the bracketed part of the
+// goroutine header, e.g. "[GC worker (idle), 10 minutes, locked to thread]".
+// SleepMin and SleepMax are 0 when no wait time was printed.
 type Signature struct {
 	// State is the goroutine state at the time of the snapshot.
 	//
@@ -576,6 +813,7 @@ type Signature struct {
 	//     - chan send, chan receive, select
 	//     - finalizer wait, mark wait (idle),
 	//     - Concurrent GC wait, GC sweep wait, force gc (idle)
+	//     - GC worker (idle), GC worker (active)
 	//     - IO wait, panicwait
 	//     - semacquire, semarelease
 	//     - sleep, timer goroutine (idle)
@@ -588,29 +826,41 @@ type Signature struct {
 	//    - scan, scanrunnable, scanrunning, scansyscall, scanwaiting, scandead,
 	//      scanenqueue
 	//
+	// The parenthetical qualifier, if any, is kept. It is also parsed into
+	// StateDetail, e.g. "idle" for "GC worker (idle)".
+	//
 	// When running under the race detector, the values are 'running' or
 	// 'finished'.
-	State string
+	State string `json:"State"`
+	// StateDetail is the parenthetical qualifier at the end of State, if any,
+	// without the parenthesis, e.g. "nil chan" for "chan receive (nil chan)".
+	StateDetail string `json:"StateDetail"`
+	// RawState is the verbatim text between the brackets of the goroutine
+	// header, e.g. "chan receive, 5 minutes, locked to thread".
+	//
+	// It is only set when Opts.KeepRaw is true. It is cleared when Signatures
+	// with different values are merged.
+	RawState string `json:"RawState"`
 	// CreatedBy is the call stack that created this goroutine, if applicable.
 	//
 	// Normally, the stack is a single Call.
 	//
 	// When the race detector is enabled, a full stack snapshot is available.
-	CreatedBy Stack
+	CreatedBy Stack `json:"CreatedBy"`
 	// SleepMin is the wait time in minutes, if applicable.
 	//
 	// Not set when running under the race detector.
-	SleepMin int
+	SleepMin int `json:"SleepMin"`
 	// SleepMax is the wait time in minutes, if applicable.
 	//
 	// Not set when running under the race detector.
-	SleepMax int
+	SleepMax int `json:"SleepMax"`
 	// Stack is the call stack.
-	Stack Stack
+	Stack Stack `json:"Stack"`
 	// Locked is set if the goroutine was locked to an OS thread.
 	//
 	// Not set when running under the race detector.
-	Locked bool
+	Locked bool `json:"Locked"`
 
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
@@ -630,7 +880,7 @@ func (s *Signature) similar(r *Signature, similar Similarity) bool {
 	if s.State != r.State || !s.CreatedBy.similar(&r.CreatedBy, similar) {
 		return false
 	}
-	if similar == ExactFlags && s.Locked != r.Locked {
+	if similar&similarityLevels == ExactFlags && s.Locked != r.Locked {
 		return false
 	}
 	return s.Stack.similar(&r.Stack, similar)
@@ -646,13 +896,19 @@ func (s *Signature) merge(r *Signature) *Signature {
 	if r.SleepMax > max {
 		max = r.SleepMax
 	}
+	rawState := s.RawState
+	if rawState != r.RawState {
+		rawState = ""
+	}
 	return &Signature{
-		State:     s.State,     // Drop right side.
-		CreatedBy: s.CreatedBy, // Drop right side.
-		SleepMin:  min,
-		SleepMax:  max,
-		Stack:     *s.Stack.merge(&r.Stack),
-		Locked:    s.Locked || r.Locked, // TODO(maruel): This is weirdo.
+		State:       s.State,       // Drop right side.
+		StateDetail: s.StateDetail, // Drop right side.
+		RawState:    rawState,
+		CreatedBy:   s.CreatedBy, // Drop right side.
+		SleepMin:    min,
+		SleepMax:    max,
+		Stack:       *s.Stack.merge(&r.Stack),
+		Locked:      s.Locked || r.Locked, // TODO(maruel): This is weirdo.
 	}
 }
 
@@ -673,13 +929,7 @@ func (s *Signature) less(r *Signature) bool {
 	if r.Locked && !s.Locked {
 		return false
 	}
-	if s.State < r.State {
-		return true
-	}
-	if s.State > r.State {
-		return false
-	}
-	return false
+	return s.State < r.State
 }
 
 // SleepString returns a string "N-M minutes" if the goroutine(s) slept for a
@@ -696,6 +946,148 @@ func (s *Signature) SleepString() string {
 	return fmt.Sprintf("%d minutes", s.SleepMax)
 }
 
+// BaseState returns State without its qualifier in StateDetail, e.g.
+// "GC worker" for "GC worker (idle)" or "chan receive" for
+// "chan receive (nil chan)".
+//
+// It is the state as known to LookupStateCategory().
+func (s *Signature) BaseState() string {
+	if s.StateDetail == "" {
+		return s.State
+	}
+	return strings.TrimSuffix(s.State, " ("+s.StateDetail+")")
+}
+
+// CommonPrefix returns the number of calls at the bottom of the stack that are
+// the same in both signatures, ignoring the arguments.
+//
+// Goroutines normally share at least their bottom calls, e.g.
+// runtime.goexit. Returns 0 if either stack was elided, since the bottom of
+// the stack is unknown.
+func (s *Signature) CommonPrefix(r *Signature) int {
+	if s.Stack.Elided || r.Stack.Elided {
+		return 0
+	}
+	i := len(s.Stack.Calls) - 1
+	j := len(r.Stack.Calls) - 1
+	n := 0
+	for ; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		lc := &s.Stack.Calls[i]
+		rc := &r.Stack.Calls[j]
+		if lc.Line != rc.Line || lc.Func.Complete != rc.Func.Complete || lc.RemoteSrcPath != rc.RemoteSrcPath {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// WithoutRuntimeTail returns a copy of the signature without the calls at
+// the bottom of the stack that are in package runtime, like runtime.goexit
+// and runtime.main.
+//
+// The calls are removed from the bottom up to the first call outside package
+// runtime, so runtime calls in the middle of the stack are kept. The stack is
+// left untouched if it only has runtime calls, so the runtime goroutines are
+// still told apart. CreatedBy is left untouched.
+//
+// It is useful to compare or hash the signature based on the user code only.
+func (s *Signature) WithoutRuntimeTail() *Signature {
+	out := *s
+	i := len(s.Stack.Calls)
+	for ; i > 0 && s.Stack.Calls[i-1].Func.ImportPath == "runtime"; i-- {
+	}
+	if i != 0 && i != len(s.Stack.Calls) {
+		out.Stack.Calls = make([]Call, i)
+		copy(out.Stack.Calls, s.Stack.Calls)
+	}
+	return &out
+}
+
+// FrameChange is how a frame changed relative to a baseline stack.
+type FrameChange int
+
+const (
+	// FrameSame means the frame is the same in both stacks.
+	FrameSame FrameChange = iota
+	// FrameAdded means the frame is only in the stack.
+	FrameAdded
+	// FrameRemoved means the frame is only in the baseline stack.
+	FrameRemoved
+	// FrameMoved means the function is in both stacks but the line differs.
+	FrameMoved
+)
+
+// FrameDiff is one frame of the diff returned by Signature.DiffFrames().
+type FrameDiff struct {
+	// Change is how the frame changed.
+	Change FrameChange
+	// Call is the frame in the stack, or in the baseline stack for
+	// FrameRemoved.
+	Call Call
+	// BaselineLine is the line of the frame in the baseline stack for FrameSame
+	// and FrameMoved.
+	BaselineLine int
+
+	// Disallow initialization with unnamed parameters.
+	_ struct{}
+}
+
+// DiffFrames returns the difference of the call stack relative to a baseline
+// one, for example from a known good run, ignoring the arguments.
+//
+// The frames are in the same order as Stack.Calls, with the removed frames
+// interleaved at the position they had in the baseline. The bottom calls
+// shared with the baseline, as counted by CommonPrefix(), are FrameSame. The
+// rest is matched by function and source file so that a function still called
+// from a different line is FrameMoved.
+func (s *Signature) DiffFrames(baseline *Signature) []FrameDiff {
+	n := s.CommonPrefix(baseline)
+	l := s.Stack.Calls[:len(s.Stack.Calls)-n]
+	r := baseline.Stack.Calls[:len(baseline.Stack.Calls)-n]
+	// lcs[i][j] is the length of the longest common subsequence of l[i:] and
+	// r[j:].
+	lcs := make([][]int, len(l)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(r)+1)
+	}
+	for i := len(l) - 1; i >= 0; i-- {
+		for j := len(r) - 1; j >= 0; j-- {
+			if sameFrame(&l[i], &r[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	out := make([]FrameDiff, 0, len(s.Stack.Calls)+len(r)-lcs[0][0])
+	i, j := 0, 0
+	for i < len(l) || j < len(r) {
+		switch {
+		case i < len(l) && j < len(r) && sameFrame(&l[i], &r[j]) && lcs[i][j] == lcs[i+1][j+1]+1:
+			c := FrameSame
+			if l[i].Line != r[j].Line {
+				c = FrameMoved
+			}
+			out = append(out, FrameDiff{Change: c, Call: l[i], BaselineLine: r[j].Line})
+			i++
+			j++
+		case j == len(r) || (i < len(l) && lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, FrameDiff{Change: FrameAdded, Call: l[i]})
+			i++
+		default:
+			out = append(out, FrameDiff{Change: FrameRemoved, Call: r[j]})
+			j++
+		}
+	}
+	for _, c := range s.Stack.Calls[len(l):] {
+		out = append(out, FrameDiff{Change: FrameSame, Call: c, BaselineLine: c.Line})
+	}
+	return out
+}
+
 // updateLocations calls updateLocations on both CreatedBy and Stack and
 // returns true if they were both resolved.
 func (s *Signature) updateLocations(goroot, localgoroot string, localgomods, gopaths map[string]string) bool {
@@ -713,6 +1105,22 @@ type Goroutine struct {
 	ID int
 	// First is the goroutine first printed, normally the one that crashed.
 	First bool
+	// CreatedByGoroutine is the ID of the goroutine that created this one, if
+	// printed. It is only printed since Go 1.21. 0 means unknown.
+	CreatedByGoroutine int
+	// CreatedByInfo is any additional information printed after the creator on
+	// the "created by" line, like a timestamp on some experimental builds.
+	CreatedByInfo string
+	// GP is the address of the runtime g struct of the goroutine, as printed
+	// with "gp=" in the header since Go 1.21 when the runtime throws. 0 means
+	// not printed.
+	GP uint64
+	// MID is the ID of the M, the OS thread, running the goroutine, as printed
+	// with "m=". Only meaningful when MP is not 0.
+	MID int
+	// MP is the address of the runtime m struct running the goroutine, as
+	// printed with "mp=". 0 means not printed or not running on an M.
+	MP uint64
 
 	// RaceWrite is true if a race condition was detected, and this goroutine was
 	// race on a write operation, otherwise it was a read.
@@ -721,12 +1129,33 @@ type Goroutine struct {
 	// Otherwise it is 0.
 	RaceAddr uint64
 
+	// Raw is the original text of the lines that were parsed into this
+	// goroutine, including the line endings. It is only set when Opts.KeepRaw
+	// is true.
+	Raw string
+
 	// Disallow initialization with unnamed parameters.
 	_ struct{}
 }
 
 // Private stuff.
 
+// elidedAt returns the index at which the "..." must be printed, in Processed
+// when set or in Values otherwise, or -1 if no value was elided.
+func (a *Args) elidedAt() int {
+	if !a.Elided {
+		return -1
+	}
+	l := len(a.Values)
+	if len(a.Processed) != 0 {
+		l = len(a.Processed)
+	}
+	if a.AfterElided > l {
+		return 0
+	}
+	return l - a.AfterElided
+}
+
 // nameArguments is a post-processing step where Args are 'named' with numbers.
 func nameArguments(goroutines []*Goroutine) {
 	// Set a name for any pointer occurring more than once.
@@ -793,3 +1222,48 @@ type uint64Slice []uint64
 func (a uint64Slice) Len() int           { return len(a) }
 func (a uint64Slice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a uint64Slice) Less(i, j int) bool { return a[i] < a[j] }
+
+// similarSrcPath returns true if the two source paths are equal or similar
+// per the flags BasenameOnly and AnyModuleVersion.
+func similarSrcPath(l, r string, similar Similarity) bool {
+	if l == r {
+		return true
+	}
+	if similar&BasenameOnly != 0 {
+		return path.Base(l) == path.Base(r)
+	}
+	if similar&AnyModuleVersion != 0 {
+		return stripModuleVersion(l) == stripModuleVersion(r)
+	}
+	return false
+}
+
+// stripModuleVersion removes the module version from a path in the module
+// cache, e.g. "/gopath/pkg/mod/github.com/foo/bar@v1.2.3/baz.go" becomes
+// "/gopath/pkg/mod/github.com/foo/bar/baz.go".
+//
+// p is returned as-is if it is not in the module cache.
+func stripModuleVersion(p string) string {
+	const pkgmod = "/pkg/mod/"
+	i := strings.Index(p, pkgmod)
+	if i == -1 {
+		return p
+	}
+	i += len(pkgmod)
+	j := strings.IndexByte(p[i:], '@')
+	if j == -1 {
+		return p
+	}
+	j += i
+	k := strings.IndexByte(p[j:], '/')
+	if k == -1 {
+		return p[:j]
+	}
+	return p[:j] + p[j+k:]
+}
+
+// sameFrame returns true if both calls are in the same function, ignoring the
+// line and the arguments.
+func sameFrame(l, r *Call) bool {
+	return l.Func.Complete == r.Func.Complete && l.RemoteSrcPath == r.RemoteSrcPath
+}
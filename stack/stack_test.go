@@ -78,6 +78,38 @@ func TestFuncInit(t *testing.T) {
 	}
 }
 
+func TestFunc_IsGenerated(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		raw        string
+		want       bool
+		normalized string
+	}{
+		{"main.main", false, "main.main"},
+		{"main.func1Helper", false, "main.func1Helper"},
+		{"main.(*T).func1", true, "main.(*T).#"},
+		{"main.main.func1", true, "main.main.#"},
+		{"main.main.func12.3", true, "main.main.#.#"},
+		{"main.main.gowrap1", true, "main.main.#"},
+		{"main.main.deferwrap2", true, "main.main.#"},
+		{"main.main-range1", true, "main.main-range#"},
+		{"main.main-range1.func3", true, "main.main-range#.#"},
+		{"main.main-range1-range2", true, "main.main-range#-range#"},
+		{"main.glob..func4", true, "main.glob..#"},
+		{"main.func·001", true, "main.func·#"},
+		{"gopkg.in/yaml%2ev2.(*decoder).unmarshal.func2", true, "gopkg.in/yaml.v2.(*decoder).unmarshal.#"},
+		{"gopkg.in/yaml%2ev2.(*decoder).unmarshal", false, "gopkg.in/yaml.v2.(*decoder).unmarshal"},
+		{"foo", false, "foo"},
+	}
+	for i, line := range data {
+		f := newFunc(line.raw)
+		if got := f.IsGenerated(); got != line.want {
+			t.Errorf("#%d: %q: IsGenerated() = %t", i, line.raw, got)
+		}
+		compareString(t, line.normalized, f.normalized())
+	}
+}
+
 func TestCallPkg(t *testing.T) {
 	t.Parallel()
 	data := []struct {
@@ -228,6 +260,31 @@ func TestCallPkg(t *testing.T) {
 	}
 }
 
+func TestCall_SpecialSrcPath(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		path                                string
+		unknown, unavailable, autogenerated bool
+	}{
+		{"/gopath/src/foo/bar.go", false, false, false},
+		{SrcUnknown, true, false, false},
+		{SrcUnavailable, false, true, false},
+		{SrcAutogenerated, false, false, true},
+	}
+	for i, line := range data {
+		c := newCall("main.main", Args{}, line.path, 1)
+		if got := c.IsUnknownLocation(); got != line.unknown {
+			t.Errorf("#%d: IsUnknownLocation() = %t", i, got)
+		}
+		if got := c.StackUnavailable(); got != line.unavailable {
+			t.Errorf("#%d: StackUnavailable() = %t", i, got)
+		}
+		if got := c.IsAutogenerated(); got != line.autogenerated {
+			t.Errorf("#%d: IsAutogenerated() = %t", i, got)
+		}
+	}
+}
+
 func TestArgs(t *testing.T) {
 	t.Parallel()
 	a := Args{
@@ -249,6 +306,94 @@ func TestArgs(t *testing.T) {
 
 	a = Args{Processed: []string{"yo"}}
 	compareString(t, "yo", a.String())
+
+	a = Args{Values: []Arg{{Value: 0x1}, {Value: 0x2}, {Value: 0x9}}, Elided: true, AfterElided: 1}
+	compareString(t, "1, 2, ..., 9", a.String())
+
+	a = Args{Values: []Arg{{Value: 0x1}, {Value: 0x9}}, Processed: []string{"int = 1", "int = 9"}, Elided: true, AfterElided: 1}
+	compareString(t, "int = 1, ..., int = 9", a.String())
+}
+
+func TestArgs_Text(t *testing.T) {
+	t.Parallel()
+	a := Args{
+		Values: []Arg{
+			{Value: 0x4},
+			{Value: 0x20},
+			{Value: 0xffff},
+			{Value: 0x10000},
+			{Value: 0xc000012345, IsPtr: true},
+			{Value: 0x20, Name: "foo"},
+		},
+		Elided: true,
+	}
+	compareString(t, "4, 0x20, 0xffff, 0x10000, 0xc000012345, foo, ...", a.Text(ArgHex))
+	compareString(t, "4, 32, 65535, 0x10000, 0xc000012345, foo, ...", a.Text(ArgAuto))
+	compareString(t, "4, 32, 65535, 65536, 0xc000012345, foo, ...", a.Text(ArgDecimal))
+}
+
+func TestStripModuleVersion(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		in, want string
+	}{
+		{"/gopath/pkg/mod/github.com/foo/bar@v1.2.3/baz.go", "/gopath/pkg/mod/github.com/foo/bar/baz.go"},
+		{"/gopath/pkg/mod/github.com/foo/bar@v0.0.0-20200724161237-0e2f3a69832c/a/b.go", "/gopath/pkg/mod/github.com/foo/bar/a/b.go"},
+		{"/gopath/pkg/mod/github.com/foo/bar@v1.2.3", "/gopath/pkg/mod/github.com/foo/bar"},
+		{"/gopath/pkg/mod/github.com/foo/bar/baz.go", "/gopath/pkg/mod/github.com/foo/bar/baz.go"},
+		{"/gopath/src/github.com/foo/bar@v1.2.3/baz.go", "/gopath/src/github.com/foo/bar@v1.2.3/baz.go"},
+	}
+	for i, line := range data {
+		if got := stripModuleVersion(line.in); got != line.want {
+			t.Errorf("#%d: stripModuleVersion(%q) = %q; want %q", i, line.in, got, line.want)
+		}
+	}
+}
+
+func TestArgs_SimilarElided(t *testing.T) {
+	t.Parallel()
+	complete := Args{Values: []Arg{{Value: 1}, {Value: 0xc000012345, IsPtr: true}, {Value: 3}}}
+	elided := Args{Values: []Arg{{Value: 1}, {Value: 0xc000054321, IsPtr: true}}, Elided: true}
+	middle := Args{Values: []Arg{{Value: 1}, {Value: 3}}, Elided: true, AfterElided: 1}
+	data := []struct {
+		a, b    *Args
+		similar Similarity
+		want    bool
+	}{
+		{&complete, &elided, ExactFlags, false},
+		{&complete, &elided, ExactLines, false},
+		{&complete, &elided, AnyPointer, true},
+		{&elided, &complete, AnyPointer, true},
+		{&complete, &elided, AnyValue, true},
+		{&complete, &middle, AnyValue, false},
+		{&complete, &Args{Values: []Arg{{Value: 2}}, Elided: true}, AnyPointer, false},
+		{&complete, &Args{Values: []Arg{{Value: 2}}, Elided: true}, AnyValue, true},
+		{&complete, &Args{Values: []Arg{{Value: 1}}}, AnyValue, false},
+	}
+	for i, line := range data {
+		if got := line.a.similar(line.b, line.similar); got != line.want {
+			t.Errorf("#%d: similar(%s, %s, %d) = %t", i, line.a, line.b, line.similar, got)
+		}
+	}
+}
+
+func TestArgs_MergeElided(t *testing.T) {
+	t.Parallel()
+	complete := Args{Values: []Arg{{Value: 1}, {Value: 0xc000012345, IsPtr: true}, {Value: 3}}}
+	elided := Args{Values: []Arg{{Value: 1}, {Value: 0xc000054321, IsPtr: true}}, Elided: true}
+	want := Args{Values: []Arg{{Value: 1}, {Value: 0xc000012345, Name: "*", IsPtr: true}, {Value: 3}}}
+	if diff := cmp.Diff(want, complete.merge(&elided)); diff != "" {
+		t.Errorf("merge() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, elided.merge(&complete)); diff != "" {
+		t.Errorf("merge() mismatch (-want +got):\n%s", diff)
+	}
+	// Same elision.
+	other := Args{Values: []Arg{{Value: 1}, {Value: 0xc000012345, IsPtr: true}}, Elided: true}
+	want = Args{Values: []Arg{{Value: 1}, {Value: 0xc000054321, Name: "*", IsPtr: true}}, Elided: true}
+	if diff := cmp.Diff(want, elided.merge(&other)); diff != "" {
+		t.Errorf("merge() mismatch (-want +got):\n%s", diff)
+	}
 }
 
 func TestSignature(t *testing.T) {
@@ -272,6 +417,12 @@ func TestSignature_Equal(t *testing.T) {
 	if s1.equal(s2) {
 		t.Fatal("inequal")
 	}
+	s2 = getSignature()
+	s2.State += " (nil chan)"
+	s2.StateDetail = "nil chan"
+	if s1.equal(s2) {
+		t.Fatal("inequal")
+	}
 }
 
 func TestSignature_Similar(t *testing.T) {
@@ -287,6 +438,19 @@ func TestSignature_Similar(t *testing.T) {
 	}
 }
 
+func TestSignature_MergeRawState(t *testing.T) {
+	t.Parallel()
+	s1 := getSignature()
+	s1.RawState = "chan receive"
+	s2 := getSignature()
+	s2.RawState = "chan receive"
+	compareString(t, "chan receive", s1.merge(s2).RawState)
+	s2.SleepMin = 5
+	s2.SleepMax = 5
+	s2.RawState = "chan receive, 5 minutes"
+	compareString(t, "", s1.merge(s2).RawState)
+}
+
 func TestSignature_Less(t *testing.T) {
 	t.Parallel()
 	s1 := getSignature()
@@ -305,6 +469,150 @@ func TestSignature_Less(t *testing.T) {
 	}
 }
 
+func TestSignature_CommonPrefix(t *testing.T) {
+	t.Parallel()
+	s1 := getSignature()
+	s2 := getSignature()
+	if got := s1.CommonPrefix(s2); got != 5 {
+		t.Fatalf("want 5, got %d", got)
+	}
+	// Arguments are ignored.
+	s2.Stack.Calls[4].Args = Args{}
+	if got := s1.CommonPrefix(s2); got != 5 {
+		t.Fatalf("want 5, got %d", got)
+	}
+	// Different stack depth.
+	s2.Stack.Calls = s2.Stack.Calls[2:]
+	if got := s1.CommonPrefix(s2); got != 3 {
+		t.Fatalf("want 3, got %d", got)
+	}
+	s2.Stack.Calls[1].Line = 73
+	if got := s1.CommonPrefix(s2); got != 1 {
+		t.Fatalf("want 1, got %d", got)
+	}
+	s2.Stack.Calls[2].Func = newFunc("doOtherStuff")
+	if got := s1.CommonPrefix(s2); got != 0 {
+		t.Fatalf("want 0, got %d", got)
+	}
+	// Elided stacks have an unknown bottom.
+	s2 = getSignature()
+	s2.Stack.Elided = true
+	if got := s1.CommonPrefix(s2); got != 0 {
+		t.Fatalf("want 0, got %d", got)
+	}
+}
+
+func TestSignature_WithoutRuntimeTail(t *testing.T) {
+	t.Parallel()
+	user := []Call{
+		newCall("main.func·001", Args{}, "/gopath/src/foo/main.go", 72),
+		newCall("runtime.gopark", Args{}, "/goroot/src/runtime/proc.go", 306),
+		newCall("main.main", Args{}, "/gopath/src/foo/main.go", 10),
+	}
+	s1 := &Signature{State: "chan receive", Stack: Stack{Calls: append(append([]Call{}, user...),
+		newCall("runtime.main", Args{}, "/goroot/src/runtime/proc.go", 250),
+		newCall("runtime.goexit", Args{}, "/goroot/src/runtime/asm_amd64.s", 1650),
+	)}}
+	s2 := &Signature{State: "chan receive", Stack: Stack{Calls: append(append([]Call{}, user...),
+		newCall("runtime.goexit", Args{}, "/goroot/src/runtime/asm_amd64.s", 1373),
+	)}}
+	if s1.equal(s2) {
+		t.Fatal("expected different signatures")
+	}
+	g1 := s1.WithoutRuntimeTail()
+	g2 := s2.WithoutRuntimeTail()
+	if !g1.equal(g2) {
+		t.Fatal("expected equal signatures")
+	}
+	// The runtime call in the middle of the stack is kept.
+	if diff := cmp.Diff(user, g1.Stack.Calls); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+	// The original is not modified.
+	if len(s1.Stack.Calls) != 5 {
+		t.Fatalf("want 5, got %d", len(s1.Stack.Calls))
+	}
+	// Hashes only focus on the user code.
+	fingerprint := func(g *Signature) string {
+		return (&Snapshot{Goroutines: []*Goroutine{{Signature: *g}}}).Fingerprint()
+	}
+	if fingerprint(s1) == fingerprint(s2) {
+		t.Fatal("expected different fingerprints")
+	}
+	compareString(t, fingerprint(g1), fingerprint(g2))
+
+	// A stack with only runtime calls is kept as is.
+	s3 := &Signature{State: "GC worker (idle)", StateDetail: "idle", Stack: Stack{Calls: []Call{
+		newCall("runtime.gcBgMarkWorker", Args{}, "/goroot/src/runtime/mgc.go", 1239),
+		newCall("runtime.goexit", Args{}, "/goroot/src/runtime/asm_amd64.s", 1650),
+	}}}
+	if diff := cmp.Diff(s3, s3.WithoutRuntimeTail()); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+}
+
+func TestSignature_DiffFrames(t *testing.T) {
+	t.Parallel()
+	baseline := &Signature{
+		Stack: Stack{
+			Calls: []Call{
+				newCall("main.leaf", Args{}, "/gopath/src/foo/main.go", 10),
+				newCall("main.removed", Args{}, "/gopath/src/foo/main.go", 20),
+				newCall("main.moved", Args{}, "/gopath/src/foo/main.go", 30),
+				newCall("main.main", Args{}, "/gopath/src/foo/main.go", 40),
+			},
+		},
+	}
+	s := &Signature{
+		Stack: Stack{
+			Calls: []Call{
+				newCall("main.leaf", Args{Values: []Arg{{Value: 1}}}, "/gopath/src/foo/main.go", 10),
+				newCall("main.added", Args{}, "/gopath/src/foo/main.go", 50),
+				newCall("main.moved", Args{}, "/gopath/src/foo/main.go", 33),
+				newCall("main.main", Args{}, "/gopath/src/foo/main.go", 40),
+			},
+		},
+	}
+	want := []FrameDiff{
+		{Change: FrameSame, Call: s.Stack.Calls[0], BaselineLine: 10},
+		{Change: FrameAdded, Call: s.Stack.Calls[1]},
+		{Change: FrameRemoved, Call: baseline.Stack.Calls[1]},
+		{Change: FrameMoved, Call: s.Stack.Calls[2], BaselineLine: 30},
+		{Change: FrameSame, Call: s.Stack.Calls[3], BaselineLine: 40},
+	}
+	if diff := cmp.Diff(want, s.DiffFrames(baseline)); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+
+	// Identical stacks.
+	got := baseline.DiffFrames(baseline)
+	if len(got) != len(baseline.Stack.Calls) {
+		t.Fatalf("unexpected diff %v", got)
+	}
+	for i, d := range got {
+		if d.Change != FrameSame {
+			t.Fatalf("#%d: unexpected change %s", i, d.Change)
+		}
+	}
+
+	// Only removed frames.
+	s = &Signature{Stack: Stack{Calls: baseline.Stack.Calls[3:]}}
+	got = s.DiffFrames(baseline)
+	changes := make([]FrameChange, len(got))
+	for i, d := range got {
+		changes[i] = d.Change
+	}
+	if diff := cmp.Diff([]FrameChange{FrameRemoved, FrameRemoved, FrameRemoved, FrameSame}, changes); diff != "" {
+		t.Fatalf("-want, +got:\n%s", diff)
+	}
+}
+
+func TestFrameChangeString(t *testing.T) {
+	t.Parallel()
+	compareString(t, "FrameMoved", FrameMoved.String())
+	compareString(t, "FrameChange(100)", FrameChange(100).String())
+}
+
 //
 
 var (
@@ -364,6 +672,13 @@ func newCall(f string, a Args, s string, l int) Call {
 	return c
 }
 
+// newCallOffset is like newCall but also sets the program counter offset.
+func newCallOffset(f string, a Args, s string, l int, pc uint64) Call {
+	c := newCall(f, a, s, l)
+	c.PCOffset = pc
+	return c
+}
+
 func newCallLocal(f string, a Args, s string, l int) Call {
 	c := newCall(f, a, s, l)
 	r := c.updateLocations(goroot, goroot, gomods, gopaths)
@@ -406,6 +721,19 @@ func similarGoroutines(t *testing.T, want, got []*Goroutine) {
 	}
 }
 
+// zapPCOffsets clears the program counter offsets, which depend on the
+// compiler.
+func zapPCOffsets(gs []*Goroutine) {
+	for _, g := range gs {
+		for i := range g.Stack.Calls {
+			g.Stack.Calls[i].PCOffset = 0
+		}
+		for i := range g.CreatedBy.Calls {
+			g.CreatedBy.Calls[i].PCOffset = 0
+		}
+	}
+}
+
 func zapGoroutines(t *testing.T, a, b []*Goroutine) {
 	if len(a) != len(b) {
 		t.Error("different []*Goroutine length")
@@ -440,6 +768,13 @@ func zapSignatures(t *testing.T, a, b *Signature) {
 			b.CreatedBy.Calls[0].Line = 42
 		}
 	}
+	// The offsets depend on the compiler.
+	for i := range a.CreatedBy.Calls {
+		a.CreatedBy.Calls[i].PCOffset = 0
+	}
+	for i := range b.CreatedBy.Calls {
+		b.CreatedBy.Calls[i].PCOffset = 0
+	}
 	zapStacks(t, &a.Stack, &b.Stack)
 }
 
@@ -458,6 +793,9 @@ func zapCalls(t *testing.T, a, b *Call) {
 		a.Line = 42
 		b.Line = 42
 	}
+	// The offsets depend on the compiler.
+	a.PCOffset = 0
+	b.PCOffset = 0
 	zapArgs(t, &a.Args, &b.Args)
 }
 
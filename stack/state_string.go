@@ -17,21 +17,24 @@ func _() {
 	_ = x[gotFileFunc-6]
 	_ = x[gotFileCreated-7]
 	_ = x[gotUnavail-8]
-	_ = x[gotRaceHeader1-9]
-	_ = x[gotRaceHeader2-10]
-	_ = x[gotRaceOperationHeader-11]
-	_ = x[gotRaceOperationFunc-12]
-	_ = x[gotRaceOperationFile-13]
-	_ = x[betweenRaceOperations-14]
-	_ = x[gotRaceGoroutineHeader-15]
-	_ = x[gotRaceGoroutineFunc-16]
-	_ = x[gotRaceGoroutineFile-17]
-	_ = x[betweenRaceGoroutines-18]
+	_ = x[gotSkipped-9]
+	_ = x[gotRaceHeader1-10]
+	_ = x[gotRaceHeader2-11]
+	_ = x[gotRaceOperationHeader-12]
+	_ = x[gotRaceOperationFunc-13]
+	_ = x[gotRaceOperationFile-14]
+	_ = x[betweenRaceOperations-15]
+	_ = x[gotRaceGlobal-16]
+	_ = x[gotRaceGoroutineHeader-17]
+	_ = x[gotRaceGoroutineFunc-18]
+	_ = x[gotRaceGoroutineFile-19]
+	_ = x[betweenRaceGoroutines-20]
+	_ = x[gotRaceFooter-21]
 }
 
-const _state_name = "lookingdonebetweenRoutinegotRoutineHeadergotFuncgotCreatedgotFileFuncgotFileCreatedgotUnavailgotRaceHeader1gotRaceHeader2gotRaceOperationHeadergotRaceOperationFuncgotRaceOperationFilebetweenRaceOperationsgotRaceGoroutineHeadergotRaceGoroutineFuncgotRaceGoroutineFilebetweenRaceGoroutines"
+const _state_name = "lookingdonebetweenRoutinegotRoutineHeadergotFuncgotCreatedgotFileFuncgotFileCreatedgotUnavailgotSkippedgotRaceHeader1gotRaceHeader2gotRaceOperationHeadergotRaceOperationFuncgotRaceOperationFilebetweenRaceOperationsgotRaceGlobalgotRaceGoroutineHeadergotRaceGoroutineFuncgotRaceGoroutineFilebetweenRaceGoroutinesgotRaceFooter"
 
-var _state_index = [...]uint16{0, 7, 11, 25, 41, 48, 58, 69, 83, 93, 107, 121, 143, 163, 183, 204, 226, 246, 266, 287}
+var _state_index = [...]uint16{0, 7, 11, 25, 41, 48, 58, 69, 83, 93, 103, 117, 131, 153, 173, 193, 214, 227, 249, 269, 289, 310, 323}
 
 func (i state) String() string {
 	if i < 0 || i >= state(len(_state_index)-1) {
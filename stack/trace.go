@@ -0,0 +1,126 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// TraceSnapshotDuration is the duration in microseconds that each snapshot
+// spans in the output of WriteTrace().
+const TraceSnapshotDuration = 1000000
+
+// WriteTrace writes the goroutines states over multiple snapshots in the
+// Chrome Trace Event JSON format, which can be loaded in chrome://tracing or
+// https://ui.perfetto.dev.
+//
+// The snapshots must be in chronological order. Since the snapshots do not
+// contain a timestamp, snapshot i spans from i*TraceSnapshotDuration to
+// (i+1)*TraceSnapshotDuration microseconds. nil snapshots are skipped but
+// still take their time span.
+//
+// Each goroutine ID is a track (a "thread") named "goroutine <ID>" in a single
+// process named "goroutines". Each state is a complete event (phase "X")
+// named after the State, like "chan receive (nil chan)".
+// Consecutive snapshots where a goroutine has the same state are merged into
+// a single event, so the duration of an event is the number of snapshots
+// where the state was seen times TraceSnapshotDuration. The wait time and
+// whether the goroutine is locked to a thread are not used to merge events.
+// The "func" argument of an event is the function at the top of the stack in
+// the first snapshot of the event.
+func WriteTrace(w io.Writer, snapshots []*Snapshot) error {
+	var events []*traceEvent
+	last := map[int]*traceEvent{}
+	for i, s := range snapshots {
+		if s == nil {
+			continue
+		}
+		ts := int64(i) * TraceSnapshotDuration
+		for _, g := range s.Goroutines {
+			name := g.State
+			if e := last[g.ID]; e != nil && e.Name == name && e.Ts+e.Dur == ts {
+				e.Dur += TraceSnapshotDuration
+				continue
+			}
+			e := &traceEvent{
+				Name: name,
+				Cat:  "goroutine",
+				Ph:   "X",
+				Ts:   ts,
+				Dur:  TraceSnapshotDuration,
+				Pid:  1,
+				Tid:  g.ID,
+			}
+			if len(g.Stack.Calls) != 0 {
+				e.Args = map[string]interface{}{"func": g.Stack.Calls[0].Func.Complete}
+			}
+			last[g.ID] = e
+			events = append(events, e)
+		}
+	}
+
+	ids := make([]int, 0, len(last))
+	for id := range last {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	out := traceFile{
+		TraceEvents: make([]*traceEvent, 0, 1+2*len(ids)+len(events)),
+		// Snapshots are generally taken seconds apart.
+		DisplayTimeUnit: "ms",
+	}
+	out.TraceEvents = append(out.TraceEvents, &traceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]interface{}{"name": "goroutines"},
+	})
+	for _, id := range ids {
+		out.TraceEvents = append(out.TraceEvents,
+			&traceEvent{
+				Name: "thread_name",
+				Ph:   "M",
+				Pid:  1,
+				Tid:  id,
+				Args: map[string]interface{}{"name": "goroutine " + strconv.Itoa(id)},
+			},
+			&traceEvent{
+				Name: "thread_sort_index",
+				Ph:   "M",
+				Pid:  1,
+				Tid:  id,
+				Args: map[string]interface{}{"sort_index": id},
+			})
+	}
+	out.TraceEvents = append(out.TraceEvents, events...)
+	return json.NewEncoder(w).Encode(&out)
+}
+
+// Private stuff.
+
+// traceFile is the root object of the Chrome Trace Event JSON format.
+type traceFile struct {
+	TraceEvents     []*traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+// traceEvent is an event in the Chrome Trace Event JSON format.
+//
+// See
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+// for the format.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteTrace(t *testing.T) {
+	t.Parallel()
+	// A nil dump is a missing snapshot.
+	dumps := [][]string{
+		{
+			"goroutine 1 [running]:",
+			"main.main()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+			"goroutine 7 [chan receive]:",
+			"main.worker()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+		},
+		{
+			"goroutine 1 [running]:",
+			"main.loop()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+			"goroutine 7 [chan receive (nil chan)]:",
+			"main.worker()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+			"goroutine 8 [IO wait]:",
+			"main.read()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+		},
+		nil,
+		{
+			"goroutine 1 [running]:",
+			"main.loop()",
+			"\t/gopath/src/foo/main.go:12 +0x30",
+			"",
+		},
+	}
+	var snapshots []*Snapshot
+	for _, d := range dumps {
+		if d == nil {
+			snapshots = append(snapshots, nil)
+			continue
+		}
+		s, _, err := ScanSnapshot(bytes.NewBufferString(strings.Join(d, "\n")), ioutil.Discard, &Opts{})
+		compareErr(t, io.EOF, err)
+		if s == nil {
+			t.Fatal("expected snapshot")
+		}
+		snapshots = append(snapshots, s)
+	}
+	b := bytes.Buffer{}
+	if err := WriteTrace(&b, snapshots); err != nil {
+		t.Fatal(err)
+	}
+	got := traceFile{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	const d = TraceSnapshotDuration
+	want := traceFile{
+		TraceEvents: []*traceEvent{
+			{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]interface{}{"name": "goroutines"}},
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 1, Args: map[string]interface{}{"name": "goroutine 1"}},
+			{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: 1, Args: map[string]interface{}{"sort_index": 1.}},
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 7, Args: map[string]interface{}{"name": "goroutine 7"}},
+			{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: 7, Args: map[string]interface{}{"sort_index": 7.}},
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 8, Args: map[string]interface{}{"name": "goroutine 8"}},
+			{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: 8, Args: map[string]interface{}{"sort_index": 8.}},
+			// Merged over two snapshots.
+			{Name: "running", Cat: "goroutine", Ph: "X", Ts: 0, Dur: 2 * d, Pid: 1, Tid: 1, Args: map[string]interface{}{"func": "main.main"}},
+			{Name: "chan receive", Cat: "goroutine", Ph: "X", Ts: 0, Dur: d, Pid: 1, Tid: 7, Args: map[string]interface{}{"func": "main.worker"}},
+			{Name: "chan receive (nil chan)", Cat: "goroutine", Ph: "X", Ts: d, Dur: d, Pid: 1, Tid: 7, Args: map[string]interface{}{"func": "main.worker"}},
+			{Name: "IO wait", Cat: "goroutine", Ph: "X", Ts: d, Dur: d, Pid: 1, Tid: 8, Args: map[string]interface{}{"func": "main.read"}},
+			// Not merged across the missing snapshot.
+			{Name: "running", Cat: "goroutine", Ph: "X", Ts: 3 * d, Dur: d, Pid: 1, Tid: 1, Args: map[string]interface{}{"func": "main.loop"}},
+		},
+		DisplayTimeUnit: "ms",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("WriteTrace mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteTraceEmpty(t *testing.T) {
+	t.Parallel()
+	b := bytes.Buffer{}
+	if err := WriteTrace(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "{\"traceEvents\":[{\"name\":\"process_name\",\"ph\":\"M\",\"ts\":0,\"pid\":1,\"tid\":0,\"args\":{\"name\":\"goroutines\"}}],\"displayTimeUnit\":\"ms\"}\n", b.String())
+}